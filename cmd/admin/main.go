@@ -0,0 +1,313 @@
+// Command admin manages users directly against the database, for when the
+// API is down or there is no admin to call it yet. Changes go through the
+// same service as the API, so they are validated, audited and trigger
+// webhooks the same way.
+//
+// Usage:
+//
+//	admin create-user -phone <phone> -email <email> [-role <role>]
+//	admin set-role <user> <role>
+//	admin reset-password <user>
+//	admin list-users [-deleted]
+//	admin disable [-enable] <user>
+//	admin purge <user>
+//	admin seed [-fake n] [-fake-seed s] [fixture files...]
+//
+// <user> is a user ID or phone number. Passwords are prompted for, or read
+// from the first line of standard input when it isn't a terminal.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"my-project/config"
+	"my-project/internal/audit"
+	"my-project/internal/database"
+	"my-project/internal/events"
+	"my-project/internal/models"
+	"my-project/internal/repository"
+	"my-project/internal/seed"
+	"my-project/internal/services"
+	"my-project/internal/webhooks"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/term"
+)
+
+const usage = `usage: admin <command> [arguments]
+
+commands:
+  create-user -phone <phone> -email <email> [-role <role>]
+  set-role <user> <role>
+  reset-password <user>
+  list-users [-deleted]
+  disable [-enable] <user>
+  purge <user>
+  seed [-fake n] [-fake-seed s] [fixture files...]
+
+<user> is a user ID or phone number.`
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		fail()
+	}
+
+	commands := map[string]func(ctx context.Context, s *services.UserService, args []string) error{
+		"create-user":    createUser,
+		"set-role":       setRole,
+		"reset-password": resetPassword,
+		"list-users":     listUsers,
+		"disable":        disable,
+		"purge":          purge,
+		"seed":           seedUsers,
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fail()
+	}
+
+	ctx, s := setup()
+	if err := command(ctx, s, os.Args[2:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func fail() {
+	fmt.Fprintln(os.Stderr, usage)
+	os.Exit(2)
+}
+
+// setup connects to the database like the server does and returns a
+// context recording the OS user as the actor in the audit log.
+func setup() (context.Context, *services.UserService) {
+	cfg, err := config.Load(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db := database.Connect(cfg)
+
+	bus := events.NewBus()
+	audit.Subscribe(bus, db)
+	webhooks.Subscribe(bus, db)
+
+	actor := "cli"
+	if u, err := user.Current(); err == nil {
+		actor = "cli:" + u.Username
+	}
+	ctx := events.WithMetadata(context.Background(), events.Metadata{Actor: actor, ActorRole: "admin"})
+	return ctx, services.NewUserService(repository.NewGormUserRepository(db), bus)
+}
+
+func createUser(ctx context.Context, s *services.UserService, args []string) error {
+	flags := flag.NewFlagSet("create-user", flag.ExitOnError)
+	phone := flags.String("phone", "", "phone number")
+	email := flags.String("email", "", "email")
+	role := flags.String("role", "user", "role")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		fail()
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	u := models.User{PhoneNumber: *phone, Email: *email, Password: password, Role: *role}
+	if err := s.Create(ctx, &u); err != nil {
+		return err
+	}
+	fmt.Printf("Created user %d (%s) with role %s\n", u.ID, u.PhoneNumber, u.Role)
+	return nil
+}
+
+func setRole(ctx context.Context, s *services.UserService, args []string) error {
+	if len(args) != 2 || args[1] == "" {
+		fail()
+	}
+	u, err := findUser(ctx, s, args[0])
+	if err != nil {
+		return err
+	}
+	if err := s.AssignRole(ctx, &u, args[1]); err != nil {
+		return err
+	}
+	fmt.Printf("User %d (%s) now has role %s\n", u.ID, u.PhoneNumber, u.Role)
+	return nil
+}
+
+func resetPassword(ctx context.Context, s *services.UserService, args []string) error {
+	if len(args) != 1 {
+		fail()
+	}
+	u, err := findUser(ctx, s, args[0])
+	if err != nil {
+		return err
+	}
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	if err := s.Update(ctx, &u, services.UserChanges{Password: &password}); err != nil {
+		return err
+	}
+	fmt.Printf("Reset the password of user %d (%s)\n", u.ID, u.PhoneNumber)
+	return nil
+}
+
+func listUsers(ctx context.Context, s *services.UserService, args []string) error {
+	flags := flag.NewFlagSet("list-users", flag.ExitOnError)
+	deleted := flags.Bool("deleted", false, "list soft-deleted users instead of active ones")
+	flags.Parse(args)
+
+	users, err := s.List(ctx, repository.UserFilter{Deleted: *deleted})
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPHONE NUMBER\tEMAIL\tROLE\tSTATUS")
+	for _, u := range users {
+		status := "active"
+		switch {
+		case u.DeletedAt.Valid:
+			status = "deleted"
+		case u.DisabledAt != nil:
+			status = "disabled"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", u.ID, u.PhoneNumber, u.Email, u.Role, status)
+	}
+	return w.Flush()
+}
+
+func disable(ctx context.Context, s *services.UserService, args []string) error {
+	flags := flag.NewFlagSet("disable", flag.ExitOnError)
+	enable := flags.Bool("enable", false, "enable the user again instead")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fail()
+	}
+
+	u, err := findUser(ctx, s, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := s.SetDisabled(ctx, &u, !*enable); err != nil {
+		return err
+	}
+	if *enable {
+		fmt.Printf("Enabled user %d (%s)\n", u.ID, u.PhoneNumber)
+	} else {
+		fmt.Printf("Disabled user %d (%s)\n", u.ID, u.PhoneNumber)
+	}
+	return nil
+}
+
+// purge erases a user, soft-deleted or not. Soft-deleted users can only be
+// found by ID, since their phone number may have been taken since.
+func purge(ctx context.Context, s *services.UserService, args []string) error {
+	if len(args) != 1 {
+		fail()
+	}
+	var u models.User
+	var err error
+	if id, convErr := strconv.ParseUint(args[0], 10, 0); convErr == nil {
+		u, err = s.GetAny(ctx, uint(id))
+	} else {
+		u, err = s.FindByPhoneNumber(ctx, args[0])
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.Delete(ctx, &u, true); err != nil {
+		return err
+	}
+	fmt.Printf("Purged user %d (%s)\n", u.ID, u.PhoneNumber)
+	return nil
+}
+
+// seedUsers loads fixture files, or the builtin dev fixtures if there are
+// none, and optionally generates fake users for load testing.
+func seedUsers(ctx context.Context, s *services.UserService, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	fake := flags.Int("fake", 0, "also generate this many fake users")
+	fakeSeed := flags.Uint64("fake-seed", 1, "random seed for fake users; reuse it to get the same users")
+	fakePassword := flags.String("fake-password", "password", "password of the fake users")
+	flags.Parse(args)
+
+	var fixtures seed.Fixtures
+	for _, path := range flags.Args() {
+		loaded, err := seed.Load(path)
+		if err != nil {
+			return err
+		}
+		fixtures.Users = append(fixtures.Users, loaded.Users...)
+	}
+	if flags.NArg() == 0 && *fake == 0 {
+		dev, err := seed.Builtin("dev")
+		if err != nil {
+			return err
+		}
+		fixtures = dev
+	}
+	fixtures.Users = append(fixtures.Users, seed.Fake(*fake, *fakeSeed, *fakePassword)...)
+
+	result, err := seed.Apply(ctx, s, fixtures)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Seeded users: %d created, %d updated, %d unchanged\n", result.Created, result.Updated, result.Unchanged)
+	return nil
+}
+
+// findUser returns the active user with the ID or phone number ref.
+func findUser(ctx context.Context, s *services.UserService, ref string) (models.User, error) {
+	if id, err := strconv.ParseUint(ref, 10, 0); err == nil {
+		return s.Get(ctx, uint(id))
+	}
+	return s.FindByPhoneNumber(ctx, ref)
+}
+
+// readPassword prompts for a password twice on a terminal, or reads it
+// from the first line of standard input otherwise.
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", errors.New("no password on standard input")
+		}
+		return nonEmpty(strings.TrimRight(line, "\r\n"))
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Confirm password: ")
+	confirm, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(password) != string(confirm) {
+		return "", errors.New("passwords don't match")
+	}
+	return nonEmpty(string(password))
+}
+
+func nonEmpty(password string) (string, error) {
+	if password == "" {
+		return "", errors.New("password cannot be empty")
+	}
+	return password, nil
+}
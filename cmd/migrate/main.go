@@ -0,0 +1,108 @@
+// Command migrate manages the database schema.
+//
+// Usage:
+//
+//	migrate up              apply all pending migrations
+//	migrate down [n]        roll back the last n migrations (default 1)
+//	migrate status          list migrations and whether they are applied
+//	migrate create <name>   add an empty up/down migration pair per driver
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"my-project/config"
+	"my-project/internal/database"
+	"my-project/internal/migrations"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+
+	switch command, args := os.Args[1], os.Args[2:]; command {
+	case "create":
+		if len(args) != 1 {
+			usage()
+		}
+		for _, driver := range migrations.Drivers {
+			paths, err := migrations.Create(filepath.Join(migrations.Dir, driver), args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, path := range paths {
+				fmt.Println("Created", path)
+			}
+		}
+
+	case "up":
+		done, err := migrator().Up(ctx)
+		for _, m := range done {
+			fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(done) == 0 {
+			fmt.Println("Database is up to date")
+		}
+
+	case "down":
+		steps := 1
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				usage()
+			}
+			steps = n
+		}
+		done, err := migrator().Down(ctx, steps)
+		for _, m := range done {
+			fmt.Printf("Rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	case "status":
+		statuses, err := migrator().Status(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied {
+				applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-40s %s\n", s.Version, s.Name, applied)
+		}
+
+	default:
+		usage()
+	}
+}
+
+func migrator() *migrations.Migrator {
+	cfg, err := config.Load(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.DB.AutoMigrate = false
+	m, err := migrations.New(database.Connect(cfg))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return m
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate up | down [n] | status | create <name>")
+	os.Exit(2)
+}
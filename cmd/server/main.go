@@ -1,15 +1,43 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"my-project/config"
 	_ "my-project/docs" // This line is important for swag
+	"my-project/internal/apierr"
+	"my-project/internal/audit"
 	"my-project/internal/auth"
 	"my-project/internal/database"
+	"my-project/internal/events"
 	"my-project/internal/handlers"
+	"my-project/internal/health"
+	"my-project/internal/i18n"
+	"my-project/internal/logging"
+	"my-project/internal/metrics"
+	"my-project/internal/migrations"
+	"my-project/internal/notify"
+	"my-project/internal/ratelimit"
+	"my-project/internal/repository"
+	"my-project/internal/server"
+	"my-project/internal/services"
+	"my-project/internal/timeout"
+	"my-project/internal/tracing"
+	"my-project/internal/webhooks"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"gorm.io/gorm"
 )
 
 // @title           My Project API
@@ -31,32 +59,144 @@ import (
 // @in                          header
 // @name                        Authorization
 func main() {
-	cfg := config.LoadConfig()
-	database.Connect(cfg)
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		configCommand(os.Args[2:])
+		return
+	}
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+	if _, err := logging.Setup(cfg); err != nil {
+		fatal("Invalid logging configuration", err)
+	}
+	if cfg.Env == config.Dev {
+		slog.Warn("Running in dev mode, insecure settings are allowed")
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+	db := database.Connect(cfg)
 	auth.InitializeJWT(cfg)
+	warnPendingMigrations(db)
+	if err := metrics.InstrumentDB(db, cfg.DB.Name); err != nil {
+		fatal("Failed to instrument database", err)
+	}
+	if err := tracing.InstrumentDB(db); err != nil {
+		fatal("Failed to instrument database", err)
+	}
+
+	bus := events.NewBus()
+	audit.Subscribe(bus, db)
+	webhooks.Subscribe(bus, db)
+	sms, email := notifyProviders(cfg)
+	notify.Subscribe(bus, sms, email)
+	metrics.Subscribe(bus)
+	userService := services.NewUserService(repository.NewGormUserRepository(db), bus)
+	h := handlers.NewHandler(userService, repository.NewGormWebhookRepository(db), db)
+
+	// Background jobs get their own context, so they keep running while
+	// in-flight requests drain and are stopped right after.
+	jobs, stopJobs := context.WithCancel(context.Background())
+	var running sync.WaitGroup
+	runJob := func(job func(ctx context.Context)) {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			job(jobs)
+		}()
+	}
+
+	if cfg.Users.DeletedRetentionDays > 0 {
+		retention := time.Duration(cfg.Users.DeletedRetentionDays) * 24 * time.Hour
+		runJob(func(ctx context.Context) { userService.RunRetentionJob(ctx, retention, time.Hour) })
+	}
+
+	runJob(webhooks.NewDispatcher(db, cfg.Webhooks.MaxAttempts).Run)
+
+	if cfg.Secrets.ReloadInterval > 0 {
+		runJob(func(ctx context.Context) {
+			cfg.WatchSecrets(ctx, cfg.Secrets.ReloadInterval, func(next *config.Config) {
+				auth.InitializeJWT(next)
+				database.SetPassword(next.DB.Password)
+			})
+		})
+	}
 
-	r := gin.Default()
+	r, err := server.NewRouter(cfg)
+	if err != nil {
+		fatal("Invalid trusted proxies", err)
+	}
+	r.Use(tracing.Middleware(), logging.Middleware(), metrics.Middleware(), i18n.Middleware(), apierr.Middleware(), logging.Recovery())
+	r.Use(timeout.Middleware(cfg.HTTP.RequestTimeout, map[string]time.Duration{
+		"/api/v1/users/export": cfg.HTTP.BulkRequestTimeout,
+		"/api/v1/users/import": cfg.HTTP.BulkRequestTimeout,
+		"/api/v1/audit/export": cfg.HTTP.BulkRequestTimeout,
+		"/api/v1/audit/verify": cfg.HTTP.BulkRequestTimeout,
+	}))
+	r.NoRoute(apierr.NotFound)
 
-	r.POST("/signup", handlers.CreateUser)
-	r.POST("/login", handlers.Login)
-	r.POST("/login/sms/request", handlers.RequestSMSCode)
-	r.POST("/login/sms/verify", handlers.VerifySMSCode)
-	r.POST("/login/email/request", handlers.RequestEmailCode)
-	r.POST("/login/email/verify", handlers.VerifyEmailCode)
+	public := r.Group("")
+	if cfg.RateLimit.Requests > 0 {
+		public.Use(ratelimit.New(cfg.RateLimit.Requests, cfg.RateLimit.Window).Middleware())
+	}
+	public.POST("/signup", h.CreateUser)
+	public.POST("/login", h.Login)
+	public.POST("/login/sms/request", h.RequestSMSCode)
+	public.POST("/login/sms/verify", h.VerifySMSCode)
+	public.POST("/login/email/request", h.RequestEmailCode)
+	public.POST("/login/email/verify", h.VerifyEmailCode)
 
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	api := r.Group("/api/v1")
-	api.Use(auth.AuthMiddleware())
+	if cfg.TLS.ClientCAFile != "" {
+		principals, err := auth.ParsePrincipals(cfg.TLS.ClientPrincipals)
+		if err != nil {
+			fatal("Invalid TLS client principals", err)
+		}
+		api.Use(auth.ClientCertMiddleware(principals, cfg.TLS.ClientCertRequired))
+	}
+	api.Use(auth.AuthMiddleware(userService))
 	{
 		users := api.Group("/users")
 		users.Use(auth.RoleAuthMiddleware("admin"))
 		{
-			users.GET("", handlers.GetUsers)
-			users.GET("/:id", handlers.GetUser)
-			users.PUT("/:id", handlers.UpdateUser)
-			users.DELETE("/:id", handlers.DeleteUser)
-			users.PUT("/:id/role", handlers.AssignRole)
+			users.GET("", h.GetUsers)
+			users.GET("/search", h.SearchUsers)
+			users.GET("/export", h.ExportUsers)
+			users.POST("/import", h.ImportUsers)
+			users.GET("/:id", h.GetUser)
+			users.PUT("/:id", h.UpdateUser)
+			users.PATCH("/:id", h.PatchUser)
+			users.DELETE("/:id", h.DeleteUser)
+			users.POST("/:id/restore", h.RestoreUser)
+			users.PUT("/:id/role", h.AssignRole)
+		}
+
+		hooks := api.Group("/webhooks")
+		hooks.Use(auth.RoleAuthMiddleware("admin"))
+		{
+			hooks.POST("", h.CreateWebhook)
+			hooks.GET("", h.GetWebhooks)
+			hooks.GET("/:id", h.GetWebhook)
+			hooks.PUT("/:id", h.UpdateWebhook)
+			hooks.DELETE("/:id", h.DeleteWebhook)
+			hooks.GET("/:id/deliveries", h.GetWebhookDeliveries)
+			hooks.GET("/:id/deliveries/:delivery_id", h.GetWebhookDelivery)
+			hooks.POST("/:id/deliveries/:delivery_id/retry", h.RetryWebhookDelivery)
+		}
+
+		auditLog := api.Group("/audit")
+		auditLog.Use(auth.RoleAuthMiddleware("admin"))
+		{
+			auditLog.GET("", h.ListAuditEvents)
+			auditLog.GET("/export", h.ExportAuditEvents)
+			auditLog.GET("/verify", h.VerifyAuditLog)
 		}
 	}
 
@@ -65,5 +205,216 @@ func main() {
 			"message": "pong",
 		})
 	})
-	r.Run() // listen and serve on 0.0.0.0:8080
+
+	checker := healthChecker(cfg, db, sms, email)
+	r.GET("/healthz", checker.Live)
+	r.GET("/readyz", checker.Ready)
+
+	switch {
+	case cfg.Metrics.Addr != "":
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler(cfg.Metrics.Token))
+		metricsSrv := &http.Server{Addr: cfg.Metrics.Addr, Handler: mux, ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout}
+		runJob(func(ctx context.Context) {
+			if err := server.Run(ctx, metricsSrv, time.Second); err != nil {
+				slog.Error("Metrics server stopped", "error", err)
+			}
+		})
+	case cfg.Metrics.Token != "":
+		r.GET("/metrics", gin.WrapH(metrics.Handler(cfg.Metrics.Token)))
+	default:
+		slog.Warn("Metrics are disabled, set METRICS_ADDR or METRICS_TOKEN to enable them")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	// On a signal, fail readiness first and keep serving for a while, so
+	// load balancers stop routing here before connections are refused.
+	serving, stopServing := context.WithCancel(context.Background())
+	go func() {
+		<-ctx.Done()
+		checker.ShutDown()
+		select {
+		case <-time.After(cfg.HTTP.ShutdownDelay):
+		case <-serving.Done():
+		}
+		stopServing()
+	}()
+	srv := server.New(cfg, r)
+	if cfg.TLS.CertFile != "" {
+		reloader, err := server.NewCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			fatal("Failed to load TLS certificate", err)
+		}
+		if srv.TLSConfig, err = server.NewTLSConfig(cfg, reloader); err != nil {
+			fatal("Invalid TLS configuration", err)
+		}
+		runJob(func(ctx context.Context) { reloader.Watch(ctx, cfg.TLS.ReloadInterval) })
+	}
+	err = server.Run(serving, srv, cfg.HTTP.ShutdownTimeout)
+	stopServing()
+	// A second signal kills the process instead of waiting for the rest.
+	stop()
+	if err != nil {
+		slog.Error("Server stopped", "error", err)
+	}
+
+	// Shut down in dependency order: requests have drained, so stop the
+	// jobs and wait for event subscribers before closing the pool they use.
+	stopJobs()
+	running.Wait()
+	bus.Wait()
+	if err := database.Close(db); err != nil {
+		slog.Error("Failed to close database", "error", err)
+	}
+	flush, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(flush); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+	cancel()
+	slog.Info("Server exited")
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+const configUsage = `usage: config <command> [arguments]
+
+commands:
+  print [-redacted] [-config file] [-<setting> value ...]
+  keygen
+  seal <secrets.yaml>`
+
+// configCommand runs the config commands:
+//
+//   - print prints the configuration the server would run with as YAML,
+//     and reports whether it is valid;
+//   - keygen prints a new key for sealed secrets files;
+//   - seal encrypts a YAML file of secrets with SECRETS_KEY, printing the
+//     file to use as secrets.file.
+func configCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, configUsage)
+		os.Exit(2)
+	}
+	switch command, args := args[0], args[1:]; command {
+	case "print":
+		fs := flag.NewFlagSet("config print", flag.ExitOnError)
+		redacted := fs.Bool("redacted", false, "replace passwords, keys and tokens with REDACTED")
+		cfg, err := config.Parse(fs, args)
+		if err != nil {
+			fatal("Invalid configuration", err)
+		}
+		printed := cfg
+		if *redacted {
+			printed = cfg.Redacted()
+		}
+		if err := printed.Write(os.Stdout); err != nil {
+			fatal("Failed to print configuration", err)
+		}
+		if err := cfg.Validate(); err != nil {
+			fatal("Invalid configuration", err)
+		}
+
+	case "keygen":
+		fmt.Println(config.GenerateKey())
+
+	case "seal":
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, configUsage)
+			os.Exit(2)
+		}
+		key, err := config.SecretsKey()
+		if err != nil {
+			fatal("Missing secrets key", err)
+		}
+		plaintext, err := os.ReadFile(args[0])
+		if err != nil {
+			fatal("Failed to read secrets", err)
+		}
+		os.Stdout.Write(config.Seal(plaintext, key))
+
+	default:
+		fmt.Fprintln(os.Stderr, configUsage)
+		os.Exit(2)
+	}
+}
+
+// warnPendingMigrations logs migrations that haven't been applied with
+// cmd/migrate yet. The server still starts, since the schema may well be
+// compatible.
+func warnPendingMigrations(db *gorm.DB) {
+	migrator, err := migrations.New(db)
+	if err != nil {
+		slog.Error("Failed to load migrations", "error", err)
+		return
+	}
+	pending, err := migrator.Pending(context.Background())
+	if err != nil {
+		slog.Error("Failed to check migrations", "error", err)
+		return
+	}
+	for _, m := range pending {
+		slog.Warn("Migration is pending, run `migrate up`", "version", m.Version, "name", m.Name)
+	}
+}
+
+// notifyProviders returns the SMS and email providers verification codes
+// are sent through, logging the codes for channels that aren't configured.
+func notifyProviders(cfg *config.Config) (sms, email notify.Provider) {
+	sms, email = notify.LogProvider{}, notify.LogProvider{}
+	if cfg.SMS.APIURL != "" {
+		sms = &notify.HTTPSMSProvider{URL: cfg.SMS.APIURL, APIKey: cfg.SMS.APIKey}
+	}
+	if cfg.SMTP.Addr != "" {
+		email = &notify.SMTPProvider{
+			Addr:     cfg.SMTP.Addr,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.SMTP.From,
+		}
+	}
+	return metrics.InstrumentProvider(events.MethodSMS, sms), metrics.InstrumentProvider(events.MethodEmail, email)
+}
+
+// healthChecker checks the database, that its migrations are applied and
+// that the configured providers are reachable.
+func healthChecker(cfg *config.Config, db *gorm.DB, sms, email notify.Provider) *health.Checker {
+	checker := health.NewChecker()
+	checker.Add("database", cfg.HTTP.HealthCheckTimeout, func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	})
+	// The AutoMigrate schema isn't versioned, so there's nothing to check.
+	if !cfg.DB.AutoMigrate {
+		migrator, err := migrations.New(db)
+		checker.Add("migrations", cfg.HTTP.HealthCheckTimeout, func(ctx context.Context) error {
+			if err != nil {
+				return err
+			}
+			pending, err := migrator.Pending(ctx)
+			if err != nil {
+				return err
+			}
+			if len(pending) > 0 {
+				return fmt.Errorf("%d migrations pending", len(pending))
+			}
+			return nil
+		})
+	}
+	if cfg.SMS.APIURL != "" {
+		checker.Add("sms", cfg.HTTP.HealthCheckTimeout, sms.Check)
+	}
+	if cfg.SMTP.Addr != "" {
+		checker.Add("smtp", cfg.HTTP.HealthCheckTimeout, email.Check)
+	}
+	return checker
+}
+
+// fatal logs err and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -1,42 +1,315 @@
+// Package config loads the configuration of the server and the CLIs.
+//
+// Settings are grouped in sections, such as http and db, and read from, in
+// increasing order of precedence:
+//
+//   - their defaults, see Default;
+//   - a YAML or TOML file, named by the -config flag or CONFIG_FILE;
+//   - for secrets, files holding them, see ReloadSecrets;
+//   - environment variables, such as HTTP_ADDR;
+//   - command line flags named after the setting, such as -http.addr.
 package config
 
 import (
 	"fmt"
-	"os"
+	"strings"
+	"time"
 )
 
+// Environments. Outside Dev, Validate refuses the insecure defaults that
+// make local development easy.
+const (
+	Dev        = "dev"
+	Production = "production"
+)
+
+// Database drivers. The names match the GORM dialectors'.
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
+// Defaults that are fine locally but must not be deployed.
+const (
+	devDBPassword = "password"
+	devJWTSecret  = "a-very-secret-key"
+)
+
+// Config is the configuration of the server. Fields are tagged with the
+// key of their setting in files, and the environment variable setting it.
+// Secret settings are redacted when the configuration is printed.
 type Config struct {
-	DBHost     string
-	DBUser     string
-	DBPassword string
-	DBName     string
-	DBPort     string
-	SSLMode    string
-	TimeZone   string
-	JWTSecret  string
+	// Env is Dev or Production.
+	Env string `yaml:"env" env:"APP_ENV"`
+
+	HTTP      HTTPConfig      `yaml:"http"`
+	TLS       TLSConfig       `yaml:"tls"`
+	DB        DBConfig        `yaml:"db"`
+	JWT       JWTConfig       `yaml:"jwt"`
+	SMS       SMSConfig       `yaml:"sms"`
+	SMTP      SMTPConfig      `yaml:"smtp"`
+	RateLimit RateLimitConfig `yaml:"ratelimit"`
+	Log       LogConfig       `yaml:"log"`
+	Metrics   MetricsConfig   `yaml:"metrics"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	Users     UsersConfig     `yaml:"users"`
+	Webhooks  WebhooksConfig  `yaml:"webhooks"`
+	Secrets   SecretsConfig   `yaml:"secrets"`
+
+	secrets *secretSources
 }
 
-func LoadConfig() *Config {
+// HTTPConfig configures the HTTP server.
+type HTTPConfig struct {
+	// Addr is the address the server listens on.
+	Addr string `yaml:"addr" env:"HTTP_ADDR"`
+	// ReadTimeout, ReadHeaderTimeout, WriteTimeout and IdleTimeout bound
+	// how long a client can hold a connection. Exports stream for a while,
+	// so writes get more time than reads by default.
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"HTTP_READ_TIMEOUT"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env:"HTTP_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT"`
+	// RequestTimeout bounds how long a request can run: its database
+	// queries and provider calls are cancelled after that, and it gets a
+	// 504. BulkRequestTimeout replaces it for imports, exports and audit
+	// log verification. Zero means no limit.
+	RequestTimeout     time.Duration `yaml:"request_timeout" env:"HTTP_REQUEST_TIMEOUT"`
+	BulkRequestTimeout time.Duration `yaml:"bulk_request_timeout" env:"HTTP_BULK_REQUEST_TIMEOUT"`
+	// MaxHeaderBytes caps the size of request headers.
+	MaxHeaderBytes int `yaml:"max_header_bytes" env:"HTTP_MAX_HEADER_BYTES"`
+	// ShutdownTimeout is how long in-flight requests get to finish once
+	// the server is asked to stop.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+	// ShutdownDelay is how long the server keeps serving with readiness
+	// failing before it stops accepting connections, so load balancers
+	// notice and drain it first.
+	ShutdownDelay time.Duration `yaml:"shutdown_delay" env:"SHUTDOWN_DELAY"`
+	// HealthCheckTimeout bounds each dependency check of /readyz.
+	HealthCheckTimeout time.Duration `yaml:"health_check_timeout" env:"HEALTH_CHECK_TIMEOUT"`
+	// TrustedProxies is a comma-separated list of the IPs and CIDR ranges
+	// of the reverse proxies in front of the server. Only their
+	// X-Forwarded-For headers are believed, for rate limits and logs.
+	TrustedProxies string `yaml:"trusted_proxies" env:"HTTP_TRUSTED_PROXIES"`
+}
+
+// TLSConfig configures HTTPS and client certificates.
+type TLSConfig struct {
+	// CertFile and KeyFile enable HTTPS when set. The files are polled
+	// every ReloadInterval and reloaded when they change.
+	CertFile       string        `yaml:"cert_file" env:"TLS_CERT_FILE"`
+	KeyFile        string        `yaml:"key_file" env:"TLS_KEY_FILE"`
+	ReloadInterval time.Duration `yaml:"reload_interval" env:"TLS_RELOAD_INTERVAL"`
+	// MinVersion is the oldest TLS version accepted, "1.2" or "1.3".
+	MinVersion string `yaml:"min_version" env:"TLS_MIN_VERSION"`
+	// CipherSuites is a comma-separated list of cipher suite names for
+	// TLS 1.2. Empty means Go's defaults. TLS 1.3 suites aren't configurable.
+	CipherSuites string `yaml:"cipher_suites" env:"TLS_CIPHER_SUITES"`
+	// ClientCAFile enables client certificate (mTLS) authentication on
+	// /api/v1, for certificates signed by these CAs.
+	ClientCAFile string `yaml:"client_ca_file" env:"TLS_CLIENT_CA_FILE"`
+	// ClientCertRequired rejects /api/v1 requests without a client
+	// certificate instead of falling back to JWT authentication.
+	ClientCertRequired bool `yaml:"client_cert_required" env:"TLS_CLIENT_CERT_REQUIRED"`
+	// ClientPrincipals maps certificate subjects to principals, as
+	// "subject=name:role" entries separated by semicolons. The subject is
+	// the certificate's common name or its full distinguished name.
+	ClientPrincipals string `yaml:"client_principals" env:"TLS_CLIENT_PRINCIPALS"`
+}
+
+// DBConfig configures the database connection.
+type DBConfig struct {
+	// Driver is Postgres or SQLite. SQLite runs the server as a single
+	// self-contained binary, for demos and edge deployments; its database
+	// is the file at Name, or ":memory:" for one that is lost on exit, and
+	// the host, login, SSL, time zone and replica settings don't apply.
+	Driver string `yaml:"driver" env:"DB_DRIVER"`
+	// Host is the primary, which serves all writes and most reads.
+	Host     string `yaml:"host" env:"DB_HOST"`
+	Port     string `yaml:"port" env:"DB_PORT"`
+	User     string `yaml:"user" env:"DB_USER"`
+	Password string `yaml:"password" env:"DB_PASSWORD" secret:"true"`
+	Name     string `yaml:"name" env:"DB_NAME"`
+	SSLMode  string `yaml:"sslmode" env:"DB_SSLMODE"`
+	TimeZone string `yaml:"timezone" env:"DB_TIMEZONE"`
+	// AutoMigrate makes the server create the schema with GORM's
+	// AutoMigrate on boot instead of relying on cmd/migrate. It is meant
+	// for development.
+	AutoMigrate bool `yaml:"auto_migrate" env:"DB_AUTO_MIGRATE"`
+	// ReplicaHosts is a comma-separated list of read replicas, as host or
+	// host:port, logged into like the primary. Reads that can be slightly
+	// stale, such as listing users, are spread over them.
+	ReplicaHosts string `yaml:"replica_hosts" env:"DB_REPLICA_HOSTS"`
+	// ConnectTimeout is how long to keep retrying, with backoff, when the
+	// database can't be reached on startup.
+	ConnectTimeout time.Duration `yaml:"connect_timeout" env:"DB_CONNECT_TIMEOUT"`
+	// MaxOpenConns and MaxIdleConns size the connection pool of the
+	// primary and of each replica. Zero means no limit on open
+	// connections.
+	MaxOpenConns int `yaml:"max_open_conns" env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns int `yaml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS"`
+	// ConnMaxLifetime and ConnMaxIdleTime close connections that are
+	// that old or have been idle that long, so connections are spread
+	// again after a failover. Zero keeps them forever.
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME"`
+}
+
+// JWTConfig configures the tokens issued on login.
+type JWTConfig struct {
+	// Secret is the HMAC key tokens are signed with.
+	Secret string `yaml:"secret" env:"JWT_SECRET" secret:"true"`
+	// PreviousSecret is a secret tokens were signed with before Secret.
+	// Tokens signed with it are still accepted, so rotating the secret
+	// doesn't log everyone out.
+	PreviousSecret string `yaml:"previous_secret" env:"JWT_PREVIOUS_SECRET" secret:"true"`
+}
+
+// SMSConfig configures the SMS gateway.
+type SMSConfig struct {
+	// APIURL is the endpoint of the SMS gateway. When empty, codes are
+	// logged instead.
+	APIURL string `yaml:"api_url" env:"SMS_API_URL"`
+	APIKey string `yaml:"api_key" env:"SMS_API_KEY" secret:"true"`
+}
+
+// SMTPConfig configures the SMTP server email codes are sent through.
+type SMTPConfig struct {
+	// Addr is the host:port of the SMTP server. When empty, codes are
+	// logged instead.
+	Addr     string `yaml:"addr" env:"SMTP_ADDR"`
+	Username string `yaml:"username" env:"SMTP_USERNAME"`
+	Password string `yaml:"password" env:"SMTP_PASSWORD" secret:"true"`
+	From     string `yaml:"from" env:"SMTP_FROM"`
+}
+
+// RateLimitConfig limits how often a client can call the signup and login
+// endpoints.
+type RateLimitConfig struct {
+	// Requests is how many requests a client IP can make per Window.
+	// Zero disables rate limiting.
+	Requests int           `yaml:"requests" env:"RATE_LIMIT_REQUESTS"`
+	Window   time.Duration `yaml:"window" env:"RATE_LIMIT_WINDOW"`
+}
+
+// LogConfig configures logging.
+type LogConfig struct {
+	// Level is the minimum level logged: debug, info, warn or error.
+	Level string `yaml:"level" env:"LOG_LEVEL"`
+	// Redact masks phone numbers, emails, verification codes and tokens
+	// in logs. Turn it off locally to read codes sent by the log provider.
+	Redact bool `yaml:"redact" env:"LOG_REDACT"`
+}
+
+// MetricsConfig configures the Prometheus /metrics endpoint.
+type MetricsConfig struct {
+	// Addr serves /metrics on a separate listener, such as
+	// "127.0.0.1:9090", out of reach of API clients. Otherwise /metrics is
+	// served with the API, and only if Token is set.
+	Addr string `yaml:"addr" env:"METRICS_ADDR"`
+	// Token is the bearer token scrapers must present to /metrics.
+	Token string `yaml:"token" env:"METRICS_TOKEN" secret:"true"`
+}
+
+// TracingConfig configures OpenTelemetry tracing.
+type TracingConfig struct {
+	// Exporter is where spans are sent: "otlp", "stdout" or "none". The
+	// OTLP exporter is configured with the standard OTEL_EXPORTER_OTLP_*
+	// variables.
+	Exporter string `yaml:"exporter" env:"TRACING_EXPORTER"`
+	// File makes the stdout exporter write to a file instead.
+	File string `yaml:"file" env:"TRACING_FILE"`
+	// SampleRatio is the share of new traces that are recorded. Traces
+	// started by a caller follow the caller's decision.
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+// UsersConfig configures the lifecycle of users.
+type UsersConfig struct {
+	// DeletedRetentionDays is how long soft-deleted users are kept before
+	// being purged. Zero disables purging.
+	DeletedRetentionDays int `yaml:"deleted_retention_days" env:"DELETED_USER_RETENTION_DAYS"`
+}
+
+// WebhooksConfig configures webhook deliveries.
+type WebhooksConfig struct {
+	// MaxAttempts is how many times a delivery is tried before it is
+	// dead-lettered.
+	MaxAttempts int `yaml:"max_attempts" env:"WEBHOOK_MAX_ATTEMPTS"`
+}
+
+// SecretsConfig configures the sealed secrets file, see ReloadSecrets.
+type SecretsConfig struct {
+	// File is a YAML file of secret settings sealed with `config seal`.
+	File string `yaml:"file" env:"SECRETS_FILE"`
+	// ReloadInterval is how often secrets are read again from their
+	// files. Zero disables reloading.
+	ReloadInterval time.Duration `yaml:"reload_interval" env:"SECRETS_RELOAD_INTERVAL"`
+}
+
+// Default returns the default configuration, which is meant for
+// production except for the credentials of the dev database and the JWT
+// secret, which Validate refuses outside Dev.
+func Default() *Config {
 	return &Config{
-		DBHost:     getEnv("DB_HOST", "localhost"),
-		DBUser:     getEnv("DB_USER", "user"),
-		DBPassword: getEnv("DB_PASSWORD", "password"),
-		DBName:     getEnv("DB_NAME", "mydatabase"),
-		DBPort:     getEnv("DB_PORT", "5432"),
-		SSLMode:    getEnv("DB_SSLMODE", "disable"),
-		TimeZone:   getEnv("DB_TIMEZONE", "UTC"),
-		JWTSecret:  getEnv("JWT_SECRET", "a-very-secret-key"),
+		Env: Production,
+		HTTP: HTTPConfig{
+			Addr:               ":8080",
+			ReadTimeout:        15 * time.Second,
+			ReadHeaderTimeout:  5 * time.Second,
+			WriteTimeout:       60 * time.Second,
+			IdleTimeout:        120 * time.Second,
+			RequestTimeout:     10 * time.Second,
+			BulkRequestTimeout: 50 * time.Second,
+			MaxHeaderBytes:     1 << 20,
+			ShutdownTimeout:    20 * time.Second,
+			ShutdownDelay:      5 * time.Second,
+			HealthCheckTimeout: 2 * time.Second,
+		},
+		TLS: TLSConfig{
+			ReloadInterval: time.Minute,
+			MinVersion:     "1.2",
+		},
+		DB: DBConfig{
+			Driver:   Postgres,
+			Host:     "localhost",
+			Port:     "5432",
+			User:     "user",
+			Password: devDBPassword,
+			Name:     "mydatabase",
+			SSLMode:  "disable",
+			TimeZone: "UTC",
+
+			ConnectTimeout:  30 * time.Second,
+			MaxOpenConns:    25,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+		},
+		JWT:       JWTConfig{Secret: devJWTSecret},
+		SMTP:      SMTPConfig{From: "no-reply@example.com"},
+		RateLimit: RateLimitConfig{Requests: 20, Window: time.Minute},
+		Log:       LogConfig{Level: "info", Redact: true},
+		Tracing:   TracingConfig{Exporter: "none", SampleRatio: 1},
+		Users:     UsersConfig{DeletedRetentionDays: 30},
+		Webhooks:  WebhooksConfig{MaxAttempts: 8},
+		Secrets:   SecretsConfig{ReloadInterval: time.Minute},
 	}
 }
 
-func (c *Config) DSN() string {
-	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s TimeZone=%s",
-		c.DBHost, c.DBUser, c.DBPassword, c.DBName, c.DBPort, c.SSLMode, c.TimeZone)
+// TrustedProxies returns the entries of http.trusted_proxies.
+func (c *Config) TrustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(c.HTTP.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
 }
 
-func getEnv(key, fallback string) string {
-	if value, ok := os.LookupEnv(key); ok {
-		return value
-	}
-	return fallback
+// DSN returns the connection string of the Postgres database.
+func (c *Config) DSN() string {
+	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s TimeZone=%s",
+		c.DB.Host, c.DB.User, c.DB.Password, c.DB.Name, c.DB.Port, c.DB.SSLMode, c.DB.TimeZone)
 }
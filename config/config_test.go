@@ -0,0 +1,216 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parse(t *testing.T, args ...string) (*Config, error) {
+	t.Helper()
+	return Parse(flag.NewFlagSet("test", flag.ContinueOnError), args)
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestParsePrecedence(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+http:
+  addr: ":7000"
+  read_timeout: 30s
+db:
+  host: db.internal
+  name: users
+log:
+  level: debug
+`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("DB_HOST", "db.from.env")
+	t.Setenv("LOG_LEVEL", "warn")
+	// Empty values in .env files don't unset non-string settings.
+	t.Setenv("HTTP_MAX_HEADER_BYTES", "")
+
+	cfg, err := parse(t, "-log.level=error", "-log.redact=false")
+	require.NoError(t, err)
+	assert.Equal(t, ":7000", cfg.HTTP.Addr)               // file
+	assert.Equal(t, 30*time.Second, cfg.HTTP.ReadTimeout) // file
+	assert.Equal(t, 5*time.Second, cfg.HTTP.ReadHeaderTimeout)
+	assert.Equal(t, 1<<20, cfg.HTTP.MaxHeaderBytes)
+	assert.Equal(t, "users", cfg.DB.Name)        // file
+	assert.Equal(t, "db.from.env", cfg.DB.Host)  // env over file
+	assert.Equal(t, "error", cfg.Log.Level)      // flag over env
+	assert.False(t, cfg.Log.Redact)              // boolean flag
+	assert.Equal(t, Production, cfg.Env)         // default
+	assert.Equal(t, 8, cfg.Webhooks.MaxAttempts) // default
+	assert.Equal(t, 20, cfg.RateLimit.Requests)  // default
+	assert.Equal(t, "no-reply@example.com", cfg.SMTP.From)
+}
+
+func TestParseTOML(t *testing.T) {
+	path := writeFile(t, "config.toml", `
+env = "dev"
+
+[ratelimit]
+requests = 5
+window = "30s"
+
+[tracing]
+exporter = "stdout"
+sample_ratio = 0.25
+`)
+	cfg, err := parse(t, "-config", path)
+	require.NoError(t, err)
+	assert.Equal(t, Dev, cfg.Env)
+	assert.Equal(t, RateLimitConfig{Requests: 5, Window: 30 * time.Second}, cfg.RateLimit)
+	assert.Equal(t, "stdout", cfg.Tracing.Exporter)
+	assert.Equal(t, 0.25, cfg.Tracing.SampleRatio)
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := parse(t, "-config", writeFile(t, "config.yaml", "http:\n  adr: \":7000\"\n"))
+	assert.ErrorContains(t, err, `unknown setting "http.adr"`)
+
+	_, err = parse(t, "-config", writeFile(t, "config.yaml", "http:\n  read_timeout: soon\n"))
+	assert.ErrorContains(t, err, `http.read_timeout: invalid duration "soon"`)
+
+	_, err = parse(t, "-config", writeFile(t, "config.json", "{}"))
+	assert.ErrorContains(t, err, "unknown configuration format")
+
+	t.Setenv("WEBHOOK_MAX_ATTEMPTS", "many")
+	_, err = parse(t)
+	assert.ErrorContains(t, err, `WEBHOOK_MAX_ATTEMPTS: invalid integer "many"`)
+
+	_, err = parse(t, "-db.port")
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.Env = Dev
+	assert.NoError(t, cfg.Validate())
+
+	// The defaults are insecure outside dev.
+	cfg.Env = Production
+	err := cfg.Validate()
+	assert.ErrorContains(t, err, "jwt.secret")
+	assert.ErrorContains(t, err, "db.password")
+
+	cfg.JWT.Secret = "too-short"
+	cfg.DB.Password = "s3cr3t"
+	assert.ErrorContains(t, cfg.Validate(), "jwt.secret")
+	cfg.JWT.Secret = "1c9d1b8f3a7e4c2b9d0f6a5e8b7c3d2a"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Log.Redact = false
+	cfg.Log.Level = "verbose"
+	cfg.TLS.CertFile = "cert.pem"
+	cfg.Tracing.SampleRatio = 2
+	cfg.DB.MaxOpenConns = -1
+	cfg.HTTP.TrustedProxies = "10.0.0.0/8, proxy.internal"
+	err = cfg.Validate()
+	for _, problem := range []string{"log.redact", "log.level", "tls.cert_file", "tracing.sample_ratio", "db connection limits", `"proxy.internal"`} {
+		assert.ErrorContains(t, err, problem)
+	}
+
+	assert.Equal(t, []string{"10.0.0.0/8", "proxy.internal"}, cfg.TrustedProxies())
+
+	cfg = Default()
+	cfg.Env = "staging"
+	assert.ErrorContains(t, cfg.Validate(), `env is "staging"`)
+
+	// SQLite needs no password, but has no replicas.
+	cfg = Default()
+	cfg.JWT.Secret = "1c9d1b8f3a7e4c2b9d0f6a5e8b7c3d2a"
+	cfg.DB.Driver = SQLite
+	cfg.DB.Name = "data.db"
+	assert.NoError(t, cfg.Validate())
+	cfg.DB.ReplicaHosts = "replica"
+	assert.ErrorContains(t, cfg.Validate(), "db.replica_hosts")
+	cfg.DB.Driver = "mysql"
+	assert.ErrorContains(t, cfg.Validate(), `db.driver is "mysql"`)
+}
+
+func TestRedactedRoundTrip(t *testing.T) {
+	cfg := Default()
+	cfg.SMTP.Password = "hunter2"
+	cfg.Metrics.Addr = "127.0.0.1:9090"
+
+	redacted := cfg.Redacted()
+	assert.Equal(t, "REDACTED", redacted.SMTP.Password)
+	assert.Equal(t, "REDACTED", redacted.JWT.Secret)
+	assert.Empty(t, redacted.SMS.APIKey)
+	assert.Equal(t, "hunter2", cfg.SMTP.Password)
+
+	// Printed configurations can be loaded back.
+	var printed bytes.Buffer
+	require.NoError(t, cfg.Write(&printed))
+	loaded, err := parse(t, "-config", writeFile(t, "config.yaml", printed.String()))
+	require.NoError(t, err)
+	assert.Equal(t, cfg, loaded)
+}
+
+func TestSecretFiles(t *testing.T) {
+	passwordFile := writeFile(t, "db_password", "from-file\n")
+	t.Setenv("DB_PASSWORD_FILE", passwordFile)
+
+	key := GenerateKey()
+	parsed, err := ParseKey(key)
+	require.NoError(t, err)
+	sealed := Seal([]byte("jwt:\n  secret: sealed-secret\nsmtp:\n  password: sealed-smtp\n"), parsed)
+	sealedFile := writeFile(t, "secrets.enc", string(sealed))
+	t.Setenv("SECRETS_FILE", sealedFile)
+	t.Setenv("SECRETS_KEY", key)
+	// Secrets set in the environment win over the files.
+	t.Setenv("SMTP_PASSWORD", "from-env")
+
+	cfg, err := parse(t)
+	require.NoError(t, err)
+	assert.Equal(t, "from-file", cfg.DB.Password)
+	assert.Equal(t, "sealed-secret", cfg.JWT.Secret)
+	assert.Equal(t, "from-env", cfg.SMTP.Password)
+
+	// Rotated secrets are picked up on reload.
+	require.NoError(t, os.WriteFile(passwordFile, []byte("rotated"), 0o600))
+	sealed = Seal([]byte("jwt:\n  secret: rotated-secret\n  previous_secret: sealed-secret\n"), parsed)
+	require.NoError(t, os.WriteFile(sealedFile, sealed, 0o600))
+	next, err := cfg.ReloadSecrets()
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", next.DB.Password)
+	assert.Equal(t, JWTConfig{Secret: "rotated-secret", PreviousSecret: "sealed-secret"}, next.JWT)
+	assert.Equal(t, "from-env", next.SMTP.Password)
+	assert.Equal(t, "from-file", cfg.DB.Password)
+}
+
+func TestSecretFileErrors(t *testing.T) {
+	t.Setenv("DB_PASSWORD", "from-env")
+	t.Setenv("DB_PASSWORD_FILE", writeFile(t, "db_password", "from-file"))
+	_, err := parse(t)
+	assert.ErrorContains(t, err, "set either DB_PASSWORD or DB_PASSWORD_FILE")
+	t.Setenv("DB_PASSWORD_FILE", "")
+
+	key, err := ParseKey(GenerateKey())
+	require.NoError(t, err)
+	t.Setenv("SECRETS_FILE", writeFile(t, "secrets.enc", string(Seal([]byte("http:\n  addr: \":1\"\n"), key))))
+	_, err = parse(t)
+	assert.ErrorContains(t, err, "SECRETS_KEY or SECRETS_KEY_FILE must be set")
+
+	t.Setenv("SECRETS_KEY", GenerateKey())
+	_, err = parse(t)
+	assert.ErrorContains(t, err, "wrong key")
+
+	t.Setenv("SECRETS_KEY", base64.StdEncoding.EncodeToString(key[:]))
+	_, err = parse(t)
+	assert.ErrorContains(t, err, `"http.addr" isn't a secret setting`)
+}
@@ -0,0 +1,278 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Load reads the configuration from its file, the environment and the
+// command line flags in args, and validates it.
+func Load(args []string) (*Config, error) {
+	cfg, err := Parse(flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError), args)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Parse registers -config and a flag per setting on fs, parses args with
+// it and returns the resulting configuration, without validating it.
+// Other flags registered on fs are parsed as well.
+func Parse(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := Default()
+	settings := settingsOf(cfg)
+
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML configuration `file`, also CONFIG_FILE")
+	var flags []flagValue
+	for _, s := range settings {
+		fs.Var(&flagValue{setting: s, set: &flags}, s.key, "sets "+s.key+", also "+s.env)
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if *file != "" {
+		if err := readFile(*file, settings); err != nil {
+			return nil, err
+		}
+	}
+	sources := &secretSources{files: map[string]string{}, fixed: map[string]bool{}}
+	for _, s := range settings {
+		value, ok := os.LookupEnv(s.env)
+		if s.secret {
+			if path := os.Getenv(s.env + "_FILE"); path != "" {
+				if value != "" {
+					return nil, fmt.Errorf("set either %s or %s_FILE, not both", s.env, s.env)
+				}
+				sources.files[s.key] = path
+				continue
+			}
+			// Empty secrets in .env files don't hide those in files.
+			if value != "" {
+				sources.fixed[s.key] = true
+			}
+		}
+		// .env files leave unused settings empty, which only strings can be.
+		if !ok || (value == "" && s.value.Kind() != reflect.String) {
+			continue
+		}
+		if err := s.set(value); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.env, err)
+		}
+	}
+	for _, f := range flags {
+		if err := f.setting.set(f.raw); err != nil {
+			return nil, fmt.Errorf("-%s: %w", f.setting.key, err)
+		}
+		if f.setting.secret {
+			sources.fixed[f.setting.key] = true
+		}
+	}
+
+	if sources.sealed = cfg.Secrets.File; sources.sealed != "" {
+		if sources.key, err = SecretsKey(); err != nil {
+			return nil, err
+		}
+	}
+	if sources.sealed != "" || len(sources.files) > 0 {
+		if err := sources.apply(settings); err != nil {
+			return nil, err
+		}
+		cfg.secrets = sources
+	}
+	return cfg, nil
+}
+
+// setting is a field of Config, such as HTTP.Addr with key "http.addr".
+type setting struct {
+	key    string
+	env    string
+	secret bool
+	value  reflect.Value
+}
+
+// settingsOf lists the settings of cfg, in the order of its fields.
+func settingsOf(cfg *Config) []setting {
+	var settings []setting
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		for i := range v.NumField() {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			key := prefix + field.Tag.Get("yaml")
+			if field.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), key+".")
+				continue
+			}
+			settings = append(settings, setting{
+				key:    key,
+				env:    field.Tag.Get("env"),
+				secret: field.Tag.Get("secret") == "true",
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "")
+	return settings
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// set parses raw into the setting.
+func (s setting) set(raw string) error {
+	v := s.value
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetFloat(f)
+	default:
+		panic("config: unsupported setting type " + v.Type().String())
+	}
+	return nil
+}
+
+// flagValue collects the flags set on the command line, so they can be
+// applied after the file and the environment.
+type flagValue struct {
+	setting setting
+	raw     string
+	set     *[]flagValue
+}
+
+func (f *flagValue) String() string {
+	if f == nil || !f.setting.value.IsValid() {
+		return ""
+	}
+	return fmt.Sprint(f.setting.value.Interface())
+}
+
+func (f *flagValue) Set(raw string) error {
+	// Check the value now, so flag reports it with the usage.
+	if err := f.setting.set(raw); err != nil {
+		return err
+	}
+	*f.set = append(*f.set, flagValue{setting: f.setting, raw: raw})
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.setting.value.Kind() == reflect.Bool
+}
+
+// readFile applies the settings of a YAML or TOML file, told apart by its
+// extension. Keys that aren't settings are refused, to catch typos.
+func readFile(path string, settings []setting) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	values := map[string]any{}
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("%s: unknown configuration format %q, want .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	byKey := map[string]setting{}
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+	flat := map[string]any{}
+	flatten(values, "", flat)
+	keys := make([]string, 0, len(flat))
+	for key := range flat {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s, ok := byKey[key]
+		if !ok {
+			return fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+		if flat[key] == nil {
+			continue
+		}
+		if err := s.set(fmt.Sprint(flat[key])); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+	}
+	return nil
+}
+
+// flatten turns nested sections into dotted keys, such as "http.addr".
+func flatten(values map[string]any, prefix string, flat map[string]any) {
+	for key, value := range values {
+		if section, ok := value.(map[string]any); ok {
+			flatten(section, prefix+key+".", flat)
+			continue
+		}
+		flat[prefix+key] = value
+	}
+}
+
+// Redacted returns a copy of c with its secrets, such as passwords and
+// keys, replaced by "REDACTED". Secrets that are unset stay empty.
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	for _, s := range settingsOf(&redacted) {
+		if s.secret && s.value.String() != "" {
+			s.value.SetString("REDACTED")
+		}
+	}
+	return &redacted
+}
+
+// Write writes c to w as YAML, in the format of configuration files.
+func (c *Config) Write(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
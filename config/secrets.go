@@ -0,0 +1,226 @@
+package config
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"gopkg.in/yaml.v3"
+)
+
+// KeySize is the size of the keys secrets files are sealed with.
+const KeySize = 32
+
+const nonceSize = 24
+
+// secretSources records where the secrets of a configuration come from,
+// to read them again.
+type secretSources struct {
+	// files maps settings to the file their _FILE variable names.
+	files map[string]string
+	// sealed is the path of the sealed secrets file, and key its key.
+	sealed string
+	key    *[KeySize]byte
+	// fixed holds the settings set in the environment or with flags,
+	// which the files can't override.
+	fixed map[string]bool
+}
+
+// read returns the secrets held by the files now, by setting.
+func (s *secretSources) read(settings []setting) (map[string]string, error) {
+	secrets := map[string]string{}
+	if s.sealed != "" {
+		sealed, err := readSealed(s.sealed, s.key, settings)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range sealed {
+			secrets[key] = value
+		}
+	}
+	for key, path := range s.files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		secrets[key] = strings.TrimRight(string(data), "\r\n")
+	}
+	for key := range s.fixed {
+		delete(secrets, key)
+	}
+	return secrets, nil
+}
+
+// apply sets the settings to the secrets the files hold now.
+func (s *secretSources) apply(settings []setting) error {
+	secrets, err := s.read(settings)
+	if err != nil {
+		return err
+	}
+	for _, setting := range settings {
+		if value, ok := secrets[setting.key]; ok {
+			setting.value.SetString(value)
+		}
+	}
+	return nil
+}
+
+// readSealed opens the sealed secrets file at path. It holds secret
+// settings only, in the format of configuration files.
+func readSealed(path string, key *[KeySize]byte, settings []setting) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := Open(data, key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	values := map[string]any{}
+	if err := yaml.Unmarshal(plaintext, &values); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	secret := map[string]bool{}
+	for _, s := range settings {
+		secret[s.key] = s.secret
+	}
+	flat := map[string]any{}
+	flatten(values, "", flat)
+	secrets := map[string]string{}
+	for key, value := range flat {
+		if !secret[key] {
+			return nil, fmt.Errorf("%s: %q isn't a secret setting", path, key)
+		}
+		if value != nil {
+			secrets[key] = fmt.Sprint(value)
+		}
+	}
+	return secrets, nil
+}
+
+// SecretsKey reads the key of the sealed secrets file from SECRETS_KEY or
+// the file named by SECRETS_KEY_FILE.
+func SecretsKey() (*[KeySize]byte, error) {
+	encoded := os.Getenv("SECRETS_KEY")
+	if path := os.Getenv("SECRETS_KEY_FILE"); path != "" {
+		if encoded != "" {
+			return nil, errors.New("set either SECRETS_KEY or SECRETS_KEY_FILE, not both")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(data)
+	}
+	if encoded == "" {
+		return nil, errors.New("SECRETS_KEY or SECRETS_KEY_FILE must be set")
+	}
+	return ParseKey(encoded)
+}
+
+// GenerateKey returns a new random key for sealing secrets files, encoded
+// as base64.
+func GenerateKey() string {
+	key := make([]byte, KeySize)
+	rand.Read(key)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// ParseKey decodes a base64 key from GenerateKey.
+func ParseKey(encoded string) (*[KeySize]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(decoded) != KeySize {
+		return nil, fmt.Errorf("secrets key must be %d bytes encoded as base64", KeySize)
+	}
+	key := new([KeySize]byte)
+	copy(key[:], decoded)
+	return key, nil
+}
+
+// Seal encrypts and authenticates plaintext with key, and encodes it as
+// base64 so sealed files can be diffed and pasted.
+func Seal(plaintext []byte, key *[KeySize]byte) []byte {
+	var nonce [nonceSize]byte
+	rand.Read(nonce[:])
+	sealed := secretbox.Seal(nonce[:], plaintext, &nonce, key)
+	return []byte(base64.StdEncoding.EncodeToString(sealed) + "\n")
+}
+
+// Open decrypts what Seal returned, checking it wasn't tampered with.
+func Open(sealed []byte, key *[KeySize]byte) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sealed)))
+	if err != nil || len(decoded) < nonceSize {
+		return nil, errors.New("malformed secrets file")
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], decoded)
+	plaintext, ok := secretbox.Open(nil, decoded[nonceSize:], &nonce, key)
+	if !ok {
+		return nil, errors.New("secrets file can't be decrypted, wrong key or corrupted file")
+	}
+	return plaintext, nil
+}
+
+// ReloadSecrets returns a copy of c with its secrets read again from
+// their files, so rotated secrets are picked up without a restart.
+//
+// Secret settings, such as db.password, can be read from files, so they
+// needn't sit in the environment or the configuration file:
+//
+//   - from a file named by the variable with a _FILE suffix, such as
+//     DB_PASSWORD_FILE, as mounted by Docker and Kubernetes secrets;
+//   - from secrets.file, a YAML file of secret settings sealed with
+//     NaCl secretbox under the key in SECRETS_KEY or SECRETS_KEY_FILE.
+//
+// Both take precedence over the configuration file, but not over secrets
+// set in the environment or on the command line.
+func (c *Config) ReloadSecrets() (*Config, error) {
+	next := *c
+	if c.secrets != nil {
+		if err := c.secrets.apply(settingsOf(&next)); err != nil {
+			return nil, err
+		}
+	}
+	return &next, nil
+}
+
+// WatchSecrets reloads the secrets of c every interval until ctx is done,
+// and calls reload with the new configuration whenever they changed. If
+// they can't be read or are invalid, the error is logged and the current
+// ones are kept. Only secrets read from files can change.
+func (c *Config) WatchSecrets(ctx context.Context, interval time.Duration, reload func(*Config)) {
+	if c.secrets == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	current := c
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		next, err := current.ReloadSecrets()
+		if err == nil {
+			err = next.Validate()
+		}
+		if err != nil {
+			slog.Error("Failed to reload secrets", "error", err)
+			continue
+		}
+		if *next != *current {
+			slog.Info("Secrets changed, reloading")
+			reload(next)
+			current = next
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+)
+
+// minJWTSecretLength is the shortest JWT secret accepted outside Dev. HS256
+// keys should be at least as long as the hash.
+const minJWTSecretLength = 32
+
+// Validate checks that the settings make sense together, and outside Dev
+// that none of the insecure defaults are left. It reports every problem at
+// once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Env == Dev || c.Env == Production, "env is %q, want %s or %s", c.Env, Dev, Production)
+
+	check(c.HTTP.Addr != "", "http.addr is required")
+	check(c.HTTP.ReadTimeout >= 0 && c.HTTP.ReadHeaderTimeout >= 0 && c.HTTP.WriteTimeout >= 0 && c.HTTP.IdleTimeout >= 0,
+		"http timeouts can't be negative")
+	check(c.HTTP.RequestTimeout >= 0 && c.HTTP.BulkRequestTimeout >= 0, "http request timeouts can't be negative")
+	check(c.HTTP.MaxHeaderBytes > 0, "http.max_header_bytes must be positive")
+	check(c.HTTP.ShutdownTimeout > 0, "http.shutdown_timeout must be positive")
+	check(c.HTTP.ShutdownDelay >= 0, "http.shutdown_delay can't be negative")
+	check(c.HTTP.HealthCheckTimeout > 0, "http.health_check_timeout must be positive")
+	for _, proxy := range c.TrustedProxies() {
+		_, _, err := net.ParseCIDR(proxy)
+		check(err == nil || net.ParseIP(proxy) != nil, "http.trusted_proxies has %q, want an IP or CIDR range", proxy)
+	}
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls.cert_file and tls.key_file must be set together")
+	check(c.TLS.CertFile == "" || c.TLS.ReloadInterval > 0, "tls.reload_interval must be positive")
+	check(c.TLS.MinVersion == "1.2" || c.TLS.MinVersion == "1.3", "tls.min_version is %q, want 1.2 or 1.3", c.TLS.MinVersion)
+	check(!c.TLS.ClientCertRequired || c.TLS.ClientCAFile != "", "tls.client_cert_required needs tls.client_ca_file")
+
+	check(c.DB.Driver == Postgres || c.DB.Driver == SQLite, "db.driver is %q, want %s or %s", c.DB.Driver, Postgres, SQLite)
+	check(c.DB.Name != "", "db.name is required")
+	check(c.DB.Driver != Postgres || c.DB.Host != "", "db.host is required")
+	check(c.DB.Driver != SQLite || c.DB.ReplicaHosts == "", "db.replica_hosts isn't supported by %s", SQLite)
+	check(c.DB.ConnectTimeout >= 0 && c.DB.ConnMaxLifetime >= 0 && c.DB.ConnMaxIdleTime >= 0,
+		"db timeouts and lifetimes can't be negative")
+	check(c.DB.MaxOpenConns >= 0 && c.DB.MaxIdleConns >= 0, "db connection limits can't be negative")
+
+	check(c.RateLimit.Requests >= 0, "ratelimit.requests can't be negative")
+	check(c.RateLimit.Requests == 0 || c.RateLimit.Window > 0, "ratelimit.window must be positive")
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "log.level is %q, want debug, info, warn or error", c.Log.Level)
+
+	check(slices.Contains([]string{"", "none", "otlp", "stdout"}, c.Tracing.Exporter),
+		"tracing.exporter is %q, want otlp, stdout or none", c.Tracing.Exporter)
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+
+	check(c.Users.DeletedRetentionDays >= 0, "users.deleted_retention_days can't be negative")
+	check(c.Webhooks.MaxAttempts > 0, "webhooks.max_attempts must be positive")
+	check(c.Secrets.ReloadInterval >= 0, "secrets.reload_interval can't be negative")
+
+	if c.Env != Dev {
+		check(c.JWT.Secret != devJWTSecret && len(c.JWT.Secret) >= minJWTSecretLength,
+			"jwt.secret must be a random value of at least %d bytes outside dev", minJWTSecretLength)
+		check(c.DB.Driver != Postgres || c.DB.Password != "" && c.DB.Password != devDBPassword,
+			"db.password must be set outside dev")
+		check(c.Log.Redact, "log.redact can only be turned off in dev")
+	}
+	return errors.Join(errs...)
+}
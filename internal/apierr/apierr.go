@@ -0,0 +1,114 @@
+// Package apierr defines the errors the API returns and renders them as
+// RFC 7807 problem details with stable, machine-readable codes.
+package apierr
+
+import (
+	"my-project/internal/i18n"
+	"net/http"
+	"time"
+)
+
+// Code identifies the kind of an error. Codes are part of the API: clients
+// may switch on them, so existing codes must not change meaning.
+type Code string
+
+// Codes of authentication and authorization errors.
+const (
+	CodeInvalidCredentials Code = "AUTH_INVALID_CREDENTIALS"
+	CodeInvalidCode        Code = "AUTH_INVALID_CODE"
+	CodeUserDisabled       Code = "AUTH_USER_DISABLED"
+	CodeAuthRequired       Code = "AUTH_REQUIRED"
+	CodeInvalidToken       Code = "AUTH_INVALID_TOKEN"
+	CodeForbidden          Code = "AUTH_FORBIDDEN"
+)
+
+// Codes of errors about resources.
+const (
+	CodeUserNotFound      Code = "USER_NOT_FOUND"
+	CodeIdentityTaken     Code = "USER_IDENTITY_TAKEN"
+	CodeUserModified      Code = "USER_MODIFIED"
+	CodeWebhookNotFound   Code = "WEBHOOK_NOT_FOUND"
+	CodeDeliveryNotFound  Code = "WEBHOOK_DELIVERY_NOT_FOUND"
+	CodeDeliverySucceeded Code = "WEBHOOK_DELIVERY_SUCCEEDED"
+)
+
+// Codes of errors about the request itself.
+const (
+	CodeValidationFailed     Code = "VALIDATION_FAILED"
+	CodeInvalidBody          Code = "INVALID_REQUEST_BODY"
+	CodeInvalidParameter     Code = "INVALID_PARAMETER"
+	CodePreconditionRequired Code = "PRECONDITION_REQUIRED"
+	CodeUnsupportedMediaType Code = "UNSUPPORTED_MEDIA_TYPE"
+	CodePayloadTooLarge      Code = "PAYLOAD_TOO_LARGE"
+	CodeRateLimited          Code = "RATE_LIMITED"
+	CodeNotFound             Code = "NOT_FOUND"
+	CodeTimeout              Code = "TIMEOUT"
+	CodeInternal             Code = "INTERNAL_ERROR"
+)
+
+// StatusClientClosedRequest is the status logged for requests whose client
+// went away before the response, as nginx does. Clients never see it.
+const StatusClientClosedRequest = 499
+
+// Codes of the individual fields of a VALIDATION_FAILED error.
+const (
+	FieldRequired      = "REQUIRED"
+	FieldInvalidFormat = "INVALID_FORMAT"
+	FieldInvalidValue  = "INVALID_VALUE"
+	FieldInvalidType   = "INVALID_TYPE"
+	FieldReadOnly      = "READ_ONLY"
+	FieldUnknown       = "UNKNOWN_FIELD"
+)
+
+// Error is an error with the status, code and message it is returned to the
+// client with. Detail is the ID of the message in the i18n bundles, which
+// is translated with Args into the locale of the request. Err is the
+// cause, which is logged but never sent.
+type Error struct {
+	Status int
+	Code   Code
+	Detail string
+	Args   []any
+	Fields []FieldError
+	Err    error
+}
+
+func (e *Error) Error() string {
+	msg := string(e.Code) + ": " + i18n.Translate(i18n.English, e.Detail, e.Args...)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New returns an error with status, code and the detail message with ID
+// detail, formatted with args.
+func New(status int, code Code, detail string, args ...any) *Error {
+	return &Error{Status: status, Code: code, Detail: detail, Args: args}
+}
+
+// Internal returns a 500 error caused by err. Only detail is sent to the
+// client.
+func Internal(err error, detail string) *Error {
+	return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Detail: detail, Err: err}
+}
+
+// Timeout returns a 504 error for a request that ran out of time after d,
+// caused by err.
+func Timeout(err error, d time.Duration) *Error {
+	return &Error{Status: http.StatusGatewayTimeout, Code: CodeTimeout, Detail: "error.timeout", Args: []any{d.String()}, Err: err}
+}
+
+// Validation returns a VALIDATION_FAILED error listing the invalid fields.
+func Validation(fields ...FieldError) *Error {
+	return &Error{
+		Status: http.StatusBadRequest,
+		Code:   CodeValidationFailed,
+		Detail: "error.validation_failed",
+		Fields: fields,
+	}
+}
@@ -0,0 +1,127 @@
+package apierr
+
+import (
+	"encoding/json"
+	"errors"
+	"my-project/internal/logging"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(logging.Middleware(), Middleware(), logging.Recovery())
+	r.NoRoute(NotFound)
+	return r
+}
+
+func serve(r *gin.Engine, method, path, body string) (*httptest.ResponseRecorder, Problem) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(logging.RequestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var problem Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	return w, problem
+}
+
+func TestMiddleware(t *testing.T) {
+	r := setupRouter()
+	r.GET("/users/:id", func(c *gin.Context) {
+		c.Error(New(http.StatusNotFound, CodeUserNotFound, "User not found"))
+	})
+	r.GET("/broken", func(c *gin.Context) {
+		c.Error(errors.New("connection refused by 10.0.0.5"))
+	})
+	r.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+	r.GET("/written", func(c *gin.Context) {
+		c.String(http.StatusOK, "partial")
+		c.Error(errors.New("stream cut short"))
+	})
+	r.GET("/forbidden", func(c *gin.Context) {
+		Abort(c, New(http.StatusForbidden, CodeForbidden, "Nope"))
+	}, func(c *gin.Context) {
+		c.String(http.StatusOK, "not reached")
+	})
+
+	w, problem := serve(r, http.MethodGet, "/users/42", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, Problem{
+		Type:      "about:blank",
+		Title:     "Not Found",
+		Status:    http.StatusNotFound,
+		Detail:    "User not found",
+		Instance:  "/users/42",
+		Code:      CodeUserNotFound,
+		RequestID: "req-1",
+	}, problem)
+
+	// The text of unexpected errors isn't sent.
+	w, problem = serve(r, http.MethodGet, "/broken", "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, CodeInternal, problem.Code)
+	assert.NotContains(t, w.Body.String(), "10.0.0.5")
+
+	w, problem = serve(r, http.MethodGet, "/panic", "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, CodeInternal, problem.Code)
+
+	w, _ = serve(r, http.MethodGet, "/written", "")
+	assert.Equal(t, "partial", w.Body.String())
+
+	w, problem = serve(r, http.MethodGet, "/forbidden", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, CodeForbidden, problem.Code)
+
+	w, problem = serve(r, http.MethodGet, "/nowhere", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, CodeNotFound, problem.Code)
+}
+
+func TestFromBinding(t *testing.T) {
+	type request struct {
+		PhoneNumber string `json:"phone_number" binding:"required"`
+		Email       string `json:"email" binding:"omitempty,email"`
+		Age         int    `json:"age"`
+	}
+	r := setupRouter()
+	r.POST("/signup", func(c *gin.Context) {
+		var req request
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(FromBinding(err))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	w, problem := serve(r, http.MethodPost, "/signup", `{"email": "nope"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, CodeValidationFailed, problem.Code)
+	assert.Equal(t, []FieldError{
+		{Field: "phone_number", Code: FieldRequired, Message: "Field is required"},
+		{Field: "email", Code: FieldInvalidFormat, Message: "Invalid format"},
+	}, problem.Errors)
+
+	_, problem = serve(r, http.MethodPost, "/signup", `{"phone_number": "0912", "age": "ten"}`)
+	assert.Equal(t, CodeValidationFailed, problem.Code)
+	require.Len(t, problem.Errors, 1)
+	assert.Equal(t, FieldError{Field: "age", Code: FieldInvalidType, Message: "Must be of type number"}, problem.Errors[0])
+
+	_, problem = serve(r, http.MethodPost, "/signup", `{"phone_number":`)
+	assert.Equal(t, CodeInvalidBody, problem.Code)
+
+	_, problem = serve(r, http.MethodPost, "/signup", "")
+	assert.Equal(t, CodeInvalidBody, problem.Code)
+	assert.Equal(t, "Request body is empty", problem.Detail)
+}
@@ -0,0 +1,88 @@
+package apierr
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	// Report fields by their JSON names, which is what clients send.
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			if name == "" {
+				return field.Name
+			}
+			return name
+		})
+	}
+}
+
+// FromBinding translates an error from binding a request body with
+// c.ShouldBindJSON: failed binding rules become a VALIDATION_FAILED error
+// listing the fields, anything else an INVALID_REQUEST_BODY error.
+func FromBinding(err error) *Error {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		fields := make([]FieldError, len(validationErrs))
+		for i, fe := range validationErrs {
+			fields[i] = bindingFieldError(fe)
+		}
+		return Validation(fields...)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return Validation(FieldError{Field: typeErr.Field, Code: FieldInvalidType, Message: "validation.type", Args: []any{jsonType(typeErr.Type)}})
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &Error{Status: http.StatusRequestEntityTooLarge, Code: CodePayloadTooLarge, Detail: "error.body_too_large", Err: err}
+	}
+	detail := "error.body_invalid"
+	if errors.Is(err, io.EOF) {
+		detail = "error.body_empty"
+	}
+	return &Error{Status: http.StatusBadRequest, Code: CodeInvalidBody, Detail: detail, Err: err}
+}
+
+func bindingFieldError(fe validator.FieldError) FieldError {
+	field := FieldError{Field: fe.Field()}
+	switch fe.Tag() {
+	case "required":
+		field.Code, field.Message = FieldRequired, "validation.required"
+	case "email", "url", "http_url", "e164", "uuid":
+		field.Code, field.Message = FieldInvalidFormat, "validation.format"
+	default:
+		field.Code, field.Message, field.Args = FieldInvalidValue, "validation.rule", []any{fe.Tag()}
+	}
+	return field
+}
+
+// jsonType names the JSON type that decodes into t.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
@@ -0,0 +1,100 @@
+package apierr
+
+import (
+	"errors"
+	"my-project/internal/i18n"
+	"my-project/internal/logging"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType is the media type of problem details.
+const ContentType = "application/problem+json"
+
+// Problem is the body of every error response, an RFC 7807 problem details
+// object extended with the error code, the request ID and, for validation
+// errors, the invalid fields.
+type Problem struct {
+	Type      string       `json:"type" example:"about:blank"`
+	Title     string       `json:"title" example:"Not Found"`
+	Status    int          `json:"status" example:"404"`
+	Detail    string       `json:"detail,omitempty" example:"User not found"`
+	Instance  string       `json:"instance,omitempty" example:"/api/v1/users/42"`
+	Code      Code         `json:"code" swaggertype:"string" example:"USER_NOT_FOUND"`
+	RequestID string       `json:"request_id,omitempty" example:"4bf92f3577b34da6a3ce929d0e0e4736"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError reports why a field of the request is invalid. Field is the
+// JSON name of the field, or "" for the document as a whole. Message is
+// translated like Error.Detail.
+type FieldError struct {
+	Field   string `json:"field" example:"phone_number"`
+	Code    string `json:"code" example:"INVALID_FORMAT"`
+	Message string `json:"message" example:"Invalid phone number format"`
+	Args    []any  `json:"-"`
+}
+
+// Middleware renders the last error added to the request with c.Error as
+// problem details, unless a response has been written already or the
+// client has gone away. Errors
+// other than *Error are turned into a 500 with a generic message, so their
+// text never reaches the client; it is logged with the request instead.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		if c.Request.Context().Err() != nil {
+			// The client went away, so there is no one to send the error
+			// to. It is only logged.
+			c.Status(StatusClientClosedRequest)
+			return
+		}
+		err := c.Errors.Last().Err
+		var apiErr *Error
+		if !errors.As(err, &apiErr) {
+			apiErr = Internal(err, "error.unexpected")
+		}
+		Write(c, apiErr)
+	}
+}
+
+// Abort stops the request with err, for middleware that refuses requests
+// before they reach a handler. The status is set right away, so it is kept
+// even where Middleware isn't installed.
+func Abort(c *gin.Context, err *Error) {
+	c.Status(err.Status)
+	c.Error(err)
+	c.Abort()
+}
+
+// Write writes err as problem details, with its messages in the locale of
+// the request.
+func Write(c *gin.Context, err *Error) {
+	ctx := c.Request.Context()
+	var fields []FieldError
+	for _, field := range err.Fields {
+		field.Message = i18n.T(ctx, field.Message, field.Args...)
+		fields = append(fields, field)
+	}
+	c.Header("Content-Type", ContentType)
+	c.JSON(err.Status, Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(err.Status),
+		Status:    err.Status,
+		Detail:    i18n.T(ctx, err.Detail, err.Args...),
+		Instance:  c.Request.URL.Path,
+		Code:      err.Code,
+		RequestID: logging.RequestID(ctx),
+		Errors:    fields,
+	})
+}
+
+// NotFound is the handler for requests that match no route.
+func NotFound(c *gin.Context) {
+	c.Error(New(http.StatusNotFound, CodeNotFound, "error.no_route", c.Request.Method, c.Request.URL.Path))
+}
@@ -0,0 +1,242 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"my-project/internal/database"
+	"my-project/internal/events"
+	"my-project/internal/models"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Outcomes of an audited action.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionLoginPassword = "auth.login.password"
+	ActionLoginSMS      = "auth.login.sms"
+	ActionLoginEmail    = "auth.login.email"
+	ActionUserCreate    = "user.create"
+	ActionUserUpdate    = "user.update"
+	ActionUserDelete    = "user.delete"
+	ActionUserPurge     = "user.purge"
+	ActionUserRestore   = "user.restore"
+	ActionUserDisable   = "user.disable"
+	ActionUserEnable    = "user.enable"
+	ActionUserImport    = "user.import"
+	ActionRoleAssign    = "user.role_assign"
+)
+
+// chainLockKey identifies the Postgres advisory lock that serializes
+// appends to the hash chain. It is held until the audited change commits,
+// so it must be the only lock guarding appends: an import recording an
+// event per user would otherwise hold it while waiting for the other lock,
+// held by a request waiting for it. SQLite serializes writers by itself.
+const chainLockKey = 7_310_524_411
+
+// Actor describes who performed an action and where the request came from.
+type Actor struct {
+	Name      string
+	Role      string
+	IP        string
+	UserAgent string
+	RequestID string
+}
+
+// ActorFromContext returns the caller of the request that ctx belongs to.
+// Requests without a token, such as logins, have an anonymous actor.
+func ActorFromContext(ctx context.Context) Actor {
+	md := events.MetadataFrom(ctx)
+	return Actor{
+		Name:      md.Actor,
+		Role:      md.ActorRole,
+		IP:        md.IP,
+		UserAgent: md.UserAgent,
+		RequestID: md.RequestID,
+	}
+}
+
+// Entry is a single action to record.
+type Entry struct {
+	Action     string
+	Outcome    string
+	TargetType string
+	TargetID   string
+	// Before and After are the state of the target around the action. Only
+	// fields that differ between them are recorded.
+	Before interface{}
+	After  interface{}
+}
+
+// UserTarget returns the target fields of an entry about user.
+func UserTarget(user models.User) (string, string) {
+	return "user", strconv.FormatUint(uint64(user.ID), 10)
+}
+
+// Record appends entry to the audit log using db, which should be the
+// transaction making the audited change so both commit together.
+func Record(db *gorm.DB, actor Actor, entry Entry) error {
+	changes, err := Diff(entry.Before, entry.After)
+	if err != nil {
+		return err
+	}
+	outcome := entry.Outcome
+	if outcome == "" {
+		outcome = OutcomeSuccess
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if database.IsPostgres(tx) {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", chainLockKey).Error; err != nil {
+				return err
+			}
+		}
+
+		var prev models.AuditEvent
+		err := tx.Select("hash").Order("id DESC").Limit(1).Find(&prev).Error
+		if err != nil {
+			return err
+		}
+
+		event := models.AuditEvent{
+			// Postgres keeps microseconds, so truncate to make the hash reproducible.
+			CreatedAt:  time.Now().UTC().Truncate(time.Microsecond),
+			Actor:      actor.Name,
+			ActorRole:  actor.Role,
+			Action:     entry.Action,
+			Outcome:    outcome,
+			TargetType: entry.TargetType,
+			TargetID:   entry.TargetID,
+			Changes:    changes,
+			IP:         actor.IP,
+			UserAgent:  actor.UserAgent,
+			RequestID:  actor.RequestID,
+			PrevHash:   prev.Hash,
+		}
+		if event.Hash, err = Hash(event); err != nil {
+			return err
+		}
+		return tx.Create(&event).Error
+	})
+}
+
+// Hash computes the chain hash of event from its contents and PrevHash.
+func Hash(event models.AuditEvent) (string, error) {
+	content, err := json.Marshal([]interface{}{
+		event.PrevHash,
+		event.CreatedAt.UTC().Format(time.RFC3339Nano),
+		event.Actor,
+		event.ActorRole,
+		event.Action,
+		event.Outcome,
+		event.TargetType,
+		event.TargetID,
+		event.Changes,
+		event.IP,
+		event.UserAgent,
+		event.RequestID,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// VerifyResult is the outcome of checking the hash chain.
+type VerifyResult struct {
+	Valid   bool  `json:"valid"`
+	Checked int64 `json:"checked"`
+	// BrokenAt is the ID of the first event whose hash doesn't match.
+	BrokenAt uint `json:"broken_at,omitempty"`
+}
+
+// Verify walks the whole audit log and checks that every event's hash
+// matches its contents and links to the event before it.
+func Verify(db *gorm.DB) (VerifyResult, error) {
+	result := VerifyResult{Valid: true}
+	prevHash := ""
+
+	var batch []models.AuditEvent
+	errBroken := errors.New("hash chain broken")
+	err := db.Order("id").FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for _, event := range batch {
+			hash, err := Hash(event)
+			if err != nil {
+				return err
+			}
+			if event.PrevHash != prevHash || event.Hash != hash {
+				result.Valid = false
+				result.BrokenAt = event.ID
+				return errBroken
+			}
+			prevHash = event.Hash
+			result.Checked++
+		}
+		return nil
+	}).Error
+	if err != nil && !errors.Is(err, errBroken) {
+		return VerifyResult{}, err
+	}
+	return result, nil
+}
+
+// Diff returns the fields that differ between before and after, compared by
+// their JSON representation. Either side may be nil for creations and
+// deletions.
+func Diff(before, after interface{}) (models.Changes, error) {
+	b, err := toFields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := toFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := models.Changes{}
+	for field, value := range b {
+		if !reflect.DeepEqual(value, a[field]) {
+			changes[field] = models.Change{Before: value, After: a[field]}
+		}
+	}
+	for field, value := range a {
+		if _, ok := b[field]; !ok && value != nil {
+			changes[field] = models.Change{After: value}
+		}
+	}
+	// Bookkeeping fields change on every write and only add noise.
+	delete(changes, "updated_at")
+	delete(changes, "version")
+
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return changes, nil
+}
+
+func toFields(v interface{}) (map[string]interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
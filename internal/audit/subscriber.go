@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"context"
+	"my-project/internal/database"
+	"my-project/internal/events"
+	"my-project/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var loginActions = map[string]string{
+	events.MethodPassword: ActionLoginPassword,
+	events.MethodSMS:      ActionLoginSMS,
+	events.MethodEmail:    ActionLoginEmail,
+}
+
+// Subscribe records user events in the audit log. The subscribers are
+// synchronous, so an event is recorded in the transaction that published
+// it, or with db if there is none.
+func Subscribe(bus *events.Bus, db *gorm.DB) {
+	record := func(ctx context.Context, action string, user models.User, before, after interface{}) error {
+		targetType, targetID := UserTarget(user)
+		return Record(database.Conn(ctx, db), ActorFromContext(ctx), Entry{
+			Action:     action,
+			TargetType: targetType,
+			TargetID:   targetID,
+			Before:     before,
+			After:      after,
+		})
+	}
+
+	events.Subscribe(bus, func(ctx context.Context, e events.UserCreated) error {
+		action := ActionUserCreate
+		if e.Imported {
+			action = ActionUserImport
+		}
+		return record(ctx, action, e.User, nil, e.User)
+	})
+	events.Subscribe(bus, func(ctx context.Context, e events.UserUpdated) error {
+		return record(ctx, ActionUserUpdate, e.After, e.Before, e.After)
+	})
+	events.Subscribe(bus, func(ctx context.Context, e events.RoleAssigned) error {
+		return record(ctx, ActionRoleAssign, e.After, e.Before, e.After)
+	})
+	events.Subscribe(bus, func(ctx context.Context, e events.UserDeleted) error {
+		action := ActionUserDelete
+		if e.Purged {
+			action = ActionUserPurge
+		}
+		return record(ctx, action, e.User, e.User, nil)
+	})
+	events.Subscribe(bus, func(ctx context.Context, e events.UserRestored) error {
+		return record(ctx, ActionUserRestore, e.After, e.Before, e.After)
+	})
+	events.Subscribe(bus, func(ctx context.Context, e events.UserDisabled) error {
+		return record(ctx, ActionUserDisable, e.After, e.Before, e.After)
+	})
+	events.Subscribe(bus, func(ctx context.Context, e events.UserEnabled) error {
+		return record(ctx, ActionUserEnable, e.After, e.Before, e.After)
+	})
+
+	recordLogin := func(ctx context.Context, method, identifier string, user *models.User, outcome string) error {
+		// Logins are anonymous requests, so the actor is whoever they claim to be.
+		actor := ActorFromContext(ctx)
+		actor.Name = identifier
+		entry := Entry{Action: loginActions[method], Outcome: outcome}
+		if user != nil {
+			entry.TargetType, entry.TargetID = UserTarget(*user)
+			actor.Role = user.Role
+		}
+		return Record(database.Conn(ctx, db), actor, entry)
+	}
+	events.Subscribe(bus, func(ctx context.Context, e events.UserLoggedIn) error {
+		return recordLogin(ctx, e.Method, e.Identifier, &e.User, OutcomeSuccess)
+	})
+	events.Subscribe(bus, func(ctx context.Context, e events.LoginFailed) error {
+		return recordLogin(ctx, e.Method, e.Identifier, e.User, OutcomeFailure)
+	})
+}
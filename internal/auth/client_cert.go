@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"my-project/internal/apierr"
+	"my-project/internal/logging"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Principal is a service authenticated by a client certificate.
+type Principal struct {
+	Name string
+	Role string
+}
+
+// ParsePrincipals parses "subject=name:role" entries separated by
+// semicolons. The subject is matched against a certificate's common name
+// or its full distinguished name, such as "CN=billing,O=Acme", so it is
+// split from the principal at the last "=", and the role from the name at
+// the last ":".
+func ParsePrincipals(s string) (map[string]Principal, error) {
+	principals := map[string]Principal{}
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, "=")
+		j := strings.LastIndex(entry, ":")
+		if i <= 0 || j <= i+1 || j == len(entry)-1 {
+			return nil, fmt.Errorf("invalid principal %q, want subject=name:role", entry)
+		}
+		principals[entry[:i]] = Principal{Name: entry[i+1 : j], Role: entry[j+1:]}
+	}
+	return principals, nil
+}
+
+// ClientCertMiddleware authenticates requests carrying a verified client
+// certificate as the principal its subject maps to, the same way
+// AuthMiddleware does for a token. Certificates without a principal are
+// refused. Requests without a certificate are left to AuthMiddleware,
+// unless required is set.
+func ClientCertMiddleware(principals map[string]Principal, required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cert := verifiedClientCert(c.Request)
+		if cert == nil {
+			if required {
+				apierr.Abort(c, apierr.New(http.StatusUnauthorized, apierr.CodeAuthRequired, "auth.client_cert_required"))
+				return
+			}
+			c.Next()
+			return
+		}
+
+		principal, ok := principals[cert.Subject.CommonName]
+		if !ok {
+			principal, ok = principals[cert.Subject.String()]
+		}
+		if !ok {
+			apierr.Abort(c, apierr.New(http.StatusForbidden, apierr.CodeForbidden, "auth.client_cert_unmapped"))
+			return
+		}
+
+		c.Set("phone_number", principal.Name)
+		c.Set("role", principal.Role)
+		logging.AddAttrs(c, slog.String("principal", principal.Name), slog.String("role", principal.Role))
+		c.Next()
+	}
+}
+
+// verifiedClientCert returns the client certificate of r if the TLS
+// handshake verified it against the client CAs.
+func verifiedClientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
@@ -2,41 +2,61 @@ package auth
 
 import (
 	"my-project/config"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtKey []byte
+// jwtKeys holds the key tokens are signed with, followed by the keys they
+// were signed with before, which are still accepted.
+var jwtKeys atomic.Pointer[[][]byte]
 
+// InitializeJWT sets the keys tokens are signed and verified with. It is
+// called again when the secrets are rotated.
 func InitializeJWT(cfg *config.Config) {
-	jwtKey = []byte(cfg.JWTSecret)
+	keys := [][]byte{[]byte(cfg.JWT.Secret)}
+	if cfg.JWT.PreviousSecret != "" {
+		keys = append(keys, []byte(cfg.JWT.PreviousSecret))
+	}
+	jwtKeys.Store(&keys)
 }
 
 type Claims struct {
 	PhoneNumber string `json:"phone_number"`
 	Role        string `json:"role"`
+	// Locale is the user's preferred locale, if they have chosen one.
+	Locale string `json:"locale,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateJWT(phoneNumber, role string) (string, error) {
+// GenerateJWT returns a token for the user with userID, which is stored as
+// the token's subject.
+func GenerateJWT(userID uint, phoneNumber, role, locale string) (string, error) {
 	expirationTime := time.Now().Add(24 * time.Hour)
 	claims := &Claims{
 		PhoneNumber: phoneNumber,
 		Role:        role,
+		Locale:      locale,
 		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(userID), 10),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+	return token.SignedString((*jwtKeys.Load())[0])
 }
 
 func ValidateJWT(tokenStr string) (*Claims, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
+		var keys jwt.VerificationKeySet
+		for _, key := range *jwtKeys.Load() {
+			keys.Keys = append(keys.Keys, key)
+		}
+		return keys, nil
 	})
 
 	if err != nil {
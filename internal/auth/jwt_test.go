@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"my-project/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWTRotation(t *testing.T) {
+	InitializeJWT(&config.Config{JWT: config.JWTConfig{Secret: "old-secret"}})
+	oldToken, err := GenerateJWT(1, "09121111111", "user", "")
+	require.NoError(t, err)
+
+	// Tokens signed with the previous secret are still accepted.
+	InitializeJWT(&config.Config{JWT: config.JWTConfig{Secret: "new-secret", PreviousSecret: "old-secret"}})
+	claims, err := ValidateJWT(oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, "1", claims.Subject)
+	newToken, err := GenerateJWT(1, "09121111111", "user", "")
+	require.NoError(t, err)
+
+	// Until the previous secret is dropped.
+	InitializeJWT(&config.Config{JWT: config.JWTConfig{Secret: "new-secret"}})
+	_, err = ValidateJWT(oldToken)
+	assert.Error(t, err)
+	_, err = ValidateJWT(newToken)
+	assert.NoError(t, err)
+}
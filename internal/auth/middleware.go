@@ -1,34 +1,77 @@
 package auth
 
 import (
+	"context"
+	"log/slog"
+	"my-project/internal/apierr"
+	"my-project/internal/i18n"
+	"my-project/internal/logging"
+	"my-project/internal/metrics"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// Users looks up the users tokens were issued to.
+type Users interface {
+	// Active reports whether the user with id still exists and isn't
+	// disabled.
+	Active(ctx context.Context, id uint) (bool, error)
+}
+
+// AuthMiddleware authenticates requests with a bearer token, as long as
+// users still has its user active, so disabling a user locks them out
+// before their tokens expire. Requests already authenticated by
+// ClientCertMiddleware are let through.
+func AuthMiddleware(users Users) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if _, authenticated := c.Get("role"); authenticated {
+			c.Next()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is missing"})
+			metrics.TokenFailed(metrics.TokenMissing)
+			apierr.Abort(c, apierr.New(http.StatusUnauthorized, apierr.CodeAuthRequired, "auth.header_missing"))
 			return
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		if tokenString == authHeader {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
+			metrics.TokenFailed(metrics.TokenMalformed)
+			apierr.Abort(c, apierr.New(http.StatusUnauthorized, apierr.CodeInvalidToken, "auth.token_format"))
 			return
 		}
 
 		claims, err := ValidateJWT(tokenString)
+		var userID uint64
+		if err == nil {
+			userID, err = strconv.ParseUint(claims.Subject, 10, 0)
+		}
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			metrics.TokenFailed(metrics.TokenInvalid)
+			apierr.Abort(c, apierr.New(http.StatusUnauthorized, apierr.CodeInvalidToken, "auth.token_invalid"))
+			return
+		}
+		active, err := users.Active(c.Request.Context(), uint(userID))
+		if err != nil {
+			apierr.Abort(c, apierr.Internal(err, "error.unexpected"))
+			return
+		}
+		if !active {
+			metrics.TokenFailed(metrics.TokenDisabled)
+			apierr.Abort(c, apierr.New(http.StatusForbidden, apierr.CodeUserDisabled, "auth.account_disabled"))
 			return
 		}
 
 		c.Set("phone_number", claims.PhoneNumber)
 		c.Set("role", claims.Role)
+		logging.AddAttrs(c, slog.String("user_id", claims.Subject), slog.String("role", claims.Role))
+		// The locale the user chose wins over the one their client asks for.
+		i18n.SetLocale(c, claims.Locale)
 		c.Next()
 	}
 }
@@ -37,18 +80,18 @@ func RoleAuthMiddleware(requiredRole string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		role, exists := c.Get("role")
 		if !exists {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "User role not found in context"})
+			apierr.Abort(c, apierr.New(http.StatusForbidden, apierr.CodeForbidden, "auth.role_missing"))
 			return
 		}
 
 		userRole, ok := role.(string)
 		if !ok {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Invalid role format in context"})
+			apierr.Abort(c, apierr.New(http.StatusForbidden, apierr.CodeForbidden, "auth.role_format"))
 			return
 		}
 
 		if userRole != requiredRole {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "You are not authorized to perform this action"})
+			apierr.Abort(c, apierr.New(http.StatusForbidden, apierr.CodeForbidden, "auth.forbidden"))
 			return
 		}
 
@@ -1,15 +1,28 @@
 package auth
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// tracer traces password hashing, which is slow on purpose and so worth
+// telling apart from the rest of a login.
+var tracer = otel.Tracer("my-project/internal/auth")
 
 // HashPassword hashes a password using bcrypt.
-func HashPassword(password string) (string, error) {
+func HashPassword(ctx context.Context, password string) (string, error) {
+	_, span := tracer.Start(ctx, "auth.HashPassword")
+	defer span.End()
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
 	return string(bytes), err
 }
 
 // CheckPasswordHash compares a password with a hash.
-func CheckPasswordHash(password, hash string) bool {
+func CheckPasswordHash(ctx context.Context, password, hash string) bool {
+	_, span := tracer.Start(ctx, "auth.CheckPasswordHash")
+	defer span.End()
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
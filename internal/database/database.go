@@ -1,26 +1,339 @@
 package database
 
 import (
-	"log"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
 	"my-project/config"
 	"my-project/internal/models"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/glebarez/sqlite"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
 )
 
-var DB *gorm.DB
+// password is the password new connections log in with.
+var password atomic.Pointer[string]
 
-func Connect(cfg *config.Config) {
-	var err error
-	dsn := cfg.DSN()
-	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+// SetPassword changes the password new connections log in with, when it
+// is rotated. Open connections are kept, since PostgreSQL only checks the
+// password when connecting.
+func SetPassword(p string) {
+	password.Store(&p)
+}
+
+// replicas names the read replicas registered with dbresolver.
+const replicas = "replicas"
+
+// replicaPools keeps the connection pools of the read replicas, which
+// dbresolver doesn't expose, so that Close can close them. It is
+// registered as a GORM plugin.
+type replicaPools []*sql.DB
+
+func (replicaPools) Name() string { return "replica_pools" }
+
+func (replicaPools) Initialize(*gorm.DB) error { return nil }
+
+// Connect opens the database described by cfg. Postgres is retried with
+// backoff for cfg.DB.ConnectTimeout while it isn't reachable, and reads
+// made with WithReplica are spread over cfg.DB.ReplicaHosts. The schema is
+// managed by cmd/migrate, unless cfg.DB.AutoMigrate is set for development.
+func Connect(cfg *config.Config) *gorm.DB {
+	db, err := Open(cfg)
+	if err != nil {
+		slog.Error("Failed to connect to database", "driver", cfg.DB.Driver, "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Database connection established", "driver", cfg.DB.Driver)
+
+	if cfg.DB.AutoMigrate {
+		if err := AutoMigrate(db); err != nil {
+			slog.Error("Failed to migrate database schema", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Database schema auto-migrated")
+	}
+	return db
+}
+
+// Open opens the database described by cfg like Connect, but returns
+// errors and never migrates.
+func Open(cfg *config.Config) (*gorm.DB, error) {
+	if cfg.DB.Driver == config.SQLite {
+		return connectSQLite(cfg)
+	}
+	return connectPostgres(cfg)
+}
+
+// connectPostgres opens the Postgres primary and its read replicas.
+func connectPostgres(cfg *config.Config) (*gorm.DB, error) {
+	SetPassword(cfg.DB.Password)
+	primary, err := open(cfg, cfg.DB.Host, cfg.DB.Port)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		return nil, err
 	}
-	log.Println("Database connection established")
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: primary}), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	if hosts := replicaHosts(cfg.DB.ReplicaHosts); len(hosts) > 0 {
+		var dialectors []gorm.Dialector
+		var pools replicaPools
+		for _, host := range hosts {
+			h, port := splitHostPort(host, cfg.DB.Port)
+			replica, err := open(cfg, h, port)
+			if err != nil {
+				return nil, fmt.Errorf("replica %s: %w", host, err)
+			}
+			dialectors = append(dialectors, postgres.New(postgres.Config{Conn: replica}))
+			pools = append(pools, replica)
+		}
+		if err := db.Use(dbresolver.Register(dbresolver.Config{Replicas: dialectors}, replicas)); err != nil {
+			return nil, err
+		}
+		if err := db.Use(pools); err != nil {
+			return nil, err
+		}
+		slog.Info("Database replicas connected", "replicas", len(dialectors))
+	}
+	return db, nil
+}
 
-	// Auto-migrate the schema
-	DB.AutoMigrate(&models.User{})
-	log.Println("Database schema migrated")
+// memory is the name of SQLite databases held in memory.
+const memory = ":memory:"
+
+// connectSQLite opens the SQLite database file cfg.DB.Name, or an
+// in-memory one. The driver is pure Go, so the binaries still build
+// without cgo.
+func connectSQLite(cfg *config.Config) (*gorm.DB, error) {
+	db, err := gorm.Open(sqlite.Open(sqliteDSN(cfg.DB.Name)), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	setPool(sqlDB, cfg)
+	if cfg.DB.Name == memory {
+		// The database only lives as long as one of its connections.
+		sqlDB.SetMaxIdleConns(max(cfg.DB.MaxIdleConns, 1))
+		sqlDB.SetConnMaxLifetime(0)
+		sqlDB.SetConnMaxIdleTime(0)
+	}
+	return db, sqlDB.Ping()
+}
+
+// sqliteDSN returns the connection string of the SQLite database name.
+// Foreign keys are enforced as in Postgres, and writers wait for each other
+// instead of failing.
+func sqliteDSN(name string) string {
+	const pragmas = "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+	if name == memory {
+		// Every connection of the pool shares the same database.
+		return "file::memory:?cache=shared&" + pragmas
+	}
+	return "file:" + name + "?_pragma=journal_mode(WAL)&" + pragmas
+}
+
+// setPool sizes the connection pool of sqlDB as configured.
+func setPool(sqlDB *sql.DB, cfg *config.Config) {
+	sqlDB.SetMaxOpenConns(cfg.DB.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.DB.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.DB.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(cfg.DB.ConnMaxIdleTime)
+}
+
+// open opens a connection pool to the server at host and port, sized by
+// cfg, and waits until it answers.
+func open(cfg *config.Config, host, port string) (*sql.DB, error) {
+	connConfig, err := pgx.ParseConfig(cfg.DSN())
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q of %s", port, host)
+	}
+	connConfig.Host = host
+	connConfig.Port = uint16(p)
+	sqlDB := stdlib.OpenDB(*connConfig, stdlib.OptionBeforeConnect(func(ctx context.Context, cc *pgx.ConnConfig) error {
+		cc.Password = *password.Load()
+		return nil
+	}))
+	setPool(sqlDB, cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DB.ConnectTimeout)
+	defer cancel()
+	err = retry(ctx, func() error {
+		err := sqlDB.PingContext(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("Database isn't reachable yet, retrying", "host", host, "error", err)
+		}
+		return err
+	})
+	if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return sqlDB, nil
+}
+
+// Backoff between connection attempts starts at minBackoff and doubles up
+// to maxBackoff.
+const (
+	minBackoff = 250 * time.Millisecond
+	maxBackoff = 5 * time.Second
+)
+
+// retry calls f until it succeeds or ctx is done, backing off between
+// attempts. It returns the last error of f.
+func retry(ctx context.Context, f func() error) error {
+	backoff := minBackoff
+	for {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// replicaHosts splits a comma-separated list of hosts.
+func replicaHosts(list string) []string {
+	var hosts []string
+	for _, host := range strings.Split(list, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// splitHostPort splits host:port, with port defaulting to defaultPort.
+func splitHostPort(hostport, defaultPort string) (string, string) {
+	if host, port, err := net.SplitHostPort(hostport); err == nil {
+		return host, port
+	}
+	return hostport, defaultPort
+}
+
+// AutoMigrate creates the schema from the models with GORM's AutoMigrate.
+// It can't drop or rename columns or backfill data, so it is only meant for
+// development; deployments apply the versioned migrations instead.
+func AutoMigrate(db *gorm.DB) error {
+	err := db.AutoMigrate(
+		&models.User{},
+		&models.AuditEvent{},
+		&models.WebhookSubscription{},
+		&models.OutboxEvent{},
+		&models.WebhookDelivery{},
+		&models.WebhookDeliveryAttempt{},
+	)
+	if err != nil {
+		return err
+	}
+	if err := dropLegacyIndexes(db); err != nil {
+		return err
+	}
+	if err := createSearchIndexes(db); err != nil {
+		return err
+	}
+	return createAuditTriggers(db)
+}
+
+// IsPostgres reports whether db is backed by PostgreSQL.
+func IsPostgres(db *gorm.DB) bool {
+	return db.Dialector.Name() == "postgres"
+}
+
+// dropLegacyIndexes removes the unique indexes that covered soft-deleted rows
+// too. They have been replaced by partial indexes over non-deleted users.
+func dropLegacyIndexes(db *gorm.DB) error {
+	for _, name := range []string{"idx_users_phone_number", "idx_users_email"} {
+		if db.Migrator().HasIndex(&models.User{}, name) {
+			if err := db.Migrator().DropIndex(&models.User{}, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// createSearchIndexes adds the full-text and trigram indexes used by user
+// search, like the migrations do. Other databases fall back to LIKE scans
+// and need no extra indexes.
+func createSearchIndexes(db *gorm.DB) error {
+	if !IsPostgres(db) {
+		return nil
+	}
+	statements := []string{
+		"CREATE EXTENSION IF NOT EXISTS pg_trgm",
+		"CREATE INDEX IF NOT EXISTS idx_users_phone_number_trgm ON users USING gin (phone_number gin_trgm_ops)",
+		"CREATE INDEX IF NOT EXISTS idx_users_email_trgm ON users USING gin (email gin_trgm_ops)",
+		"CREATE INDEX IF NOT EXISTS idx_users_search ON users USING gin (to_tsvector('simple', phone_number || ' ' || email))",
+	}
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createAuditTriggers makes audit_events append-only at the database level,
+// so rows can't be changed even bypassing the application.
+func createAuditTriggers(db *gorm.DB) error {
+	if !IsPostgres(db) {
+		return nil
+	}
+	statements := []string{
+		`CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_events is append-only';
+		END;
+		$$ LANGUAGE plpgsql`,
+		"DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events",
+		"CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events FOR EACH ROW EXECUTE FUNCTION audit_events_append_only()",
+		"DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events",
+		"CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only()",
+	}
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the connection pools of db and of its read replicas.
+func Close(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	errs := []error{sqlDB.Close()}
+	if pools, ok := db.Config.Plugins[replicaPools(nil).Name()].(replicaPools); ok {
+		for _, pool := range pools {
+			errs = append(errs, pool.Close())
+		}
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+func TestRetry(t *testing.T) {
+	attempts := 0
+	err := retry(context.Background(), func() error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+
+	// Gives up with the last error once ctx is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = retry(ctx, func() error { return errors.New("connection refused") })
+	assert.EqualError(t, err, "connection refused")
+}
+
+func TestSplitHostPort(t *testing.T) {
+	host, port := splitHostPort("replica-1:6432", "5432")
+	assert.Equal(t, "replica-1", host)
+	assert.Equal(t, "6432", port)
+	host, port = splitHostPort("replica-2", "5432")
+	assert.Equal(t, "replica-2", host)
+	assert.Equal(t, "5432", port)
+
+	assert.Equal(t, []string{"a", "b:6432"}, replicaHosts(" a, ,b:6432,"))
+	assert.Empty(t, replicaHosts(""))
+}
+
+func TestReplicaReads(t *testing.T) {
+	type item struct {
+		ID   uint
+		Name string
+	}
+	dir := t.TempDir()
+	open := func(name string) *gorm.DB {
+		db, err := gorm.Open(sqlite.Open(filepath.Join(dir, name)), &gorm.Config{})
+		require.NoError(t, err)
+		require.NoError(t, db.AutoMigrate(&item{}))
+		require.NoError(t, db.Create(&item{ID: 1, Name: name}).Error)
+		return db
+	}
+	db := open("primary.db")
+	open("replica.db")
+	require.NoError(t, db.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: []gorm.Dialector{sqlite.Open(filepath.Join(dir, "replica.db"))},
+	}, replicas)))
+
+	ctx := context.Background()
+	var got item
+	require.NoError(t, Conn(ctx, db).First(&got, 1).Error)
+	assert.Equal(t, "primary.db", got.Name)
+	require.NoError(t, Conn(WithReplica(ctx), db).First(&got, 1).Error)
+	assert.Equal(t, "replica.db", got.Name)
+
+	// Writes and transactions stay on the primary.
+	require.NoError(t, Conn(WithReplica(ctx), db).Model(&item{}).Where("id = ?", 1).Update("name", "updated").Error)
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		return Conn(WithReplica(WithTx(ctx, tx)), db).First(&got, 1).Error
+	}))
+	assert.Equal(t, "updated", got.Name)
+}
+
+func TestCloseClosesReplicas(t *testing.T) {
+	dir := t.TempDir()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "primary.db")), &gorm.Config{})
+	require.NoError(t, err)
+	replica, err := gorm.Open(sqlite.Open(filepath.Join(dir, "replica.db")), &gorm.Config{})
+	require.NoError(t, err)
+	pool, err := replica.DB()
+	require.NoError(t, err)
+	require.NoError(t, db.Use(replicaPools{pool}))
+
+	require.NoError(t, Close(db))
+	assert.Error(t, pool.Ping())
+}
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+type (
+	txKey      struct{}
+	replicaKey struct{}
+)
+
+// WithTx returns a context carrying tx, so code called with it takes part
+// in the transaction.
+func WithTx(ctx context.Context, tx *gorm.DB) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// WithReplica returns a context whose reads may be served by a read
+// replica, for reads that can be slightly stale. Writes still go to the
+// primary, and so does everything inside a transaction.
+func WithReplica(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replicaKey{}, true)
+}
+
+// Conn returns the transaction carried by ctx, or db if there is none.
+func Conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx
+	}
+	if ctx.Value(replicaKey{}) != nil {
+		return db.WithContext(ctx).Clauses(dbresolver.Use(replicas))
+	}
+	return db.WithContext(ctx)
+}
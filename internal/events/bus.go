@@ -0,0 +1,139 @@
+// Package events is an in-process, typed event bus for domain events.
+//
+// Synchronous subscribers run inline when an event is published, inside the
+// publisher's transaction if there is one, and an error from any of them
+// aborts the operation. Asynchronous subscribers run in the background once
+// the operation has committed, so they never see events that were rolled
+// back.
+package events
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"sync"
+)
+
+// Event is something that happened in the domain.
+type Event interface {
+	EventName() string
+}
+
+type syncHandler func(context.Context, Event) error
+type asyncHandler func(context.Context, Event)
+
+// Bus routes published events to the subscribers of their type.
+type Bus struct {
+	mu    sync.RWMutex
+	sync  map[reflect.Type][]syncHandler
+	async map[reflect.Type][]asyncHandler
+	wg    sync.WaitGroup
+}
+
+// NewBus returns a Bus with no subscribers.
+func NewBus() *Bus {
+	return &Bus{
+		sync:  map[reflect.Type][]syncHandler{},
+		async: map[reflect.Type][]asyncHandler{},
+	}
+}
+
+// Subscribe registers fn to run synchronously whenever an event of type E
+// is published. Subscribers run in registration order.
+func Subscribe[E Event](b *Bus, fn func(context.Context, E) error) {
+	t := reflect.TypeFor[E]()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sync[t] = append(b.sync[t], func(ctx context.Context, e Event) error {
+		return fn(ctx, e.(E))
+	})
+}
+
+// SubscribeAsync registers fn to run in the background whenever an event
+// of type E is published and its operation commits.
+func SubscribeAsync[E Event](b *Bus, fn func(context.Context, E)) {
+	t := reflect.TypeFor[E]()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.async[t] = append(b.async[t], func(ctx context.Context, e Event) {
+		fn(ctx, e.(E))
+	})
+}
+
+// Publish runs the synchronous subscribers of e and returns the first error.
+// Asynchronous subscribers are started once the operation deferred in ctx
+// commits, or right away if there is none.
+func (b *Bus) Publish(ctx context.Context, e Event) error {
+	t := reflect.TypeOf(e)
+	b.mu.RLock()
+	syncHandlers := b.sync[t]
+	asyncHandlers := b.async[t]
+	b.mu.RUnlock()
+
+	for _, handler := range syncHandlers {
+		if err := handler(ctx, e); err != nil {
+			return err
+		}
+	}
+
+	if len(asyncHandlers) == 0 {
+		return nil
+	}
+	run := func() { b.runAsync(context.WithoutCancel(ctx), e, asyncHandlers) }
+	if d, ok := ctx.Value(deferredKey{}).(*deferred); ok {
+		d.add(run)
+		return nil
+	}
+	run()
+	return nil
+}
+
+func (b *Bus) runAsync(ctx context.Context, e Event, handlers []asyncHandler) {
+	for _, handler := range handlers {
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					slog.Error("Async subscriber panicked", "event", e.EventName(), "panic", r)
+				}
+			}()
+			handler(ctx, e)
+		}()
+	}
+}
+
+// Wait blocks until all running asynchronous subscribers have returned.
+func (b *Bus) Wait() {
+	b.wg.Wait()
+}
+
+type deferredKey struct{}
+
+type deferred struct {
+	mu  sync.Mutex
+	fns []func()
+}
+
+func (d *deferred) add(fn func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.fns = append(d.fns, fn)
+}
+
+// Defer returns a context in which asynchronous subscribers are held back
+// until commit is called. Call commit once the operation's transaction has
+// committed; if it rolls back, don't call it and the events are dropped.
+func Defer(ctx context.Context) (context.Context, func()) {
+	d := &deferred{}
+	commit := func() {
+		d.mu.Lock()
+		fns := d.fns
+		d.fns = nil
+		d.mu.Unlock()
+		for _, fn := range fns {
+			fn()
+		}
+	}
+	return context.WithValue(ctx, deferredKey{}, d), commit
+}
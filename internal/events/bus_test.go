@@ -0,0 +1,53 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type pinged struct{ N int }
+
+func (pinged) EventName() string { return "test.pinged" }
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	var syncSeen []int
+	asyncSeen := make(chan int, 10)
+	Subscribe(bus, func(ctx context.Context, e pinged) error {
+		syncSeen = append(syncSeen, e.N)
+		if e.N < 0 {
+			return errors.New("negative")
+		}
+		return nil
+	})
+	SubscribeAsync(bus, func(ctx context.Context, e pinged) {
+		asyncSeen <- e.N
+	})
+
+	// Without a deferred operation, async subscribers start right away.
+	assert.NoError(t, bus.Publish(context.Background(), pinged{N: 1}))
+	bus.Wait()
+	assert.Equal(t, []int{1}, syncSeen)
+	assert.Equal(t, 1, <-asyncSeen)
+
+	// Sync errors are returned to the publisher.
+	assert.Error(t, bus.Publish(context.Background(), pinged{N: -1}))
+
+	// Deferred async subscribers only run on commit.
+	ctx, commit := Defer(context.Background())
+	assert.NoError(t, bus.Publish(ctx, pinged{N: 2}))
+	bus.Wait()
+	assert.Empty(t, asyncSeen)
+	commit()
+	bus.Wait()
+	assert.Equal(t, 2, <-asyncSeen)
+
+	// Rolled back operations never commit, so their events are dropped.
+	ctx, _ = Defer(context.Background())
+	assert.NoError(t, bus.Publish(ctx, pinged{N: 3}))
+	bus.Wait()
+	assert.Empty(t, asyncSeen)
+}
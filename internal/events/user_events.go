@@ -0,0 +1,135 @@
+package events
+
+import (
+	"context"
+	"my-project/internal/models"
+	"time"
+)
+
+// Login methods and verification code channels.
+const (
+	MethodPassword = "password"
+	MethodSMS      = "sms"
+	MethodEmail    = "email"
+)
+
+// Metadata describes the request that caused an event.
+type Metadata struct {
+	// Actor is the phone number of the authenticated caller, if any.
+	Actor     string
+	ActorRole string
+	IP        string
+	UserAgent string
+	RequestID string
+}
+
+type metadataKey struct{}
+
+// WithMetadata returns a context carrying md for the events published with it.
+func WithMetadata(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+// MetadataFrom returns the metadata carried by ctx, or zero Metadata if there is none.
+func MetadataFrom(ctx context.Context) Metadata {
+	md, _ := ctx.Value(metadataKey{}).(Metadata)
+	return md
+}
+
+// UserCreated is published when a user signs up or is imported.
+type UserCreated struct {
+	User     models.User
+	Imported bool
+}
+
+func (UserCreated) EventName() string { return "user.created" }
+
+// UserUpdated is published when an admin edits a user.
+type UserUpdated struct {
+	Before models.User
+	After  models.User
+}
+
+func (UserUpdated) EventName() string { return "user.updated" }
+
+// RoleAssigned is published when an admin assigns a role to a user.
+type RoleAssigned struct {
+	Before models.User
+	After  models.User
+}
+
+func (RoleAssigned) EventName() string { return "user.role_assigned" }
+
+// UserDeleted is published when a user is soft-deleted or purged.
+type UserDeleted struct {
+	User   models.User
+	Purged bool
+}
+
+func (UserDeleted) EventName() string { return "user.deleted" }
+
+// UserRestored is published when a soft-deleted user is restored.
+type UserRestored struct {
+	Before models.User
+	After  models.User
+}
+
+func (UserRestored) EventName() string { return "user.restored" }
+
+// UserDisabled is published when a user is disabled.
+type UserDisabled struct {
+	Before models.User
+	After  models.User
+}
+
+func (UserDisabled) EventName() string { return "user.disabled" }
+
+// UserEnabled is published when a disabled user is enabled again.
+type UserEnabled struct {
+	Before models.User
+	After  models.User
+}
+
+func (UserEnabled) EventName() string { return "user.enabled" }
+
+// VerificationCodeIssued is published when a login code has been stored
+// and must be sent to the user over Channel, in Locale.
+type VerificationCodeIssued struct {
+	User      models.User
+	Channel   string
+	Recipient string
+	Code      string
+	ExpiresAt time.Time
+	Locale    string
+}
+
+func (VerificationCodeIssued) EventName() string { return "user.verification_code_issued" }
+
+// UserVerified is published when a user proves they own their phone number
+// or email by entering a code sent over Channel.
+type UserVerified struct {
+	User    models.User
+	Channel string
+}
+
+func (UserVerified) EventName() string { return "user.verified" }
+
+// UserLoggedIn is published after a successful login. Identifier is the
+// phone number or email the user logged in with.
+type UserLoggedIn struct {
+	User       models.User
+	Method     string
+	Identifier string
+}
+
+func (UserLoggedIn) EventName() string { return "user.logged_in" }
+
+// LoginFailed is published after a failed login. User is nil if no user
+// has the identifier that was tried.
+type LoginFailed struct {
+	User       *models.User
+	Method     string
+	Identifier string
+}
+
+func (LoginFailed) EventName() string { return "user.login_failed" }
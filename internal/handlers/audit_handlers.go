@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"my-project/internal/apierr"
+	"my-project/internal/audit"
+	"my-project/internal/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// auditQuery builds the query for audit events from the filters in the
+// request. It is shared by ListAuditEvents and ExportAuditEvents.
+func (h *Handler) auditQuery(c *gin.Context) (*gorm.DB, error) {
+	query := h.conn(c).Model(&models.AuditEvent{})
+	for param, column := range map[string]string{
+		"actor":       "actor",
+		"action":      "action",
+		"outcome":     "outcome",
+		"target_type": "target_type",
+		"target_id":   "target_id",
+		"request_id":  "request_id",
+	} {
+		if value := c.Query(param); value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	if raw := c.Query("from"); raw != "" {
+		from, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, apierr.New(http.StatusBadRequest, apierr.CodeInvalidParameter, "request.invalid_time_parameter", "from")
+		}
+		query = query.Where("created_at >= ?", from)
+	}
+	if raw := c.Query("to"); raw != "" {
+		to, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, apierr.New(http.StatusBadRequest, apierr.CodeInvalidParameter, "request.invalid_time_parameter", "to")
+		}
+		query = query.Where("created_at < ?", to)
+	}
+	return query, nil
+}
+
+// ListAuditEvents godoc
+// @Summary      List audit events
+// @Description  Lists audit events, newest first, matching the given filters (admin only)
+// @Tags         audit
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        actor        query     string  false  "Phone number or email of the actor"
+// @Param        action       query     string  false  "Action, e.g. user.delete"
+// @Param        outcome      query     string  false  "success or failure"
+// @Param        target_type  query     string  false  "Type of the target, e.g. user"
+// @Param        target_id    query     string  false  "ID of the target"
+// @Param        request_id   query     string  false  "Request ID"
+// @Param        from         query     string  false  "Only events at or after this RFC 3339 time"
+// @Param        to           query     string  false  "Only events before this RFC 3339 time"
+// @Param        limit        query     int     false  "Maximum number of events"
+// @Param        offset       query     int     false  "Number of events to skip"
+// @Success      200          {array}   models.AuditEvent
+// @Failure      400          {object}  apierr.Problem
+// @Failure      500          {object}  apierr.Problem
+// @Router       /api/v1/audit [get]
+func (h *Handler) ListAuditEvents(c *gin.Context) {
+	query, err := h.auditQuery(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	limit, offset := defaultAuditLimit, 0
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			c.Error(invalidParameter("limit"))
+			return
+		}
+		limit = min(n, maxAuditLimit)
+	}
+	if raw := c.Query("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			c.Error(invalidParameter("offset"))
+			return
+		}
+		offset = n
+	}
+
+	var events []models.AuditEvent
+	if err := query.Order("id DESC").Limit(limit).Offset(offset).Find(&events).Error; err != nil {
+		c.Error(apierr.Internal(err, "audit.list_failed"))
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+var auditExportColumns = []string{"id", "created_at", "actor", "actor_role", "action", "outcome", "target_type", "target_id", "changes", "ip", "user_agent", "request_id", "prev_hash", "hash"}
+
+// ExportAuditEvents godoc
+// @Summary      Export audit events
+// @Description  Streams audit events, oldest first, as CSV or NDJSON with the same filters as listing them (admin only)
+// @Tags         audit
+// @Produce      text/csv,application/x-ndjson
+// @Security     ApiKeyAuth
+// @Param        format       query     string  false  "csv (default) or ndjson"
+// @Param        actor        query     string  false  "Phone number or email of the actor"
+// @Param        action       query     string  false  "Action, e.g. user.delete"
+// @Param        outcome      query     string  false  "success or failure"
+// @Param        target_type  query     string  false  "Type of the target, e.g. user"
+// @Param        target_id    query     string  false  "ID of the target"
+// @Param        request_id   query     string  false  "Request ID"
+// @Param        from         query     string  false  "Only events at or after this RFC 3339 time"
+// @Param        to           query     string  false  "Only events before this RFC 3339 time"
+// @Success      200          {string}  string
+// @Failure      400          {object}  apierr.Problem
+// @Router       /api/v1/audit/export [get]
+func (h *Handler) ExportAuditEvents(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "ndjson" {
+		c.Error(apierr.New(http.StatusBadRequest, apierr.CodeInvalidParameter, "request.invalid_export_format"))
+		return
+	}
+
+	query, err := h.auditQuery(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var csvWriter *csv.Writer
+	var encoder *json.Encoder
+	if format == "csv" {
+		c.Header("Content-Type", csvContentType)
+		csvWriter = csv.NewWriter(c.Writer)
+		csvWriter.Write(auditExportColumns)
+	} else {
+		c.Header("Content-Type", ndjsonContentType)
+		encoder = json.NewEncoder(c.Writer)
+	}
+	c.Header("Content-Disposition", `attachment; filename="audit.`+format+`"`)
+	c.Status(http.StatusOK)
+
+	var batch []models.AuditEvent
+	result := query.Order("id").FindInBatches(&batch, exportBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, event := range batch {
+			if csvWriter != nil {
+				if err := csvWriter.Write(auditCSVRecord(event)); err != nil {
+					return err
+				}
+			} else if err := encoder.Encode(event); err != nil {
+				return err
+			}
+		}
+		if csvWriter != nil {
+			csvWriter.Flush()
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if csvWriter != nil {
+		csvWriter.Flush()
+	}
+	if result.Error != nil {
+		// The status line is already sent, so all we can do is cut the stream short.
+		c.Error(result.Error)
+		c.Abort()
+	}
+}
+
+func auditCSVRecord(event models.AuditEvent) []string {
+	changes := ""
+	if len(event.Changes) > 0 {
+		b, _ := json.Marshal(event.Changes)
+		changes = string(b)
+	}
+	return []string{
+		strconv.FormatUint(uint64(event.ID), 10),
+		event.CreatedAt.UTC().Format(time.RFC3339Nano),
+		event.Actor,
+		event.ActorRole,
+		event.Action,
+		event.Outcome,
+		event.TargetType,
+		event.TargetID,
+		changes,
+		event.IP,
+		event.UserAgent,
+		event.RequestID,
+		event.PrevHash,
+		event.Hash,
+	}
+}
+
+// VerifyAuditLog godoc
+// @Summary      Verify the audit log
+// @Description  Checks the hash chain of the whole audit log and reports the first tampered event, if any (admin only)
+// @Tags         audit
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Success      200  {object}  audit.VerifyResult
+// @Failure      500  {object}  apierr.Problem
+// @Router       /api/v1/audit/verify [get]
+func (h *Handler) VerifyAuditLog(c *gin.Context) {
+	result, err := audit.Verify(h.conn(c))
+	if err != nil {
+		c.Error(apierr.Internal(err, "audit.verify_failed"))
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -1,13 +1,10 @@
 package handlers
 
 import (
-	"log"
-	"my-project/internal/auth"
-	"my-project/internal/database"
-	"my-project/internal/models"
-	"my-project/pkg/utils"
+	"my-project/internal/apierr"
+	"my-project/internal/events"
+	"my-project/internal/i18n"
 	"net/http"
-	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -25,31 +22,21 @@ type LoginRequest struct {
 // @Produce      json
 // @Param        login  body      LoginRequest  true  "Login credentials"
 // @Success      200    {object}  map[string]string
-// @Failure      400    {object}  map[string]string
-// @Failure      401    {object}  map[string]string
-// @Failure      500    {object}  map[string]string
+// @Failure      400    {object}  apierr.Problem
+// @Failure      401    {object}  apierr.Problem
+// @Failure      403    {object}  apierr.Problem
+// @Failure      500    {object}  apierr.Problem
 // @Router       /login [post]
-func Login(c *gin.Context) {
+func (h *Handler) Login(c *gin.Context) {
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(apierr.FromBinding(err))
 		return
 	}
 
-	var user models.User
-	if err := database.DB.Where("phone_number = ?", req.PhoneNumber).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
-		return
-	}
-
-	if !auth.CheckPasswordHash(req.Password, user.Password) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
-		return
-	}
-
-	token, err := auth.GenerateJWT(user.PhoneNumber, user.Role)
+	token, err := h.users.Login(requestContext(c), req.PhoneNumber, req.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		c.Error(serviceError(err, "auth.token_failed"))
 		return
 	}
 
@@ -68,37 +55,24 @@ type RequestCodeRequest struct {
 // @Produce      json
 // @Param        phone  body      RequestCodeRequest  true  "Phone number"
 // @Success      200    {object}  map[string]string
-// @Failure      400    {object}  map[string]string
-// @Failure      404    {object}  map[string]string
-// @Failure      500    {object}  map[string]string
+// @Failure      400    {object}  apierr.Problem
+// @Failure      403    {object}  apierr.Problem
+// @Failure      404    {object}  apierr.Problem
+// @Failure      500    {object}  apierr.Problem
 // @Router       /login/sms/request [post]
-func RequestSMSCode(c *gin.Context) {
+func (h *Handler) RequestSMSCode(c *gin.Context) {
 	var req RequestCodeRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(apierr.FromBinding(err))
 		return
 	}
 
-	var user models.User
-	if err := database.DB.Where("phone_number = ?", req.PhoneNumber).First(&user).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	if err := h.users.RequestCode(requestContext(c), events.MethodSMS, req.PhoneNumber); err != nil {
+		c.Error(serviceError(err, "auth.code_send_failed"))
 		return
 	}
 
-	code := utils.GenerateRandomCode(6)
-	user.VerificationCode = code
-	user.VerificationCodeExpiresAt = time.Now().Add(5 * time.Minute)
-
-	if err := database.DB.Save(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save verification code"})
-		return
-	}
-
-	// In a real application, you would send the code via SMS here.
-	// For this example, we'll just log it.
-	log.Printf("Verification code for %s: %s\n", user.PhoneNumber, code)
-
-	c.JSON(http.StatusOK, gin.H{"message": "Verification code sent"})
+	c.JSON(http.StatusOK, gin.H{"message": i18n.T(c.Request.Context(), "auth.code_sent")})
 }
 
 type VerifyCodeRequest struct {
@@ -114,36 +88,22 @@ type VerifyCodeRequest struct {
 // @Produce      json
 // @Param        verification  body      VerifyCodeRequest  true  "Phone number and code"
 // @Success      200           {object}  map[string]string
-// @Failure      400           {object}  map[string]string
-// @Failure      401           {object}  map[string]string
-// @Failure      404           {object}  map[string]string
-// @Failure      500           {object}  map[string]string
+// @Failure      400           {object}  apierr.Problem
+// @Failure      401           {object}  apierr.Problem
+// @Failure      403           {object}  apierr.Problem
+// @Failure      404           {object}  apierr.Problem
+// @Failure      500           {object}  apierr.Problem
 // @Router       /login/sms/verify [post]
-func VerifySMSCode(c *gin.Context) {
+func (h *Handler) VerifySMSCode(c *gin.Context) {
 	var req VerifyCodeRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	var user models.User
-	if err := database.DB.Where("phone_number = ?", req.PhoneNumber).First(&user).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-		return
-	}
-
-	if user.VerificationCode == "" || user.VerificationCode != req.Code || time.Now().After(user.VerificationCodeExpiresAt) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired verification code"})
+		c.Error(apierr.FromBinding(err))
 		return
 	}
 
-	// Invalidate the code
-	user.VerificationCode = ""
-	database.DB.Save(&user)
-
-	token, err := auth.GenerateJWT(user.PhoneNumber, user.Role)
+	token, err := h.users.VerifyCode(requestContext(c), events.MethodSMS, req.PhoneNumber, req.Code)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		c.Error(serviceError(err, "auth.code_verify_failed"))
 		return
 	}
 
@@ -162,35 +122,24 @@ type RequestEmailCodeRequest struct {
 // @Produce      json
 // @Param        email  body      RequestEmailCodeRequest  true  "Email"
 // @Success      200    {object}  map[string]string
-// @Failure      400    {object}  map[string]string
-// @Failure      404    {object}  map[string]string
-// @Failure      500    {object}  map[string]string
+// @Failure      400    {object}  apierr.Problem
+// @Failure      403    {object}  apierr.Problem
+// @Failure      404    {object}  apierr.Problem
+// @Failure      500    {object}  apierr.Problem
 // @Router       /login/email/request [post]
-func RequestEmailCode(c *gin.Context) {
+func (h *Handler) RequestEmailCode(c *gin.Context) {
 	var req RequestEmailCodeRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	var user models.User
-	if err := database.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.Error(apierr.FromBinding(err))
 		return
 	}
 
-	code := utils.GenerateRandomCode(6)
-	user.EmailVerificationCode = code
-	user.EmailVerificationCodeExpiresAt = time.Now().Add(5 * time.Minute)
-
-	if err := database.DB.Save(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save verification code"})
+	if err := h.users.RequestCode(requestContext(c), events.MethodEmail, req.Email); err != nil {
+		c.Error(serviceError(err, "auth.code_send_failed"))
 		return
 	}
 
-	log.Printf("Verification code for %s: %s\n", user.Email, code)
-
-	c.JSON(http.StatusOK, gin.H{"message": "Verification code sent to email"})
+	c.JSON(http.StatusOK, gin.H{"message": i18n.T(c.Request.Context(), "auth.code_sent_email")})
 }
 
 type VerifyEmailCodeRequest struct {
@@ -206,35 +155,22 @@ type VerifyEmailCodeRequest struct {
 // @Produce      json
 // @Param        verification  body      VerifyEmailCodeRequest  true  "Email and code"
 // @Success      200           {object}  map[string]string
-// @Failure      400           {object}  map[string]string
-// @Failure      401           {object}  map[string]string
-// @Failure      404           {object}  map[string]string
-// @Failure      500           {object}  map[string]string
+// @Failure      400           {object}  apierr.Problem
+// @Failure      401           {object}  apierr.Problem
+// @Failure      403           {object}  apierr.Problem
+// @Failure      404           {object}  apierr.Problem
+// @Failure      500           {object}  apierr.Problem
 // @Router       /login/email/verify [post]
-func VerifyEmailCode(c *gin.Context) {
+func (h *Handler) VerifyEmailCode(c *gin.Context) {
 	var req VerifyEmailCodeRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(apierr.FromBinding(err))
 		return
 	}
 
-	var user models.User
-	if err := database.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-		return
-	}
-
-	if user.EmailVerificationCode == "" || user.EmailVerificationCode != req.Code || time.Now().After(user.EmailVerificationCodeExpiresAt) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired verification code"})
-		return
-	}
-
-	user.EmailVerificationCode = ""
-	database.DB.Save(&user)
-
-	token, err := auth.GenerateJWT(user.PhoneNumber, user.Role)
+	token, err := h.users.VerifyCode(requestContext(c), events.MethodEmail, req.Email, req.Code)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		c.Error(serviceError(err, "auth.code_verify_failed"))
 		return
 	}
 
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"my-project/internal/apierr"
+	"my-project/internal/repository"
+	"my-project/internal/services"
+	"net/http"
+)
+
+var (
+	errUserNotFound = apierr.New(http.StatusNotFound, apierr.CodeUserNotFound, "user.not_found")
+	errUserModified = apierr.New(http.StatusPreconditionFailed, apierr.CodeUserModified, "user.modified")
+)
+
+// serviceError translates an error from the user service into the error
+// returned to the client. Errors without a meaning for the client become a
+// 500 with detail as the message.
+func serviceError(err error, detail string) *apierr.Error {
+	var validationErr *services.ValidationError
+	var validationErrs services.ValidationErrors
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		return errUserNotFound
+	case errors.Is(err, services.ErrVersionConflict):
+		return errUserModified
+	case errors.Is(err, services.ErrInvalidCredentials):
+		return apierr.New(http.StatusUnauthorized, apierr.CodeInvalidCredentials, "auth.invalid_credentials")
+	case errors.Is(err, services.ErrInvalidCode):
+		return apierr.New(http.StatusUnauthorized, apierr.CodeInvalidCode, "auth.invalid_code")
+	case errors.Is(err, services.ErrUserDisabled):
+		return apierr.New(http.StatusForbidden, apierr.CodeUserDisabled, "auth.account_disabled")
+	case errors.Is(err, services.ErrIdentityTaken):
+		return apierr.New(http.StatusConflict, apierr.CodeIdentityTaken, "user.identity_taken")
+	case errors.As(err, &validationErr):
+		return apierr.Validation(fieldError(validationErr))
+	case errors.As(err, &validationErrs):
+		fields := make([]apierr.FieldError, len(validationErrs))
+		for i, err := range validationErrs {
+			fields[i] = fieldError(err)
+		}
+		return apierr.Validation(fields...)
+	default:
+		return apierr.Internal(err, detail)
+	}
+}
+
+func fieldError(err *services.ValidationError) apierr.FieldError {
+	return apierr.FieldError{Field: err.Field, Code: err.Code, Message: err.Message, Args: err.Args}
+}
+
+// invalidParameter returns the error for a query parameter that can't be
+// parsed.
+func invalidParameter(name string) *apierr.Error {
+	return apierr.New(http.StatusBadRequest, apierr.CodeInvalidParameter, "request.invalid_parameter", name)
+}
+
+// webhookError translates an error from the webhook repository into the
+// error returned to the client, like serviceError.
+func webhookError(err error, detail string) *apierr.Error {
+	switch {
+	case errors.Is(err, repository.ErrWebhookNotFound):
+		return apierr.New(http.StatusNotFound, apierr.CodeWebhookNotFound, "webhook.not_found")
+	case errors.Is(err, repository.ErrDeliveryNotFound):
+		return apierr.New(http.StatusNotFound, apierr.CodeDeliveryNotFound, "webhook.delivery_not_found")
+	default:
+		return apierr.Internal(err, detail)
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"fmt"
+	"my-project/internal/apierr"
+	"my-project/internal/models"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// userETag returns the strong entity tag for the current version of user.
+func userETag(user models.User) string {
+	return fmt.Sprintf(`"%d-%d"`, user.ID, user.Version)
+}
+
+// checkIfMatch enforces the If-Match precondition for modifying user. It
+// records the error and returns false when the request must not proceed.
+func checkIfMatch(c *gin.Context, user models.User) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		c.Error(apierr.New(http.StatusPreconditionRequired, apierr.CodePreconditionRequired, "request.if_match_required"))
+		return false
+	}
+	if !etagMatches(header, userETag(user), false) {
+		c.Header("ETag", userETag(user))
+		c.Error(errUserModified)
+		return false
+	}
+	return true
+}
+
+// etagMatches reports whether etag is listed in an If-Match or If-None-Match
+// header value. If-Match uses strong comparison, so weak tags never match it.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
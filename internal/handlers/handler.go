@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"my-project/internal/events"
+	"my-project/internal/logging"
+	"my-project/internal/repository"
+	"my-project/internal/services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Handler serves the HTTP API. Its methods are registered as routes in main.
+type Handler struct {
+	users    *services.UserService
+	webhooks repository.WebhookRepository
+	// db serves the admin endpoints for the audit log.
+	db *gorm.DB
+}
+
+// NewHandler returns a Handler using users for user operations, webhooks
+// for webhook subscriptions and db for the audit log.
+func NewHandler(users *services.UserService, webhooks repository.WebhookRepository, db *gorm.DB) *Handler {
+	return &Handler{users: users, webhooks: webhooks, db: db}
+}
+
+// requestContext returns the context of the request, carrying the metadata
+// recorded with the events it causes.
+func requestContext(c *gin.Context) context.Context {
+	return events.WithMetadata(c.Request.Context(), events.Metadata{
+		Actor:     c.GetString("phone_number"),
+		ActorRole: c.GetString("role"),
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		RequestID: logging.RequestID(c.Request.Context()),
+	})
+}
+
+// conn returns h.db bound to the context of the request, so its queries
+// are cancelled when the request times out or the client goes away.
+func (h *Handler) conn(c *gin.Context) *gorm.DB {
+	return h.db.WithContext(c.Request.Context())
+}
+
+// pathID returns the ID in the path parameter name, or an
+// INVALID_PARAMETER error if it isn't a valid ID.
+func pathID(c *gin.Context, name string) (uint, error) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 0)
+	if err != nil {
+		return 0, invalidParameter(name)
+	}
+	return uint(id), nil
+}
+
+// userID returns the user ID in the path, or 0, which matches no user, if
+// it isn't a valid ID.
+func userID(c *gin.Context) uint {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 0)
+	return uint(id)
+}
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"my-project/internal/apierr"
+	"my-project/internal/i18n"
+	"my-project/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	csvContentType    = "text/csv"
+	ndjsonContentType = "application/x-ndjson"
+
+	maxImportBodyBytes = 32 << 20
+	maxImportRows      = 50000
+	exportBatchSize    = 500
+)
+
+// ImportRowError describes why a row of an import was rejected. Rows are
+// numbered from 1, not counting the CSV header.
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	Field string `json:"field,omitempty"`
+	Error string `json:"error"`
+}
+
+// ImportReport is returned by ImportUsers.
+type ImportReport struct {
+	DryRun   bool             `json:"dry_run"`
+	Total    int              `json:"total"`
+	Imported int              `json:"imported"`
+	Errors   []ImportRowError `json:"errors"`
+}
+
+// importRow is a single user read from an import file.
+type importRow struct {
+	PhoneNumber string `json:"phone_number"`
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+	Role        string `json:"role"`
+}
+
+// ImportUsers godoc
+// @Summary      Import users
+// @Description  Creates users in bulk from a CSV file (with a phone_number,email,password,role header) or NDJSON (admin only).
+// @Description  Every row is validated like a signup. Either all rows are imported in one transaction, or none are and the per-row errors are reported.
+// @Tags         users
+// @Accept       text/csv,application/x-ndjson
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        dry_run  query     bool    false  "Only validate the rows"
+// @Param        users    body      string  true   "CSV or NDJSON users"
+// @Success      200      {object}  ImportReport
+// @Failure      400      {object}  apierr.Problem
+// @Failure      413      {object}  apierr.Problem
+// @Failure      415      {object}  apierr.Problem
+// @Failure      422      {object}  ImportReport
+// @Failure      500      {object}  apierr.Problem
+// @Router       /api/v1/users/import [post]
+func (h *Handler) ImportUsers(c *gin.Context) {
+	dryRun := false
+	if raw := c.Query("dry_run"); raw != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(raw); err != nil {
+			c.Error(invalidParameter("dry_run"))
+			return
+		}
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBodyBytes)
+	var rows []importRow
+	var err error
+	switch c.ContentType() {
+	case csvContentType:
+		rows, err = readCSVImport(body)
+	case ndjsonContentType, "application/ndjson":
+		rows, err = readNDJSONImport(body)
+	default:
+		c.Error(apierr.New(http.StatusUnsupportedMediaType, apierr.CodeUnsupportedMediaType,
+			"request.unsupported_media_type", csvContentType, ndjsonContentType))
+		return
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		c.Error(apierr.New(http.StatusRequestEntityTooLarge, apierr.CodePayloadTooLarge, "import.too_large"))
+		return
+	}
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	users := make([]*models.User, len(rows))
+	for i, row := range rows {
+		users[i] = &models.User{
+			PhoneNumber: row.PhoneNumber,
+			Email:       row.Email,
+			Password:    row.Password,
+			Role:        row.Role,
+		}
+	}
+
+	report := ImportReport{DryRun: dryRun, Total: len(rows), Errors: []ImportRowError{}}
+	importErrors, err := h.users.ValidateImport(requestContext(c), users)
+	if err != nil {
+		c.Error(apierr.Internal(err, "user.import_validate_failed"))
+		return
+	}
+	if len(importErrors) > 0 {
+		for _, importErr := range importErrors {
+			report.Errors = append(report.Errors, ImportRowError{Row: importErr.Row, Field: importErr.Field, Error: i18n.T(c.Request.Context(), importErr.Message, importErr.Args...)})
+		}
+		c.JSON(http.StatusUnprocessableEntity, report)
+		return
+	}
+	if dryRun {
+		c.JSON(http.StatusOK, report)
+		return
+	}
+
+	if err := h.users.Import(requestContext(c), users); err != nil {
+		c.Error(apierr.Internal(err, "user.import_failed"))
+		return
+	}
+
+	report.Imported = len(users)
+	c.JSON(http.StatusOK, report)
+}
+
+// importError returns the error for an import file that can't be read.
+func importError(err error, detail string, args ...any) *apierr.Error {
+	return &apierr.Error{Status: http.StatusBadRequest, Code: apierr.CodeInvalidBody, Detail: detail, Args: args, Err: err}
+}
+
+// readCSVImport reads users from CSV. The header row names the columns;
+// unknown columns are ignored so an export can be imported again.
+func readCSVImport(r io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, importError(nil, "import.csv_empty")
+	}
+	if err != nil {
+		return nil, importError(err, "import.csv_header_invalid", err.Error())
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"phone_number", "email"} {
+		if _, ok := columns[required]; !ok {
+			return nil, importError(nil, "import.csv_column_missing", required)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, importError(err, "import.csv_invalid", err.Error())
+		}
+		if len(rows) == maxImportRows {
+			return nil, importError(nil, "import.too_many_rows", maxImportRows)
+		}
+		rows = append(rows, importRow{
+			PhoneNumber: field(record, "phone_number"),
+			Email:       field(record, "email"),
+			Password:    field(record, "password"),
+			Role:        field(record, "role"),
+		})
+	}
+	return rows, nil
+}
+
+// readNDJSONImport reads users from newline-delimited JSON, one object per line.
+func readNDJSONImport(r io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []importRow
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if len(rows) == maxImportRows {
+			return nil, importError(nil, "import.too_many_rows", maxImportRows)
+		}
+		var row importRow
+		if err := json.Unmarshal([]byte(text), &row); err != nil {
+			return nil, importError(err, "import.json_invalid", line, err.Error())
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, importError(err, "import.read_failed", err.Error())
+	}
+	return rows, nil
+}
+
+var exportColumns = []string{"id", "phone_number", "email", "role", "version", "created_at", "updated_at", "deleted_at"}
+
+// ExportUsers godoc
+// @Summary      Export users
+// @Description  Streams users as CSV or NDJSON, with the same filters as listing users (admin only)
+// @Tags         users
+// @Produce      text/csv,application/x-ndjson
+// @Security     ApiKeyAuth
+// @Param        format   query     string  false  "csv (default) or ndjson"
+// @Param        deleted  query     bool    false  "Export soft-deleted users instead"
+// @Success      200      {string}  string
+// @Failure      400      {object}  apierr.Problem
+// @Failure      500      {object}  apierr.Problem
+// @Router       /api/v1/users/export [get]
+func (h *Handler) ExportUsers(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "ndjson" {
+		c.Error(apierr.New(http.StatusBadRequest, apierr.CodeInvalidParameter, "request.invalid_export_format"))
+		return
+	}
+
+	filter, err := userFilter(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var csvWriter *csv.Writer
+	var encoder *json.Encoder
+	filename := "users." + format
+	if format == "csv" {
+		c.Header("Content-Type", csvContentType)
+		csvWriter = csv.NewWriter(c.Writer)
+	} else {
+		c.Header("Content-Type", ndjsonContentType)
+		encoder = json.NewEncoder(c.Writer)
+	}
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+
+	if csvWriter != nil {
+		csvWriter.Write(exportColumns)
+	}
+
+	err = h.users.Each(requestContext(c), filter, exportBatchSize, func(batch []models.User) error {
+		for _, user := range batch {
+			// Important: Don't send the password back in the response
+			user.Password = ""
+			if csvWriter != nil {
+				if err := csvWriter.Write(userCSVRecord(user)); err != nil {
+					return err
+				}
+			} else if err := encoder.Encode(user); err != nil {
+				return err
+			}
+		}
+		if csvWriter != nil {
+			csvWriter.Flush()
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if csvWriter != nil {
+		csvWriter.Flush()
+	}
+	if err != nil {
+		// The status line is already sent, so all we can do is cut the stream short.
+		c.Error(err)
+		c.Abort()
+	}
+}
+
+func userCSVRecord(user models.User) []string {
+	deletedAt := ""
+	if user.DeletedAt.Valid {
+		deletedAt = user.DeletedAt.Time.Format(time.RFC3339)
+	}
+	return []string{
+		strconv.FormatUint(uint64(user.ID), 10),
+		user.PhoneNumber,
+		user.Email,
+		user.Role,
+		strconv.FormatUint(uint64(user.Version), 10),
+		user.CreatedAt.Format(time.RFC3339),
+		user.UpdatedAt.Format(time.RFC3339),
+		deletedAt,
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"my-project/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportUsers(t *testing.T) {
+	db := setupDatabase(t)
+	h := setupHandler(db)
+	r := setupRouter()
+	r.POST("/users/import", h.ImportUsers)
+
+	db.Create(&models.User{PhoneNumber: "09120000000", Email: "taken@example.com", Password: "password"})
+
+	importUsers := func(contentType, query, body string) (*httptest.ResponseRecorder, ImportReport) {
+		req, _ := http.NewRequest("POST", "/users/import"+query, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var report ImportReport
+		json.Unmarshal(w.Body.Bytes(), &report)
+		return w, report
+	}
+
+	// Bad rows are reported and nothing is written.
+	invalidCSV := "phone_number,email,password\n" +
+		"09121111111,one@example.com,secret\n" +
+		"123,two@example.com,secret\n" +
+		"09121111111,three@example.com,secret\n" +
+		"09122222222,taken@example.com,secret\n"
+	w, report := importUsers("text/csv", "", invalidCSV)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, 4, report.Total)
+	assert.Equal(t, []ImportRowError{
+		{Row: 2, Field: "phone_number", Error: "Invalid phone number format"},
+		{Row: 3, Field: "phone_number", Error: "Duplicate of row 1"},
+		{Row: 4, Field: "email", Error: "Already taken by an existing user"},
+	}, report.Errors)
+	var count int64
+	db.Model(&models.User{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// A dry run validates without writing.
+	validNDJSON := `{"phone_number": "09121111111", "email": "one@example.com", "password": "secret"}
+{"phone_number": "09122222222", "email": "two@example.com", "password": "secret", "role": "moderator"}
+`
+	w, report = importUsers("application/x-ndjson", "?dry_run=true", validNDJSON)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, report.DryRun)
+	assert.Zero(t, report.Imported)
+	db.Model(&models.User{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	w, report = importUsers("application/x-ndjson", "", validNDJSON)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, report.Imported)
+	var imported models.User
+	db.Where("phone_number = ?", "09122222222").First(&imported)
+	assert.Equal(t, "moderator", imported.Role)
+	assert.NotEqual(t, "secret", imported.Password)
+}
+
+func TestExportUsers(t *testing.T) {
+	db := setupDatabase(t)
+	h := setupHandler(db)
+	r := setupRouter()
+	r.GET("/users/export", h.ExportUsers)
+
+	db.Create(&models.User{PhoneNumber: "09121111111", Email: "one@example.com", Password: "password"})
+	deleted := models.User{PhoneNumber: "09122222222", Email: "two@example.com", Password: "password"}
+	db.Create(&deleted)
+	db.Delete(&deleted)
+
+	req, _ := http.NewRequest("GET", "/users/export", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	records, err := csv.NewReader(w.Body).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, exportColumns, records[0])
+	assert.Equal(t, "09121111111", records[1][1])
+
+	// Filters match the ones for listing users.
+	req, _ = http.NewRequest("GET", "/users/export?format=ndjson&deleted=true", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 1)
+	var exported map[string]interface{}
+	json.Unmarshal([]byte(lines[0]), &exported)
+	assert.Equal(t, "09122222222", exported["phone_number"])
+	assert.NotContains(t, exported, "password")
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"html"
+	"my-project/internal/apierr"
+	"my-project/internal/models"
+	"my-project/pkg/utils"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// UserSearchResult is a single hit returned by SearchUsers.
+type UserSearchResult struct {
+	User       models.User       `json:"user"`
+	Rank       float64           `json:"rank"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+// SearchUsers godoc
+// @Summary      Search users
+// @Description  Finds users by a fragment of their phone number or email (admin only)
+// @Tags         users
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        q      query     string  true   "Search query"
+// @Param        limit  query     int     false  "Maximum number of results"
+// @Success      200    {array}   UserSearchResult
+// @Failure      400    {object}  apierr.Problem
+// @Failure      500    {object}  apierr.Problem
+// @Router       /api/v1/users/search [get]
+func (h *Handler) SearchUsers(c *gin.Context) {
+	query := strings.TrimSpace(utils.NormalizeDigits(c.Query("q")))
+	if query == "" {
+		c.Error(apierr.New(http.StatusBadRequest, apierr.CodeInvalidParameter, "request.query_required"))
+		return
+	}
+
+	limit := defaultSearchLimit
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			c.Error(invalidParameter("limit"))
+			return
+		}
+		limit = min(n, maxSearchLimit)
+	}
+
+	hits, err := h.users.Search(requestContext(c), query, limit)
+	if err != nil {
+		c.Error(apierr.Internal(err, "user.search_failed"))
+		return
+	}
+
+	results := make([]UserSearchResult, len(hits))
+	for i, hit := range hits {
+		// Important: Don't send the password back in the response
+		hit.User.Password = ""
+		results[i] = UserSearchResult{
+			User:       hit.User,
+			Rank:       hit.Rank,
+			Highlights: highlightUser(hit.User, query),
+		}
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+// highlightUser returns the searchable fields of user that contain query,
+// with each match wrapped in <mark> tags.
+func highlightUser(user models.User, query string) map[string]string {
+	highlights := map[string]string{}
+	if h, ok := highlight(user.PhoneNumber, query); ok {
+		highlights["phone_number"] = h
+	}
+	if h, ok := highlight(user.Email, query); ok {
+		highlights["email"] = h
+	}
+	return highlights
+}
+
+func highlight(value, query string) (string, bool) {
+	lowerValue, lowerQuery := strings.ToLower(value), strings.ToLower(query)
+	if lowerQuery == "" || len(lowerValue) != len(value) || !strings.Contains(lowerValue, lowerQuery) {
+		return "", false
+	}
+
+	var b strings.Builder
+	for {
+		idx := strings.Index(lowerValue, lowerQuery)
+		if idx < 0 {
+			b.WriteString(html.EscapeString(value))
+			break
+		}
+		end := idx + len(lowerQuery)
+		b.WriteString(html.EscapeString(value[:idx]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(value[idx:end]))
+		b.WriteString("</mark>")
+		value, lowerValue = value[end:], lowerValue[end:]
+	}
+	return b.String(), true
+}
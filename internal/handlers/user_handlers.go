@@ -1,12 +1,21 @@
 package handlers
 
 import (
-	"my-project/internal/auth"
+	"encoding/json"
+	"errors"
+	"io"
+	"my-project/internal/apierr"
 	"my-project/internal/database"
+	"my-project/internal/i18n"
 	"my-project/internal/models"
-	"my-project/pkg/validators"
+	"my-project/internal/repository"
+	"my-project/internal/services"
 	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
 
+	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/gin-gonic/gin"
 )
 
@@ -18,30 +27,19 @@ import (
 // @Produce      json
 // @Param        user  body      models.User  true  "User info"
 // @Success      201   {object}  models.User
-// @Failure      400   {object}  map[string]string
-// @Failure      500   {object}  map[string]string
+// @Failure      400   {object}  apierr.Problem
+// @Failure      409   {object}  apierr.Problem
+// @Failure      500   {object}  apierr.Problem
 // @Router       /signup [post]
-func CreateUser(c *gin.Context) {
+func (h *Handler) CreateUser(c *gin.Context) {
 	var user models.User
 	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(apierr.FromBinding(err))
 		return
 	}
 
-	if !validators.ValidatePersianPhoneNumber(user.PhoneNumber) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number format"})
-		return
-	}
-
-	hashedPassword, err := auth.HashPassword(user.Password)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
-		return
-	}
-	user.Password = hashedPassword
-
-	if err := database.DB.Create(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+	if err := h.users.Create(requestContext(c), &user); err != nil {
+		c.Error(serviceError(err, "user.create_failed"))
 		return
 	}
 
@@ -52,17 +50,26 @@ func CreateUser(c *gin.Context) {
 
 // GetUsers godoc
 // @Summary      Get all users
-// @Description  Get a list of all users (admin only)
+// @Description  Get a list of all users, or only soft-deleted ones with deleted=true (admin only)
 // @Tags         users
 // @Produce      json
 // @Security     ApiKeyAuth
-// @Success      200  {array}   models.User
-// @Failure      500  {object}  map[string]string
+// @Param        deleted  query     bool  false  "List soft-deleted users instead"
+// @Success      200      {array}   models.User
+// @Failure      400      {object}  apierr.Problem
+// @Failure      500      {object}  apierr.Problem
 // @Router       /api/v1/users [get]
-func GetUsers(c *gin.Context) {
-	var users []models.User
-	if err := database.DB.Find(&users).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve users"})
+func (h *Handler) GetUsers(c *gin.Context) {
+	filter, err := userFilter(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	// Listings can be slightly stale, so they are read from a replica.
+	users, err := h.users.List(database.WithReplica(requestContext(c)), filter)
+	if err != nil {
+		c.Error(apierr.Internal(err, "user.list_failed"))
 		return
 	}
 	// Important: Don't send the password back in the response
@@ -72,23 +79,47 @@ func GetUsers(c *gin.Context) {
 	c.JSON(http.StatusOK, users)
 }
 
+// userFilter reads the filters for listing users from the request. It is
+// shared by GetUsers and ExportUsers.
+func userFilter(c *gin.Context) (repository.UserFilter, error) {
+	var filter repository.UserFilter
+	if raw := c.Query("deleted"); raw != "" {
+		deleted, err := strconv.ParseBool(raw)
+		if err != nil {
+			return filter, invalidParameter("deleted")
+		}
+		filter.Deleted = deleted
+	}
+	return filter, nil
+}
+
 // GetUser godoc
 // @Summary      Get a user by ID
 // @Description  Get a single user by their ID (admin only)
 // @Tags         users
 // @Produce      json
 // @Security     ApiKeyAuth
-// @Param        id   path      int  true  "User ID"
-// @Success      200  {object}  models.User
-// @Failure      404  {object}  map[string]string
+// @Param        id             path      int     true   "User ID"
+// @Param        If-None-Match  header    string  false  "ETag from a previous response"
+// @Success      200            {object}  models.User
+// @Header       200            {string}  ETag  "Entity tag of the user"
+// @Success      304            "User has not changed"
+// @Failure      404            {object}  apierr.Problem
 // @Router       /api/v1/users/{id} [get]
-func GetUser(c *gin.Context) {
-	id := c.Param("id")
-	var user models.User
-	if err := database.DB.First(&user, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+func (h *Handler) GetUser(c *gin.Context) {
+	user, err := h.users.Get(database.WithReplica(requestContext(c)), userID(c))
+	if err != nil {
+		c.Error(serviceError(err, "user.get_failed"))
 		return
 	}
+
+	etag := userETag(user)
+	c.Header("ETag", etag)
+	if inm := c.GetHeader("If-None-Match"); inm != "" && etagMatches(inm, etag, true) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
 	// Important: Don't send the password back in the response
 	user.Password = ""
 	c.JSON(http.StatusOK, user)
@@ -101,81 +132,316 @@ func GetUser(c *gin.Context) {
 // @Accept       json
 // @Produce      json
 // @Security     ApiKeyAuth
-// @Param        id    path      int          true  "User ID"
-// @Param        user  body      models.User  true  "User info"
-// @Success      200   {object}  models.User
-// @Failure      400   {object}  map[string]string
-// @Failure      404   {object}  map[string]string
-// @Failure      500   {object}  map[string]string
+// @Param        id        path      int          true  "User ID"
+// @Param        If-Match  header    string       true  "ETag of the user being updated"
+// @Param        user      body      models.User  true  "User info"
+// @Success      200       {object}  models.User
+// @Failure      400       {object}  apierr.Problem
+// @Failure      404       {object}  apierr.Problem
+// @Failure      409       {object}  apierr.Problem
+// @Failure      412       {object}  apierr.Problem
+// @Failure      428       {object}  apierr.Problem
+// @Failure      500       {object}  apierr.Problem
 // @Router       /api/v1/users/{id} [put]
-func UpdateUser(c *gin.Context) {
-	id := c.Param("id")
-	var user models.User
-	if err := database.DB.First(&user, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+func (h *Handler) UpdateUser(c *gin.Context) {
+	user, err := h.users.Get(requestContext(c), userID(c))
+	if err != nil {
+		c.Error(serviceError(err, "user.get_failed"))
+		return
+	}
+	if !checkIfMatch(c, user) {
 		return
 	}
-
 	var updatedUser models.User
 	if err := c.ShouldBindJSON(&updatedUser); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(apierr.FromBinding(err))
 		return
 	}
 
+	// Empty fields are left as they are.
+	var changes services.UserChanges
 	if updatedUser.PhoneNumber != "" {
-		if !validators.ValidatePersianPhoneNumber(updatedUser.PhoneNumber) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number format"})
-			return
-		}
-		user.PhoneNumber = updatedUser.PhoneNumber
+		changes.PhoneNumber = &updatedUser.PhoneNumber
+	}
+	if updatedUser.Email != "" {
+		changes.Email = &updatedUser.Email
+	}
+	if updatedUser.Locale != "" {
+		changes.Locale = &updatedUser.Locale
 	}
-
-	// Update user fields
-	user.Email = updatedUser.Email
 	if updatedUser.Password != "" {
-		hashedPassword, err := auth.HashPassword(updatedUser.Password)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
-			return
+		changes.Password = &updatedUser.Password
+	}
+
+	if err := h.users.Update(requestContext(c), &user, changes); err != nil {
+		c.Error(serviceError(err, "user.update_failed"))
+		return
+	}
+	c.Header("ETag", userETag(user))
+
+	// Important: Don't send the password back in the response
+	user.Password = ""
+	c.JSON(http.StatusOK, user)
+}
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+// PatchUser godoc
+// @Summary      Partially update a user
+// @Description  Update only the given fields of a user, using either a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) document (admin only).
+// @Description  The patchable fields are phone_number, email, role, locale and the write-only password.
+// @Tags         users
+// @Accept       application/merge-patch+json,application/json-patch+json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id        path      int     true  "User ID"
+// @Param        If-Match  header    string  true  "ETag of the user being updated"
+// @Param        patch     body      object  true  "Merge patch or JSON Patch document"
+// @Success      200       {object}  models.User
+// @Failure      400       {object}  apierr.Problem
+// @Failure      404       {object}  apierr.Problem
+// @Failure      409       {object}  apierr.Problem
+// @Failure      412       {object}  apierr.Problem
+// @Failure      415       {object}  apierr.Problem
+// @Failure      428       {object}  apierr.Problem
+// @Failure      500       {object}  apierr.Problem
+// @Router       /api/v1/users/{id} [patch]
+func (h *Handler) PatchUser(c *gin.Context) {
+	user, err := h.users.Get(requestContext(c), userID(c))
+	if err != nil {
+		c.Error(serviceError(err, "user.get_failed"))
+		return
+	}
+	if !checkIfMatch(c, user) {
+		return
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.Error(apierr.FromBinding(err))
+		return
+	}
+
+	original, err := json.Marshal(patchableUser(user))
+	if err != nil {
+		c.Error(apierr.Internal(err, "user.update_failed"))
+		return
+	}
+
+	var patched []byte
+	switch c.ContentType() {
+	case mergePatchContentType, "application/json":
+		patched, err = jsonpatch.MergePatch(original, body)
+	case jsonPatchContentType:
+		var patch jsonpatch.Patch
+		if patch, err = jsonpatch.DecodePatch(body); err == nil {
+			patched, err = patch.Apply(original)
 		}
-		user.Password = hashedPassword
+	default:
+		c.Error(apierr.New(http.StatusUnsupportedMediaType, apierr.CodeUnsupportedMediaType,
+			"request.unsupported_media_type", mergePatchContentType, jsonPatchContentType))
+		return
+	}
+	if err != nil {
+		c.Error(&apierr.Error{Status: http.StatusBadRequest, Code: apierr.CodeInvalidBody, Detail: "request.invalid_patch", Args: []any{err.Error()}, Err: err})
+		return
 	}
 
-	if err := database.DB.Save(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+	changes, fieldErrors := userPatchChanges(original, patched)
+	for _, validationErr := range services.ValidateChanges(changes) {
+		fieldErrors = append(fieldErrors, fieldError(validationErr))
+	}
+	if len(fieldErrors) > 0 {
+		sort.Slice(fieldErrors, func(i, j int) bool { return fieldErrors[i].Field < fieldErrors[j].Field })
+		c.Error(apierr.Validation(fieldErrors...))
 		return
 	}
 
+	if err := h.users.Update(requestContext(c), &user, changes); err != nil {
+		c.Error(serviceError(err, "user.update_failed"))
+		return
+	}
+	c.Header("ETag", userETag(user))
+
 	// Important: Don't send the password back in the response
 	user.Password = ""
 	c.JSON(http.StatusOK, user)
 }
 
+var patchableFields = map[string]bool{
+	"phone_number": true,
+	"email":        true,
+	"role":         true,
+	"locale":       true,
+	"password":     true,
+}
+
+// patchableUser is the document that patches are applied to. Password is
+// write-only, so it is absent here and only set when a patch adds it.
+func patchableUser(user models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           user.ID,
+		"phone_number": user.PhoneNumber,
+		"email":        user.Email,
+		"role":         user.Role,
+		"locale":       user.Locale,
+	}
+}
+
+// userPatchChanges returns the changes between the original and patched
+// documents. It only checks that the patch touches known fields and sets
+// them to strings, leaving the values to services.ValidateChanges.
+func userPatchChanges(original, patched []byte) (services.UserChanges, []apierr.FieldError) {
+	var changes services.UserChanges
+	var before, after map[string]json.RawMessage
+	if err := json.Unmarshal(original, &before); err != nil {
+		return changes, []apierr.FieldError{{Code: apierr.FieldInvalidValue, Message: "validation.invalid_document"}}
+	}
+	if err := json.Unmarshal(patched, &after); err != nil {
+		return changes, []apierr.FieldError{{Code: apierr.FieldInvalidType, Message: "validation.patch_not_object"}}
+	}
+
+	var fieldErrors []apierr.FieldError
+	for field := range before {
+		if _, ok := after[field]; !ok {
+			fieldErrors = append(fieldErrors, apierr.FieldError{Field: field, Code: apierr.FieldRequired, Message: "validation.field_removed"})
+		}
+	}
+
+	for field, value := range after {
+		if sameJSON(before[field], value) {
+			continue
+		}
+		if field == "id" {
+			fieldErrors = append(fieldErrors, apierr.FieldError{Field: field, Code: apierr.FieldReadOnly, Message: "validation.read_only"})
+			continue
+		}
+		if !patchableFields[field] {
+			fieldErrors = append(fieldErrors, apierr.FieldError{Field: field, Code: apierr.FieldUnknown, Message: "validation.unknown_field"})
+			continue
+		}
+
+		str := new(string)
+		if err := json.Unmarshal(value, str); err != nil {
+			fieldErrors = append(fieldErrors, apierr.FieldError{Field: field, Code: apierr.FieldInvalidType, Message: "validation.not_string"})
+			continue
+		}
+
+		switch field {
+		case "phone_number":
+			changes.PhoneNumber = str
+		case "email":
+			changes.Email = str
+		case "role":
+			changes.Role = str
+		case "locale":
+			changes.Locale = str
+		case "password":
+			changes.Password = str
+		}
+	}
+	return changes, fieldErrors
+}
+
+// sameJSON reports whether a and b encode the same JSON value.
+func sameJSON(a, b json.RawMessage) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	var x, y interface{}
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
+
 // DeleteUser godoc
 // @Summary      Delete a user
-// @Description  Delete a user by their ID (admin only)
+// @Description  Soft-delete a user by their ID, or erase them permanently with purge=true (admin only)
 // @Tags         users
 // @Produce      json
 // @Security     ApiKeyAuth
-// @Param        id   path      int  true  "User ID"
-// @Success      200  {object}  map[string]string
-// @Failure      404  {object}  map[string]string
-// @Failure      500  {object}  map[string]string
+// @Param        id        path      int     true   "User ID"
+// @Param        If-Match  header    string  true   "ETag of the user being deleted"
+// @Param        purge     query     bool    false  "Permanently erase the user, even if already soft-deleted"
+// @Success      200       {object}  map[string]string
+// @Failure      400       {object}  apierr.Problem
+// @Failure      404       {object}  apierr.Problem
+// @Failure      412       {object}  apierr.Problem
+// @Failure      428       {object}  apierr.Problem
+// @Failure      500       {object}  apierr.Problem
 // @Router       /api/v1/users/{id} [delete]
-func DeleteUser(c *gin.Context) {
-	id := c.Param("id")
-	var user models.User
-	if err := database.DB.First(&user, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+func (h *Handler) DeleteUser(c *gin.Context) {
+	purge := false
+	if raw := c.Query("purge"); raw != "" {
+		var err error
+		if purge, err = strconv.ParseBool(raw); err != nil {
+			c.Error(invalidParameter("purge"))
+			return
+		}
+	}
+
+	get := h.users.Get
+	if purge {
+		get = h.users.GetAny
+	}
+	user, err := get(requestContext(c), userID(c))
+	if err != nil {
+		c.Error(serviceError(err, "user.get_failed"))
+		return
+	}
+	if !checkIfMatch(c, user) {
 		return
 	}
 
-	if err := database.DB.Delete(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+	if err := h.users.Delete(requestContext(c), &user, purge); err != nil {
+		c.Error(serviceError(err, "user.delete_failed"))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+	if purge {
+		c.JSON(http.StatusOK, gin.H{"message": i18n.T(c.Request.Context(), "user.purged")})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": i18n.T(c.Request.Context(), "user.deleted")})
+}
+
+// RestoreUser godoc
+// @Summary      Restore a deleted user
+// @Description  Restore a soft-deleted user by their ID (admin only)
+// @Tags         users
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id   path      int  true  "User ID"
+// @Success      200  {object}  models.User
+// @Failure      404  {object}  apierr.Problem
+// @Failure      409  {object}  apierr.Problem
+// @Failure      500  {object}  apierr.Problem
+// @Router       /api/v1/users/{id}/restore [post]
+func (h *Handler) RestoreUser(c *gin.Context) {
+	user, err := h.users.GetDeleted(requestContext(c), userID(c))
+	if errors.Is(err, services.ErrUserNotFound) {
+		c.Error(apierr.New(http.StatusNotFound, apierr.CodeUserNotFound, "user.deleted_not_found"))
+		return
+	}
+	if err != nil {
+		c.Error(apierr.Internal(err, "user.restore_failed"))
+		return
+	}
+
+	// Someone may have signed up with the same details since the user was
+	// deleted, which is reported as USER_IDENTITY_TAKEN.
+	if err := h.users.Restore(requestContext(c), &user); err != nil {
+		c.Error(serviceError(err, "user.restore_failed"))
+		return
+	}
+	c.Header("ETag", userETag(user))
+
+	// Important: Don't send the password back in the response
+	user.Password = ""
+	c.JSON(http.StatusOK, user)
 }
 
 type AssignRoleRequest struct {
@@ -189,32 +455,37 @@ type AssignRoleRequest struct {
 // @Accept       json
 // @Produce      json
 // @Security     ApiKeyAuth
-// @Param        id    path      int              true  "User ID"
-// @Param        role  body      AssignRoleRequest  true  "New role"
-// @Success      200   {object}  models.User
-// @Failure      400   {object}  map[string]string
-// @Failure      404   {object}  map[string]string
-// @Failure      500   {object}  map[string]string
+// @Param        id        path      int                true  "User ID"
+// @Param        If-Match  header    string             true  "ETag of the user being updated"
+// @Param        role      body      AssignRoleRequest  true  "New role"
+// @Success      200       {object}  models.User
+// @Failure      400       {object}  apierr.Problem
+// @Failure      404       {object}  apierr.Problem
+// @Failure      412       {object}  apierr.Problem
+// @Failure      428       {object}  apierr.Problem
+// @Failure      500       {object}  apierr.Problem
 // @Router       /api/v1/users/{id}/role [put]
-func AssignRole(c *gin.Context) {
-	id := c.Param("id")
-	var user models.User
-	if err := database.DB.First(&user, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+func (h *Handler) AssignRole(c *gin.Context) {
+	user, err := h.users.Get(requestContext(c), userID(c))
+	if err != nil {
+		c.Error(serviceError(err, "user.get_failed"))
+		return
+	}
+	if !checkIfMatch(c, user) {
 		return
 	}
 
 	var req AssignRoleRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(apierr.FromBinding(err))
 		return
 	}
 
-	user.Role = req.Role
-	if err := database.DB.Save(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
+	if err := h.users.AssignRole(requestContext(c), &user, req.Role); err != nil {
+		c.Error(serviceError(err, "user.role_failed"))
 		return
 	}
+	c.Header("ETag", userETag(user))
 
 	user.Password = ""
 	c.JSON(http.StatusOK, user)
@@ -2,14 +2,24 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"my-project/config"
+	"my-project/internal/apierr"
+	"my-project/internal/audit"
 	"my-project/internal/auth"
-	"my-project/internal/database"
+	"my-project/internal/events"
+	"my-project/internal/i18n"
+	"my-project/internal/logging"
 	"my-project/internal/models"
+	"my-project/internal/repository"
+	"my-project/internal/seed"
+	"my-project/internal/services"
+	"my-project/internal/webhooks"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
@@ -22,22 +32,65 @@ import (
 func setupRouter() *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	r := gin.Default()
+	r.Use(logging.Middleware(), i18n.Middleware(), apierr.Middleware())
 	return r
 }
 
-func setupDatabase() {
-	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+// setupDatabase returns a fresh in-memory database for the test.
+func setupDatabase(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
 	if err != nil {
-		panic("Failed to connect to database: " + err.Error())
+		t.Fatal("Failed to connect to database:", err)
 	}
-	database.DB = db
-	database.DB.AutoMigrate(&models.User{})
+	db.AutoMigrate(
+		&models.User{},
+		&models.AuditEvent{},
+		&models.WebhookSubscription{},
+		&models.OutboxEvent{},
+		&models.WebhookDelivery{},
+		&models.WebhookDeliveryAttempt{},
+	)
+	return db
+}
+
+// setupHandler returns a Handler backed by db, with the audit log and
+// webhook outbox subscribed to its events.
+func setupHandler(db *gorm.DB) *Handler {
+	bus := events.NewBus()
+	audit.Subscribe(bus, db)
+	webhooks.Subscribe(bus, db)
+	users := services.NewUserService(repository.NewGormUserRepository(db), bus)
+	return NewHandler(users, repository.NewGormWebhookRepository(db), db)
+}
+
+// seedUsers loads the dev fixtures, the same ones `admin seed` loads, and
+// returns the seeded users by email. Their passwords are all "password".
+func seedUsers(t *testing.T, h *Handler) map[string]models.User {
+	ctx := context.Background()
+	fixtures, err := seed.Builtin("dev")
+	if err != nil {
+		t.Fatal("Failed to load fixtures:", err)
+	}
+	if _, err := seed.Apply(ctx, h.users, fixtures); err != nil {
+		t.Fatal("Failed to seed users:", err)
+	}
+
+	users := map[string]models.User{}
+	for _, fixture := range fixtures.Users {
+		user, err := h.users.FindByPhoneNumber(ctx, fixture.PhoneNumber)
+		if err != nil {
+			t.Fatal("Failed to find seeded user:", err)
+		}
+		users[user.Email] = user
+	}
+	return users
 }
 
 func TestCreateUser(t *testing.T) {
-	setupDatabase()
+	db := setupDatabase(t)
+	h := setupHandler(db)
 	r := setupRouter()
-	r.POST("/signup", CreateUser)
+	r.POST("/signup", h.CreateUser)
 
 	user := models.User{
 		PhoneNumber: "09123456789",
@@ -59,29 +112,32 @@ func TestCreateUser(t *testing.T) {
 	assert.Equal(t, user.PhoneNumber, createdUser.PhoneNumber)
 	assert.Equal(t, user.Email, createdUser.Email)
 	assert.Empty(t, createdUser.Password) // Password should not be in the response
+
+	// Signing up again with the same phone number is a conflict.
+	user.Email = "other@example.com"
+	jsonUser, _ = json.Marshal(user)
+	req, _ = http.NewRequest("POST", "/signup", bytes.NewBuffer(jsonUser))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var problem apierr.Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	assert.Equal(t, apierr.CodeIdentityTaken, problem.Code)
 }
 
 func TestLogin(t *testing.T) {
-	setupDatabase()
-
-	// Create a user to test login
-	password := "password"
-	hashedPassword, _ := auth.HashPassword(password)
-	user := models.User{
-		PhoneNumber: "09123456789",
-		Email:       "test@example.com",
-		Password:    hashedPassword,
-		Role:        "user",
-	}
-	database.DB.Create(&user)
+	db := setupDatabase(t)
+	h := setupHandler(db)
+	users := seedUsers(t, h)
 
-	auth.InitializeJWT(&config.Config{JWTSecret: "test-secret"})
+	auth.InitializeJWT(&config.Config{JWT: config.JWTConfig{Secret: "test-secret"}})
 
 	r := setupRouter()
-	r.POST("/login", Login)
+	r.POST("/login", h.Login)
 
 	loginReq := LoginRequest{
-		PhoneNumber: "09123456789",
+		PhoneNumber: users["user@example.com"].PhoneNumber,
 		Password:    "password",
 	}
 	jsonLogin, _ := json.Marshal(loginReq)
@@ -97,23 +153,62 @@ func TestLogin(t *testing.T) {
 	var response map[string]string
 	json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NotEmpty(t, response["token"])
+
+	// Disabled users can't log in.
+	loginReq.PhoneNumber = users["disabled@example.com"].PhoneNumber
+	jsonLogin, _ = json.Marshal(loginReq)
+	req, _ = http.NewRequest("POST", "/login", bytes.NewBuffer(jsonLogin))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestDisabledUserToken(t *testing.T) {
+	db := setupDatabase(t)
+	h := setupHandler(db)
+	user := seedUsers(t, h)["user@example.com"]
+
+	auth.InitializeJWT(&config.Config{JWT: config.JWTConfig{Secret: "test-secret"}})
+	token, _ := auth.GenerateJWT(user.ID, user.PhoneNumber, user.Role, user.Locale)
+	r := setupRouter()
+	r.GET("/users/:id", auth.AuthMiddleware(h.users), h.GetUser)
+	getUser := func() (int, apierr.Problem) {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/users/%d", user.ID), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var problem apierr.Problem
+		json.Unmarshal(w.Body.Bytes(), &problem)
+		return w.Code, problem
+	}
+	code, _ := getUser()
+	assert.Equal(t, http.StatusOK, code)
+
+	// The token was issued before the user was disabled, and hasn't expired.
+	assert.NoError(t, h.users.SetDisabled(context.Background(), &user, true))
+	code, problem := getUser()
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, apierr.CodeUserDisabled, problem.Code)
+
+	assert.NoError(t, h.users.SetDisabled(context.Background(), &user, false))
+	code, _ = getUser()
+	assert.Equal(t, http.StatusOK, code)
 }
 
 func TestAssignRole(t *testing.T) {
-	setupDatabase()
+	db := setupDatabase(t)
+	h := setupHandler(db)
 
-	// Create an admin and a regular user
-	admin := models.User{PhoneNumber: "09120000000", Email: "admin@example.com", Password: "password", Role: "admin"}
-	user := models.User{PhoneNumber: "09121111111", Email: "user@example.com", Password: "password", Role: "user"}
-	database.DB.Create(&admin)
-	database.DB.Create(&user)
+	users := seedUsers(t, h)
+	admin, user := users["admin@example.com"], users["user@example.com"]
 
-	auth.InitializeJWT(&config.Config{JWTSecret: "test-secret"})
-	adminToken, _ := auth.GenerateJWT(admin.PhoneNumber, admin.Role)
-	userToken, _ := auth.GenerateJWT(user.PhoneNumber, user.Role)
+	auth.InitializeJWT(&config.Config{JWT: config.JWTConfig{Secret: "test-secret"}})
+	adminToken, _ := auth.GenerateJWT(admin.ID, admin.PhoneNumber, admin.Role, admin.Locale)
+	userToken, _ := auth.GenerateJWT(user.ID, user.PhoneNumber, user.Role, user.Locale)
 
 	r := setupRouter()
-	r.PUT("/users/:id/role", auth.AuthMiddleware(), auth.RoleAuthMiddleware("admin"), AssignRole)
+	r.PUT("/users/:id/role", auth.AuthMiddleware(h.users), auth.RoleAuthMiddleware("admin"), h.AssignRole)
 
 	// Test with admin token (should succeed)
 	newRole := "moderator"
@@ -122,18 +217,20 @@ func TestAssignRole(t *testing.T) {
 	req, _ := http.NewRequest("PUT", "/users/"+fmt.Sprintf("%d", user.ID)+"/role", bytes.NewBuffer(jsonBody))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+adminToken)
+	req.Header.Set("If-Match", userETag(user))
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	var updatedUser models.User
-	database.DB.First(&updatedUser, user.ID)
+	db.First(&updatedUser, user.ID)
 	assert.Equal(t, newRole, updatedUser.Role)
 
 	// Test with user token (should fail)
 	req, _ = http.NewRequest("PUT", "/users/"+fmt.Sprintf("%d", admin.ID)+"/role", bytes.NewBuffer(jsonBody))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+userToken)
+	req.Header.Set("If-Match", userETag(admin))
 	w = httptest.NewRecorder()
 	r.ServeHTTP(w, req)
 
@@ -141,16 +238,17 @@ func TestAssignRole(t *testing.T) {
 }
 
 func TestRequestEmailCode(t *testing.T) {
-	setupDatabase()
+	db := setupDatabase(t)
+	h := setupHandler(db)
 	r := setupRouter()
-	r.POST("/login/email/request", RequestEmailCode)
+	r.POST("/login/email/request", h.RequestEmailCode)
 
 	user := models.User{
 		PhoneNumber: "09123456789",
 		Email:       "test@example.com",
 		Password:    "password",
 	}
-	database.DB.Create(&user)
+	db.Create(&user)
 
 	reqBody := RequestEmailCodeRequest{Email: "test@example.com"}
 	jsonBody, _ := json.Marshal(reqBody)
@@ -164,14 +262,15 @@ func TestRequestEmailCode(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 
 	var updatedUser models.User
-	database.DB.First(&updatedUser, user.ID)
+	db.First(&updatedUser, user.ID)
 	assert.NotEmpty(t, updatedUser.EmailVerificationCode)
 }
 
 func TestVerifyEmailCode(t *testing.T) {
-	setupDatabase()
+	db := setupDatabase(t)
+	h := setupHandler(db)
 	r := setupRouter()
-	r.POST("/login/email/verify", VerifyEmailCode)
+	r.POST("/login/email/verify", h.VerifyEmailCode)
 
 	code := "123456"
 	user := models.User{
@@ -181,8 +280,8 @@ func TestVerifyEmailCode(t *testing.T) {
 		EmailVerificationCode:        code,
 		EmailVerificationCodeExpiresAt: time.Now().Add(5 * time.Minute),
 	}
-	database.DB.Create(&user)
-	auth.InitializeJWT(&config.Config{JWTSecret: "test-secret"})
+	db.Create(&user)
+	auth.InitializeJWT(&config.Config{JWT: config.JWTConfig{Secret: "test-secret"}})
 
 	reqBody := VerifyEmailCodeRequest{Email: "test@example.com", Code: code}
 	jsonBody, _ := json.Marshal(reqBody)
@@ -200,16 +299,17 @@ func TestVerifyEmailCode(t *testing.T) {
 }
 
 func TestRequestSMSCode(t *testing.T) {
-	setupDatabase()
+	db := setupDatabase(t)
+	h := setupHandler(db)
 	r := setupRouter()
-	r.POST("/login/sms/request", RequestSMSCode)
+	r.POST("/login/sms/request", h.RequestSMSCode)
 
 	user := models.User{
 		PhoneNumber: "09123456789",
 		Email:       "test@example.com",
 		Password:    "password",
 	}
-	database.DB.Create(&user)
+	db.Create(&user)
 
 	reqBody := RequestCodeRequest{PhoneNumber: "09123456789"}
 	jsonBody, _ := json.Marshal(reqBody)
@@ -223,14 +323,15 @@ func TestRequestSMSCode(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 
 	var updatedUser models.User
-	database.DB.First(&updatedUser, user.ID)
+	db.First(&updatedUser, user.ID)
 	assert.NotEmpty(t, updatedUser.VerificationCode)
 }
 
 func TestVerifySMSCode(t *testing.T) {
-	setupDatabase()
+	db := setupDatabase(t)
+	h := setupHandler(db)
 	r := setupRouter()
-	r.POST("/login/sms/verify", VerifySMSCode)
+	r.POST("/login/sms/verify", h.VerifySMSCode)
 
 	code := "123456"
 	user := models.User{
@@ -240,8 +341,8 @@ func TestVerifySMSCode(t *testing.T) {
 		VerificationCode:          code,
 		VerificationCodeExpiresAt: time.Now().Add(5 * time.Minute),
 	}
-	database.DB.Create(&user)
-	auth.InitializeJWT(&config.Config{JWTSecret: "test-secret"})
+	db.Create(&user)
+	auth.InitializeJWT(&config.Config{JWT: config.JWTConfig{Secret: "test-secret"}})
 
 	reqBody := VerifyCodeRequest{PhoneNumber: "09123456789", Code: code}
 	jsonBody, _ := json.Marshal(reqBody)
@@ -257,3 +358,289 @@ func TestVerifySMSCode(t *testing.T) {
 	json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NotEmpty(t, response["token"])
 }
+
+func TestSearchUsers(t *testing.T) {
+	db := setupDatabase(t)
+	h := setupHandler(db)
+	r := setupRouter()
+	r.GET("/users/search", h.SearchUsers)
+
+	db.Create(&models.User{PhoneNumber: "09123456789", Email: "ali@example.com", Password: "password"})
+	db.Create(&models.User{PhoneNumber: "09351234567", Email: "sara@example.com", Password: "password"})
+	db.Create(&models.User{PhoneNumber: "09120000000", Email: "reza@example.com", Password: "password"})
+
+	// Persian digits in the query are normalized before matching.
+	req, _ := http.NewRequest("GET", "/users/search?q="+url.QueryEscape("۱۲۳۴"), nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var results []UserSearchResult
+	json.Unmarshal(w.Body.Bytes(), &results)
+	assert.Len(t, results, 2)
+	assert.Equal(t, "09123456789", results[0].User.PhoneNumber)
+	assert.Equal(t, "09<mark>1234</mark>56789", results[0].Highlights["phone_number"])
+	assert.Equal(t, "09351234567", results[1].User.PhoneNumber)
+	assert.Empty(t, results[0].User.Password)
+
+	req, _ = http.NewRequest("GET", "/users/search", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRestoreAndPurgeUser(t *testing.T) {
+	db := setupDatabase(t)
+	h := setupHandler(db)
+	r := setupRouter()
+	r.GET("/users", h.GetUsers)
+	r.DELETE("/users/:id", h.DeleteUser)
+	r.POST("/users/:id/restore", h.RestoreUser)
+
+	user := models.User{PhoneNumber: "09123456789", Email: "test@example.com", Password: "password"}
+	db.Create(&user)
+	userPath := "/users/" + fmt.Sprintf("%d", user.ID)
+
+	req, _ := http.NewRequest("DELETE", userPath, nil)
+	req.Header.Set("If-Match", userETag(user))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The soft-deleted user only shows up when asking for deleted users.
+	req, _ = http.NewRequest("GET", "/users?deleted=true", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var deletedUsers []models.User
+	json.Unmarshal(w.Body.Bytes(), &deletedUsers)
+	assert.Len(t, deletedUsers, 1)
+
+	req, _ = http.NewRequest("POST", userPath+"/restore", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var restoredUser models.User
+	assert.NoError(t, db.First(&restoredUser, user.ID).Error)
+	assert.Equal(t, userETag(restoredUser), w.Header().Get("ETag"))
+
+	req, _ = http.NewRequest("DELETE", userPath+"?purge=true", nil)
+	req.Header.Set("If-Match", userETag(restoredUser))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var count int64
+	db.Unscoped().Model(&models.User{}).Where("id = ?", user.ID).Count(&count)
+	assert.Zero(t, count)
+}
+
+func TestSignupAfterSoftDelete(t *testing.T) {
+	db := setupDatabase(t)
+	h := setupHandler(db)
+
+	user := models.User{PhoneNumber: "09123456789", Email: "test@example.com", Password: "password"}
+	db.Create(&user)
+	db.Delete(&user)
+
+	// A soft-deleted user no longer holds on to their phone number and email.
+	newUser := models.User{PhoneNumber: "09123456789", Email: "test@example.com", Password: "password"}
+	assert.NoError(t, db.Create(&newUser).Error)
+
+	// The deleted user can't be restored while their details are taken.
+	r := setupRouter()
+	r.POST("/users/:id/restore", h.RestoreUser)
+	req, _ := http.NewRequest("POST", "/users/"+fmt.Sprintf("%d", user.ID)+"/restore", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestUpdateUserKeepsEmail(t *testing.T) {
+	db := setupDatabase(t)
+	h := setupHandler(db)
+	r := setupRouter()
+	r.PUT("/users/:id", h.UpdateUser)
+
+	user := models.User{PhoneNumber: "09123456789", Email: "test@example.com", Password: "password"}
+	db.Create(&user)
+
+	// Sending only a phone number must not wipe the email.
+	jsonBody, _ := json.Marshal(map[string]string{"phone_number": "09351234567"})
+	req, _ := http.NewRequest("PUT", "/users/"+fmt.Sprintf("%d", user.ID), bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", userETag(user))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var updatedUser models.User
+	db.First(&updatedUser, user.ID)
+	assert.Equal(t, "09351234567", updatedUser.PhoneNumber)
+	assert.Equal(t, "test@example.com", updatedUser.Email)
+}
+
+func TestPatchUser(t *testing.T) {
+	db := setupDatabase(t)
+	h := setupHandler(db)
+	r := setupRouter()
+	r.PATCH("/users/:id", h.PatchUser)
+
+	user := models.User{PhoneNumber: "09123456789", Email: "test@example.com", Password: "password", Role: "user"}
+	db.Create(&user)
+	userPath := "/users/" + fmt.Sprintf("%d", user.ID)
+
+	patchUser := func(contentType, body string) *httptest.ResponseRecorder {
+		var current models.User
+		db.First(&current, user.ID)
+		req, _ := http.NewRequest("PATCH", userPath, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("If-Match", userETag(current))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Merge patch: only the fields present in the body change.
+	w := patchUser("application/merge-patch+json", `{"email": "new@example.com"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var updatedUser models.User
+	db.First(&updatedUser, user.ID)
+	assert.Equal(t, "new@example.com", updatedUser.Email)
+	assert.Equal(t, "09123456789", updatedUser.PhoneNumber)
+	assert.Equal(t, "user", updatedUser.Role)
+
+	// JSON Patch
+	w = patchUser("application/json-patch+json", `[
+		{"op": "test", "path": "/role", "value": "user"},
+		{"op": "replace", "path": "/role", "value": "moderator"}
+	]`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	db.First(&updatedUser, user.ID)
+	assert.Equal(t, "moderator", updatedUser.Role)
+	assert.Equal(t, "new@example.com", updatedUser.Email)
+
+	// Each field is validated, and nothing is saved when one fails.
+	w = patchUser("application/merge-patch+json", `{"phone_number": "123", "email": null, "id": 42}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, apierr.ContentType, w.Header().Get("Content-Type"))
+	var problem apierr.Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	assert.Equal(t, apierr.CodeValidationFailed, problem.Code)
+	assert.Equal(t, []apierr.FieldError{
+		{Field: "email", Code: apierr.FieldRequired, Message: "Field cannot be removed"},
+		{Field: "id", Code: apierr.FieldReadOnly, Message: "Field is read-only"},
+		{Field: "phone_number", Code: apierr.FieldInvalidFormat, Message: "Invalid phone number format"},
+	}, problem.Errors)
+	db.First(&updatedUser, user.ID)
+	assert.Equal(t, "09123456789", updatedUser.PhoneNumber)
+
+	w = patchUser("text/plain", `{}`)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestUserETag(t *testing.T) {
+	db := setupDatabase(t)
+	h := setupHandler(db)
+	r := setupRouter()
+	r.GET("/users/:id", h.GetUser)
+	r.PUT("/users/:id", h.UpdateUser)
+
+	user := models.User{PhoneNumber: "09123456789", Email: "test@example.com", Password: "password"}
+	db.Create(&user)
+	userPath := "/users/" + fmt.Sprintf("%d", user.ID)
+
+	req, _ := http.NewRequest("GET", userPath, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	// Conditional GET
+	req, _ = http.NewRequest("GET", userPath, nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	updateUser := func(ifMatch string) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(map[string]string{"email": "new@example.com"})
+		req, _ := http.NewRequest("PUT", userPath, bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w = updateUser("")
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+
+	w = updateUser(etag)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+
+	// A second admin still holding the old ETag can't overwrite the change.
+	w = updateUser(etag)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+}
+
+func TestLocalizedMessages(t *testing.T) {
+	db := setupDatabase(t)
+	h := setupHandler(db)
+	admin := seedUsers(t, h)["admin@example.com"]
+
+	auth.InitializeJWT(&config.Config{JWT: config.JWTConfig{Secret: "test-secret"}})
+	r := setupRouter()
+	r.Use(auth.AuthMiddleware(h.users))
+	r.GET("/users/:id", h.GetUser)
+	r.PATCH("/users/:id", h.PatchUser)
+
+	send := func(method, path, body, acceptLanguage, locale string) (*httptest.ResponseRecorder, apierr.Problem) {
+		token, _ := auth.GenerateJWT(admin.ID, admin.PhoneNumber, admin.Role, locale)
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		var current models.User
+		db.First(&current, admin.ID)
+		req.Header.Set("If-Match", userETag(current))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var problem apierr.Problem
+		json.Unmarshal(w.Body.Bytes(), &problem)
+		return w, problem
+	}
+
+	// The locale is negotiated from Accept-Language...
+	w, problem := send("GET", "/users/999", "", "fa-IR,fa;q=0.9,en;q=0.8", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "fa", w.Header().Get("Content-Language"))
+	assert.Equal(t, "کاربر یافت نشد", problem.Detail)
+	assert.Equal(t, apierr.CodeUserNotFound, problem.Code)
+
+	_, problem = send("GET", "/users/999", "", "de-DE", "")
+	assert.Equal(t, "User not found", problem.Detail)
+
+	// ...unless the user has chosen one.
+	_, problem = send("GET", "/users/999", "", "en-US", "fa")
+	assert.Equal(t, "کاربر یافت نشد", problem.Detail)
+
+	// Validation messages are translated too.
+	adminPath := fmt.Sprintf("/users/%d", admin.ID)
+	w, problem = send("PATCH", adminPath, `{"locale": "de"}`, "fa", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []apierr.FieldError{
+		{Field: "locale", Code: apierr.FieldInvalidValue, Message: "زبان پشتیبانی نمی‌شود، باید یکی از en, fa باشد"},
+	}, problem.Errors)
+
+	w, _ = send("PATCH", adminPath, `{"locale": "fa"}`, "en", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var updated models.User
+	db.First(&updated, admin.ID)
+	assert.Equal(t, "fa", updated.Locale)
+}
@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"my-project/internal/apierr"
+	"my-project/internal/i18n"
+	"my-project/internal/models"
+	"my-project/internal/repository"
+	"my-project/internal/webhooks"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultDeliveryLimit = 100
+	maxDeliveryLimit     = 500
+)
+
+// WebhookSubscriptionRequest creates or updates a webhook subscription.
+type WebhookSubscriptionRequest struct {
+	URL        string   `json:"url" binding:"required"`
+	EventTypes []string `json:"event_types" binding:"required"`
+	// Secret signs deliveries. One is generated if it is left empty on creation.
+	Secret string `json:"secret"`
+	Active *bool  `json:"active"`
+}
+
+// WebhookSubscriptionResponse is a subscription together with its secret,
+// which is only ever returned when it is set.
+type WebhookSubscriptionResponse struct {
+	models.WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+// validate checks the URL and event types of req, returning an error for
+// each invalid field.
+func (req WebhookSubscriptionRequest) validate() []apierr.FieldError {
+	var fields []apierr.FieldError
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fields = append(fields, apierr.FieldError{Field: "url", Code: apierr.FieldInvalidFormat, Message: "webhook.url_invalid"})
+	}
+	if len(req.EventTypes) == 0 {
+		fields = append(fields, apierr.FieldError{Field: "event_types", Code: apierr.FieldRequired, Message: "webhook.event_types_required"})
+	}
+	for _, eventType := range req.EventTypes {
+		if eventType != "*" && !slices.Contains(webhooks.EventTypes, eventType) {
+			fields = append(fields, apierr.FieldError{Field: "event_types", Code: apierr.FieldInvalidValue, Message: "webhook.event_type_unknown", Args: []any{eventType}})
+			break
+		}
+	}
+	return fields
+}
+
+// CreateWebhook godoc
+// @Summary      Create a webhook subscription
+// @Description  Subscribes a URL to user lifecycle events: user.created, user.verified, user.role_changed, user.deleted, or * for all (admin only).
+// @Description  Deliveries are signed with HMAC-SHA256 over "<timestamp>.<body>" in the X-Webhook-Signature header.
+// @Tags         webhooks
+// @Accept       json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        subscription  body      WebhookSubscriptionRequest  true  "Subscription"
+// @Success      201           {object}  WebhookSubscriptionResponse
+// @Failure      400           {object}  apierr.Problem
+// @Failure      500           {object}  apierr.Problem
+// @Router       /api/v1/webhooks [post]
+func (h *Handler) CreateWebhook(c *gin.Context) {
+	var req WebhookSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apierr.FromBinding(err))
+		return
+	}
+	if fields := req.validate(); len(fields) > 0 {
+		c.Error(apierr.Validation(fields...))
+		return
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		var err error
+		if secret, err = generateWebhookSecret(); err != nil {
+			c.Error(apierr.Internal(err, "webhook.secret_failed"))
+			return
+		}
+	}
+
+	subscription := models.WebhookSubscription{
+		URL:        req.URL,
+		Secret:     secret,
+		EventTypes: req.EventTypes,
+		Active:     req.Active == nil || *req.Active,
+	}
+	if err := h.webhooks.Create(c.Request.Context(), &subscription); err != nil {
+		c.Error(apierr.Internal(err, "webhook.create_failed"))
+		return
+	}
+
+	c.JSON(http.StatusCreated, WebhookSubscriptionResponse{WebhookSubscription: subscription, Secret: secret})
+}
+
+// GetWebhooks godoc
+// @Summary      List webhook subscriptions
+// @Description  Get all webhook subscriptions (admin only)
+// @Tags         webhooks
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Success      200  {array}   models.WebhookSubscription
+// @Failure      500  {object}  apierr.Problem
+// @Router       /api/v1/webhooks [get]
+func (h *Handler) GetWebhooks(c *gin.Context) {
+	subscriptions, err := h.webhooks.List(c.Request.Context())
+	if err != nil {
+		c.Error(apierr.Internal(err, "webhook.list_failed"))
+		return
+	}
+	c.JSON(http.StatusOK, subscriptions)
+}
+
+// GetWebhook godoc
+// @Summary      Get a webhook subscription
+// @Description  Get a single webhook subscription by its ID (admin only)
+// @Tags         webhooks
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id   path      int  true  "Subscription ID"
+// @Success      200  {object}  models.WebhookSubscription
+// @Failure      400  {object}  apierr.Problem
+// @Failure      404  {object}  apierr.Problem
+// @Failure      500  {object}  apierr.Problem
+// @Router       /api/v1/webhooks/{id} [get]
+func (h *Handler) GetWebhook(c *gin.Context) {
+	subscription, err := h.webhook(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, subscription)
+}
+
+// webhook loads the subscription with the ID in the path.
+func (h *Handler) webhook(c *gin.Context) (models.WebhookSubscription, error) {
+	id, err := pathID(c, "id")
+	if err != nil {
+		return models.WebhookSubscription{}, err
+	}
+	subscription, err := h.webhooks.Get(c.Request.Context(), id)
+	if err != nil {
+		return subscription, webhookError(err, "webhook.get_failed")
+	}
+	return subscription, nil
+}
+
+// webhookDelivery loads the delivery with the IDs of its subscription and
+// its own in the path, along with its attempt log if attempts is set.
+func (h *Handler) webhookDelivery(c *gin.Context, attempts bool) (models.WebhookDelivery, error) {
+	subscriptionID, err := pathID(c, "id")
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	id, err := pathID(c, "delivery_id")
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	delivery, err := h.webhooks.GetDelivery(c.Request.Context(), subscriptionID, id, attempts)
+	if err != nil {
+		return delivery, webhookError(err, "webhook.delivery_get_failed")
+	}
+	return delivery, nil
+}
+
+// UpdateWebhook godoc
+// @Summary      Update a webhook subscription
+// @Description  Replace the URL, event types and active flag of a subscription, and optionally rotate its secret (admin only)
+// @Tags         webhooks
+// @Accept       json
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id            path      int                         true  "Subscription ID"
+// @Param        subscription  body      WebhookSubscriptionRequest  true  "Subscription"
+// @Success      200           {object}  models.WebhookSubscription
+// @Failure      400           {object}  apierr.Problem
+// @Failure      404           {object}  apierr.Problem
+// @Failure      500           {object}  apierr.Problem
+// @Router       /api/v1/webhooks/{id} [put]
+func (h *Handler) UpdateWebhook(c *gin.Context) {
+	subscription, err := h.webhook(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var req WebhookSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apierr.FromBinding(err))
+		return
+	}
+	if fields := req.validate(); len(fields) > 0 {
+		c.Error(apierr.Validation(fields...))
+		return
+	}
+
+	subscription.URL = req.URL
+	subscription.EventTypes = req.EventTypes
+	if req.Active != nil {
+		subscription.Active = *req.Active
+	}
+	if req.Secret != "" {
+		subscription.Secret = req.Secret
+	}
+	if err := h.webhooks.Update(c.Request.Context(), &subscription); err != nil {
+		c.Error(apierr.Internal(err, "webhook.update_failed"))
+		return
+	}
+	c.JSON(http.StatusOK, subscription)
+}
+
+// DeleteWebhook godoc
+// @Summary      Delete a webhook subscription
+// @Description  Delete a subscription. Its pending deliveries are dead-lettered, and the delivery log is kept (admin only).
+// @Tags         webhooks
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id   path      int  true  "Subscription ID"
+// @Success      200  {object}  map[string]string
+// @Failure      404  {object}  apierr.Problem
+// @Failure      500  {object}  apierr.Problem
+// @Router       /api/v1/webhooks/{id} [delete]
+func (h *Handler) DeleteWebhook(c *gin.Context) {
+	subscription, err := h.webhook(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if err := h.webhooks.Delete(c.Request.Context(), &subscription); err != nil {
+		c.Error(apierr.Internal(err, "webhook.delete_failed"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": i18n.T(c.Request.Context(), "webhook.deleted")})
+}
+
+// GetWebhookDeliveries godoc
+// @Summary      List webhook deliveries
+// @Description  Get the deliveries of a subscription, newest first, optionally filtered by status (admin only).
+// @Description  Pages are fetched by passing the ID of the last delivery of the previous page as before_id.
+// @Tags         webhooks
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id         path      int     true   "Subscription ID"
+// @Param        status     query     string  false  "pending, succeeded or dead"
+// @Param        limit      query     int     false  "Maximum number of deliveries"
+// @Param        before_id  query     int     false  "Only deliveries with a lower ID"
+// @Success      200        {array}   models.WebhookDelivery
+// @Failure      400        {object}  apierr.Problem
+// @Failure      500        {object}  apierr.Problem
+// @Router       /api/v1/webhooks/{id}/deliveries [get]
+func (h *Handler) GetWebhookDeliveries(c *gin.Context) {
+	subscriptionID, err := pathID(c, "id")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	filter := repository.DeliveryFilter{Status: c.Query("status"), Limit: defaultDeliveryLimit}
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			c.Error(invalidParameter("limit"))
+			return
+		}
+		filter.Limit = min(n, maxDeliveryLimit)
+	}
+	if raw := c.Query("before_id"); raw != "" {
+		beforeID, err := strconv.ParseUint(raw, 10, 0)
+		if err != nil || beforeID == 0 {
+			c.Error(invalidParameter("before_id"))
+			return
+		}
+		filter.BeforeID = uint(beforeID)
+	}
+
+	deliveries, err := h.webhooks.ListDeliveries(c.Request.Context(), subscriptionID, filter)
+	if err != nil {
+		c.Error(apierr.Internal(err, "webhook.deliveries_failed"))
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// GetWebhookDelivery godoc
+// @Summary      Get a webhook delivery
+// @Description  Get a delivery together with the log of its attempts (admin only)
+// @Tags         webhooks
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id           path      int  true  "Subscription ID"
+// @Param        delivery_id  path      int  true  "Delivery ID"
+// @Success      200          {object}  models.WebhookDelivery
+// @Failure      400          {object}  apierr.Problem
+// @Failure      404          {object}  apierr.Problem
+// @Failure      500          {object}  apierr.Problem
+// @Router       /api/v1/webhooks/{id}/deliveries/{delivery_id} [get]
+func (h *Handler) GetWebhookDelivery(c *gin.Context) {
+	delivery, err := h.webhookDelivery(c, true)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, delivery)
+}
+
+// RetryWebhookDelivery godoc
+// @Summary      Retry a webhook delivery
+// @Description  Send a dead-lettered or pending delivery again as soon as possible, with a fresh set of attempts (admin only)
+// @Tags         webhooks
+// @Produce      json
+// @Security     ApiKeyAuth
+// @Param        id           path      int  true  "Subscription ID"
+// @Param        delivery_id  path      int  true  "Delivery ID"
+// @Success      200          {object}  models.WebhookDelivery
+// @Failure      400          {object}  apierr.Problem
+// @Failure      404          {object}  apierr.Problem
+// @Failure      409          {object}  apierr.Problem
+// @Failure      500          {object}  apierr.Problem
+// @Router       /api/v1/webhooks/{id}/deliveries/{delivery_id}/retry [post]
+func (h *Handler) RetryWebhookDelivery(c *gin.Context) {
+	delivery, err := h.webhookDelivery(c, false)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if delivery.Status == models.DeliverySucceeded {
+		c.Error(apierr.New(http.StatusConflict, apierr.CodeDeliverySucceeded, "webhook.delivery_succeeded"))
+		return
+	}
+
+	if err := h.webhooks.RetryDelivery(c.Request.Context(), &delivery); err != nil {
+		c.Error(apierr.Internal(err, "webhook.retry_failed"))
+		return
+	}
+	c.JSON(http.StatusOK, delivery)
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
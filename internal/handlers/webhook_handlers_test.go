@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"my-project/internal/apierr"
+	"my-project/internal/models"
+	"my-project/internal/webhooks"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	db := setupDatabase(t)
+	h := setupHandler(db)
+	r := setupRouter()
+	r.POST("/webhooks", h.CreateWebhook)
+	r.GET("/webhooks/:id/deliveries/:delivery_id", h.GetWebhookDelivery)
+	r.POST("/webhooks/:id/deliveries/:delivery_id/retry", h.RetryWebhookDelivery)
+	r.PUT("/users/:id/role", h.AssignRole)
+
+	var received []webhooks.Envelope
+	status := http.StatusOK
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(webhooks.HeaderTimestamp), 10, 64)
+		if r.Header.Get(webhooks.HeaderSignature) != webhooks.Sign("s3cret", timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var envelope webhooks.Envelope
+		json.Unmarshal(body, &envelope)
+		received = append(received, envelope)
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	jsonBody, _ := json.Marshal(WebhookSubscriptionRequest{
+		URL:        receiver.URL,
+		EventTypes: []string{webhooks.EventUserRoleChanged},
+		Secret:     "s3cret",
+	})
+	req, _ := http.NewRequest("POST", "/webhooks", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var subscription WebhookSubscriptionResponse
+	json.Unmarshal(w.Body.Bytes(), &subscription)
+	assert.Equal(t, "s3cret", subscription.Secret)
+
+	user := models.User{PhoneNumber: "09121111111", Email: "user@example.com", Password: "password", Role: "user"}
+	db.Create(&user)
+	assignRole := func(role string) {
+		var current models.User
+		db.First(&current, user.ID)
+		jsonBody, _ := json.Marshal(AssignRoleRequest{Role: role})
+		req, _ := http.NewRequest("PUT", "/users/"+fmt.Sprintf("%d", user.ID)+"/role", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", userETag(current))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// The role change is written to the outbox and delivered, signed.
+	assignRole("moderator")
+	dispatcher := webhooks.NewDispatcher(db, 2)
+	assert.NoError(t, dispatcher.RunOnce(context.Background()))
+	assert.Len(t, received, 1)
+	assert.Equal(t, webhooks.EventUserRoleChanged, received[0].Type)
+	var payload webhooks.RoleChangedPayload
+	json.Unmarshal(received[0].Data, &payload)
+	assert.Equal(t, "moderator", payload.Role)
+	assert.Equal(t, "user", payload.PreviousRole)
+
+	lastDelivery := func() models.WebhookDelivery {
+		var delivery models.WebhookDelivery
+		db.Last(&delivery)
+		return delivery
+	}
+	assert.Equal(t, models.DeliverySucceeded, lastDelivery().Status)
+
+	// Failures are retried with backoff, then dead-lettered.
+	status = http.StatusServiceUnavailable
+	assignRole("admin")
+	assert.NoError(t, dispatcher.RunOnce(context.Background()))
+	delivery := lastDelivery()
+	assert.Equal(t, models.DeliveryPending, delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.True(t, delivery.NextAttemptAt.After(time.Now()))
+
+	db.Model(&delivery).Update("next_attempt_at", time.Now())
+	assert.NoError(t, dispatcher.RunOnce(context.Background()))
+	assert.Equal(t, models.DeliveryDead, lastDelivery().Status)
+
+	deliveryPath := fmt.Sprintf("/webhooks/%d/deliveries/%d", subscription.ID, delivery.ID)
+	req, _ = http.NewRequest("GET", deliveryPath, nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &delivery)
+	assert.Len(t, delivery.AttemptLog, 2)
+	assert.Equal(t, http.StatusServiceUnavailable, delivery.AttemptLog[0].StatusCode)
+
+	// An admin can redrive a dead-lettered delivery.
+	status = http.StatusOK
+	req, _ = http.NewRequest("POST", deliveryPath+"/retry", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, dispatcher.RunOnce(context.Background()))
+	assert.Equal(t, models.DeliverySucceeded, lastDelivery().Status)
+	assert.Len(t, received, 4)
+}
+
+func TestWebhookQueriesFollowRequest(t *testing.T) {
+	db := setupDatabase(t)
+	h := setupHandler(db)
+	r := setupRouter()
+	r.GET("/webhooks", h.GetWebhooks)
+
+	// The query is cancelled with the request, once the client is gone.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/webhooks", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, apierr.StatusClientClosedRequest, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestWebhookLeaseExpired(t *testing.T) {
+	db := setupDatabase(t)
+
+	// The first delivery hangs until released, while the others wait.
+	var mu sync.Mutex
+	sent := map[string]int{}
+	sending := make(chan string, 1)
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(webhooks.HeaderDelivery)
+		mu.Lock()
+		sent[id]++
+		first := len(sent) == 1 && sent[id] == 1
+		mu.Unlock()
+		if first {
+			sending <- id
+			<-release
+		}
+	}))
+	defer receiver.Close()
+
+	db.Create(&models.WebhookSubscription{URL: receiver.URL, Secret: "s3cret", EventTypes: models.StringList{"*"}, Active: true})
+	for range 3 {
+		db.Create(&models.OutboxEvent{EventType: webhooks.EventUserRoleChanged, Payload: "{}"})
+	}
+
+	first := webhooks.NewDispatcher(db, 2)
+	done := make(chan error)
+	go func() { done <- first.RunOnce(context.Background()) }()
+	hanging := <-sending
+
+	// The leases of the rest of the batch run out, and another dispatcher
+	// sends them.
+	db.Model(&models.WebhookDelivery{}).Where("id <> ?", hanging).Update("next_attempt_at", time.Now())
+	assert.NoError(t, webhooks.NewDispatcher(db, 2).RunOnce(context.Background()))
+
+	// The first dispatcher doesn't send them again.
+	close(release)
+	assert.NoError(t, <-done)
+	assert.Len(t, sent, 3)
+	for id, count := range sent {
+		assert.Equal(t, 1, count, "delivery %s", id)
+	}
+	var succeeded int64
+	db.Model(&models.WebhookDelivery{}).Where("status = ?", models.DeliverySucceeded).Count(&succeeded)
+	assert.Equal(t, int64(3), succeeded)
+}
+
+func TestWebhookIDs(t *testing.T) {
+	db := setupDatabase(t)
+	h := setupHandler(db)
+	r := setupRouter()
+	r.GET("/webhooks/:id", h.GetWebhook)
+	r.GET("/webhooks/:id/deliveries/:delivery_id", h.GetWebhookDelivery)
+	db.Create(&models.WebhookSubscription{URL: "https://example.com", Secret: "s1", EventTypes: models.StringList{"*"}, Active: true})
+
+	get := func(path string) (int, apierr.Problem) {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var problem apierr.Problem
+		json.Unmarshal(w.Body.Bytes(), &problem)
+		return w.Code, problem
+	}
+
+	// IDs are never passed to the database as SQL.
+	code, problem := get("/webhooks/1%20OR%201=1")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, apierr.CodeInvalidParameter, problem.Code)
+	code, _ = get("/webhooks/0%20OR%20secret%20=%20's1'")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = get("/webhooks/1/deliveries/1%20OR%201=1")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = get("/webhooks/1")
+	assert.Equal(t, http.StatusOK, code)
+	code, problem = get("/webhooks/2")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, apierr.CodeWebhookNotFound, problem.Code)
+
+	// Only missing webhooks are reported as such.
+	db.Migrator().DropTable(&models.WebhookSubscription{})
+	code, _ = get("/webhooks/1")
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestWebhookDeliveryPages(t *testing.T) {
+	db := setupDatabase(t)
+	h := setupHandler(db)
+	r := setupRouter()
+	r.GET("/webhooks/:id/deliveries", h.GetWebhookDeliveries)
+
+	subscription := models.WebhookSubscription{URL: "https://example.com", Secret: "s1", EventTypes: models.StringList{"*"}, Active: true}
+	db.Create(&subscription)
+	for range 5 {
+		db.Create(&models.WebhookDelivery{SubscriptionID: subscription.ID, EventType: webhooks.EventUserCreated, Status: models.DeliveryPending})
+	}
+
+	list := func(query string) (int, []uint) {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/webhooks/%d/deliveries?%s", subscription.ID, query), nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var deliveries []models.WebhookDelivery
+		json.Unmarshal(w.Body.Bytes(), &deliveries)
+		var ids []uint
+		for _, delivery := range deliveries {
+			ids = append(ids, delivery.ID)
+		}
+		return w.Code, ids
+	}
+
+	code, ids := list("limit=2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []uint{5, 4}, ids)
+	_, ids = list("limit=2&before_id=4")
+	assert.Equal(t, []uint{3, 2}, ids)
+	_, ids = list("limit=2&before_id=2")
+	assert.Equal(t, []uint{1}, ids)
+
+	code, _ = list("before_id=x")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = list("limit=0")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
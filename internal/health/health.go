@@ -0,0 +1,139 @@
+// Package health serves the liveness and readiness endpoints.
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// CheckResult is the outcome of one readiness check.
+type CheckResult struct {
+	Status     string `json:"status"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Report is the response of the health endpoints.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+	// ShuttingDown is set once the server has started shutting down.
+	ShuttingDown bool `json:"shutting_down,omitempty"`
+}
+
+type check struct {
+	name    string
+	timeout time.Duration
+	fn      func(ctx context.Context) error
+}
+
+// Checker runs the readiness checks of the dependencies the server needs.
+type Checker struct {
+	checks       []check
+	shuttingDown atomic.Bool
+}
+
+// NewChecker returns a Checker without checks.
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// Add registers a readiness check. fn fails the check by returning an
+// error or by not returning within timeout.
+func (h *Checker) Add(name string, timeout time.Duration, fn func(ctx context.Context) error) {
+	h.checks = append(h.checks, check{name: name, timeout: timeout, fn: fn})
+}
+
+// ShutDown makes readiness fail from now on, so load balancers stop
+// sending traffic before the server stops accepting it.
+func (h *Checker) ShutDown() {
+	h.shuttingDown.Store(true)
+}
+
+// Run runs every check concurrently and reports their results.
+func (h *Checker) Run(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(h.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range h.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := c.run(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusFail
+			}
+		}()
+	}
+	wg.Wait()
+
+	if h.shuttingDown.Load() {
+		report.Status = StatusFail
+		report.ShuttingDown = true
+	}
+	return report
+}
+
+func (c check) run(ctx context.Context) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- c.fn(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// Don't wait for checks that ignore their context.
+		err = ctx.Err()
+	}
+
+	result := CheckResult{Status: StatusOK, DurationMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Status, result.Error = StatusFail, err.Error()
+	}
+	return result
+}
+
+// Live godoc
+// @Summary      Liveness probe
+// @Description  Reports that the process is up. It checks no dependencies.
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  health.Report
+// @Router       /healthz [get]
+func (h *Checker) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, Report{Status: StatusOK})
+}
+
+// Ready godoc
+// @Summary      Readiness probe
+// @Description  Checks the database, migrations and configured SMS and email providers. Fails while the server shuts down.
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  health.Report
+// @Failure      503  {object}  health.Report
+// @Router       /readyz [get]
+func (h *Checker) Ready(c *gin.Context) {
+	report := h.Run(c.Request.Context())
+	status := http.StatusOK
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
@@ -0,0 +1,79 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ready(t *testing.T, checker *Checker) (int, Report) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/readyz", checker.Ready)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var report Report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	return w.Code, report
+}
+
+func TestReadyReportsChecks(t *testing.T) {
+	checker := NewChecker()
+	checker.Add("database", time.Second, func(ctx context.Context) error { return nil })
+
+	code, report := ready(t, checker)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Equal(t, StatusOK, report.Checks["database"].Status)
+
+	checker.Add("smtp", time.Second, func(ctx context.Context) error { return errors.New("connection refused") })
+	code, report = ready(t, checker)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, StatusOK, report.Checks["database"].Status)
+	assert.Equal(t, CheckResult{Status: StatusFail, Error: "connection refused"}, report.Checks["smtp"])
+}
+
+func TestReadyTimesOutSlowChecks(t *testing.T) {
+	checker := NewChecker()
+	release := make(chan struct{})
+	defer close(release)
+	// The check ignores its context, so it must be abandoned.
+	checker.Add("sms", 50*time.Millisecond, func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+
+	start := time.Now()
+	code, report := ready(t, checker)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["sms"].Error)
+}
+
+func TestReadyFailsDuringShutdown(t *testing.T) {
+	checker := NewChecker()
+	checker.Add("database", time.Second, func(ctx context.Context) error { return nil })
+	checker.ShutDown()
+
+	code, report := ready(t, checker)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.True(t, report.ShuttingDown)
+	assert.Equal(t, StatusOK, report.Checks["database"].Status)
+
+	// The process is still alive.
+	r := gin.New()
+	r.GET("/healthz", checker.Live)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,133 @@
+// Package i18n translates the messages of the API. Messages are referred
+// to by ID, such as "user.not_found", and looked up in the bundle of the
+// request's locale, one JSON file per locale in locales/.
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+)
+
+// Supported locales.
+const (
+	English = "en"
+	Persian = "fa"
+	// Default is used when the client accepts none of the supported
+	// locales, and for messages missing from a bundle.
+	Default = English
+)
+
+// Supported lists the supported locales, the default first.
+var Supported = []string{English, Persian}
+
+//go:embed locales/*.json
+var locales embed.FS
+
+// bundles maps locales to message IDs to messages. A message may hold fmt
+// verbs for the arguments it is translated with.
+var bundles = loadBundles()
+
+var matcher = language.NewMatcher([]language.Tag{language.English, language.Persian})
+
+func loadBundles() map[string]map[string]string {
+	bundles := map[string]map[string]string{}
+	for _, locale := range Supported {
+		data, err := locales.ReadFile(path.Join("locales", locale+".json"))
+		if err != nil {
+			panic(err)
+		}
+		bundle := map[string]string{}
+		if err := json.Unmarshal(data, &bundle); err != nil {
+			panic(fmt.Sprintf("i18n: bundle %s: %v", locale, err))
+		}
+		bundles[locale] = bundle
+	}
+	return bundles
+}
+
+// IsSupported reports whether locale has a bundle.
+func IsSupported(locale string) bool {
+	return slices.Contains(Supported, locale)
+}
+
+// Translate returns the message with id in locale, formatted with args.
+// Messages missing from the bundle are taken from the default one, and
+// unknown IDs are returned as they are.
+func Translate(locale, id string, args ...any) string {
+	message, ok := bundles[locale][id]
+	if !ok {
+		message, ok = bundles[Default][id]
+	}
+	if !ok {
+		message = id
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+// T translates the message with id into the locale of ctx.
+func T(ctx context.Context, id string, args ...any) string {
+	return Translate(FromContext(ctx), id, args...)
+}
+
+// Negotiate picks the supported locale that best matches an
+// Accept-Language header, or Default.
+func Negotiate(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return Default
+	}
+	_, i, confidence := matcher.Match(tags...)
+	if confidence == language.No {
+		return Default
+	}
+	return Supported[i]
+}
+
+type localeKey struct{}
+
+// WithLocale returns a copy of ctx carrying locale.
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+// FromContext returns the locale carried by ctx, or Default.
+func FromContext(ctx context.Context) string {
+	if locale, ok := ctx.Value(localeKey{}).(string); ok {
+		return locale
+	}
+	return Default
+}
+
+// Middleware sets the locale of each request from its Accept-Language
+// header. The locale a user has chosen is applied later, once they are
+// authenticated, see SetLocale.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("Vary", "Accept-Language")
+		SetLocale(c, Negotiate(c.GetHeader("Accept-Language")))
+		c.Next()
+	}
+}
+
+// SetLocale makes locale the locale of the request and announces it in
+// the Content-Language header. Unsupported locales, including "", are
+// ignored.
+func SetLocale(c *gin.Context, locale string) {
+	locale = strings.ToLower(locale)
+	if !IsSupported(locale) {
+		return
+	}
+	c.Request = c.Request.WithContext(WithLocale(c.Request.Context(), locale))
+	c.Header("Content-Language", locale)
+}
@@ -0,0 +1,80 @@
+package i18n
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+var verbPattern = regexp.MustCompile(`%(\[\d+\])?[sdv]`)
+
+func TestBundles(t *testing.T) {
+	// Every message is translated, with the same arguments.
+	for _, locale := range Supported {
+		assert.Len(t, bundles[locale], len(bundles[Default]), locale)
+		for id, message := range bundles[Default] {
+			translated, ok := bundles[locale][id]
+			if assert.True(t, ok, "%s is missing %s", locale, id) {
+				assert.Len(t, verbPattern.FindAllString(translated, -1), len(verbPattern.FindAllString(message, -1)), "%s: %s", locale, id)
+			}
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	assert.Equal(t, "User not found", Translate(English, "user.not_found"))
+	assert.Equal(t, "کاربر یافت نشد", Translate(Persian, "user.not_found"))
+	assert.Equal(t, "Invalid limit parameter", Translate(English, "request.invalid_parameter", "limit"))
+	assert.Equal(t, "User not found", Translate("de", "user.not_found"))
+	assert.Equal(t, "no.such.message", Translate(Persian, "no.such.message"))
+}
+
+func TestNegotiate(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                        English,
+		"fa":                      Persian,
+		"fa-IR,fa;q=0.9,en;q=0.8": Persian,
+		"en-US,en;q=0.9,fa;q=0.8": English,
+		"de-DE,fa;q=0.5":          Persian,
+		"de-DE":                   English,
+		"*":                       English,
+		"not a header;;":          English,
+	} {
+		assert.Equal(t, want, Negotiate(header), header)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/", func(c *gin.Context) {
+		SetLocale(c, c.Query("locale"))
+		c.String(http.StatusOK, T(c.Request.Context(), "user.not_found"))
+	})
+
+	serve := func(path, acceptLanguage string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/", "fa-IR")
+	assert.Equal(t, "کاربر یافت نشد", w.Body.String())
+	assert.Equal(t, Persian, w.Header().Get("Content-Language"))
+	assert.Equal(t, "Accept-Language", w.Header().Get("Vary"))
+
+	w = serve("/?locale=en", "fa-IR")
+	assert.Equal(t, "User not found", w.Body.String())
+	assert.Equal(t, English, w.Header().Get("Content-Language"))
+
+	// Unsupported locales are ignored.
+	w = serve("/?locale=de", "fa-IR")
+	assert.Equal(t, "کاربر یافت نشد", w.Body.String())
+}
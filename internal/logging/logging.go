@@ -0,0 +1,85 @@
+// Package logging sets up structured JSON logging with log/slog, and
+// carries a logger per request in its context.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"my-project/config"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Setup makes a JSON logger at cfg.Log.Level the default, for slog and the
+// log package alike. Unless cfg.Log.Redact is off, phone numbers, emails,
+// codes and tokens are masked in everything it writes.
+func Setup(cfg *config.Config) (*slog.Logger, error) {
+	level, err := ParseLevel(cfg.Log.Level)
+	if err != nil {
+		return nil, err
+	}
+	logger := slog.New(NewHandler(os.Stdout, level, cfg.Log.Redact))
+	slog.SetDefault(logger)
+	return logger, nil
+}
+
+// NewHandler returns a JSON handler writing records at level and above to
+// w, tagged with the trace and span of their context.
+func NewHandler(w io.Writer, level slog.Level, redact bool) slog.Handler {
+	var h slog.Handler = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+	h = traceHandler{h}
+	if redact {
+		h = NewRedactHandler(h)
+	}
+	return h
+}
+
+// ParseLevel parses "debug", "info", "warn" or "error".
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("invalid log level %q, want debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a context carrying logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, such as the request's
+// logger, or the default logger if there is none.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// traceHandler adds the trace and span IDs of the record's context, so
+// logs can be found from a trace and the other way around.
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		r.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedact(t *testing.T) {
+	for in, want := range map[string]string{
+		"Message for 09121234567":                         "Message for *********67",
+		"call +989121234567 now":                          "call *********67 now",
+		"sent to user.name@example.com":                   "sent to ***@example.com",
+		"Your verification code is 123456. It expires at": "Your verification code is ******. It expires at",
+		"کد تأیید شما 123456 است":                         "کد تأیید شما ****** است",
+		"Authorization: Bearer abc.def-ghi":               "Authorization: Bearer [REDACTED]",
+		"token eyJhbGciOi.eyJzdWIiOiIx.c2lnbmF0dXJl":      "token [REDACTED]",
+		"Purged 12 deleted users":                         "Purged 12 deleted users",
+	} {
+		assert.Equal(t, want, Redact(in), in)
+	}
+}
+
+func TestRedactHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(&buf, slog.LevelInfo, true)).With("phone_number", "09121234567")
+
+	logger.Info("Login from user@example.com",
+		"code", 4711,
+		slog.Group("request", "token", "opaque"),
+		"error", errors.New("no user with email user@example.com"),
+		"count", 3,
+	)
+	logger.Debug("not logged")
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "Login from ***@example.com", entry["msg"])
+	assert.Equal(t, "*********67", entry["phone_number"])
+	assert.Equal(t, "******", entry["code"])
+	assert.Equal(t, map[string]any{"token": "[REDACTED]"}, entry["request"])
+	assert.Equal(t, "no user with email ***@example.com", entry["error"])
+	assert.Equal(t, 3.0, entry["count"])
+}
+
+func TestMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(NewHandler(&buf, slog.LevelInfo, true)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/users/:id", func(c *gin.Context) {
+		AddAttrs(c, "user_id", "7")
+		FromContext(c.Request.Context()).Info("Handling")
+		c.String(http.StatusOK, RequestID(c.Request.Context()))
+	})
+
+	// A valid ID from the client is kept.
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set(RequestIDHeader, "req-123")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "req-123", w.Body.String())
+	assert.Equal(t, "req-123", w.Header().Get(RequestIDHeader))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+	var handling, handled map[string]any
+	require.NoError(t, json.Unmarshal(lines[0], &handling))
+	require.NoError(t, json.Unmarshal(lines[1], &handled))
+	assert.Equal(t, "req-123", handling["request_id"])
+	assert.Equal(t, "/users/:id", handling["route"])
+	assert.Equal(t, "Request handled", handled["msg"])
+	assert.Equal(t, "7", handled["user_id"])
+	assert.Equal(t, 200.0, handled["status"])
+
+	// Anything else is replaced.
+	req = httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set(RequestIDHeader, "bad id\n")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Len(t, w.Body.String(), 32)
+	assert.Equal(t, w.Body.String(), w.Header().Get(RequestIDHeader))
+}
@@ -0,0 +1,106 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the ID of a request, from the client or a proxy
+// in front of the server, and back in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID limits which IDs are accepted from clients, since they
+// end up in logs, events and webhook payloads.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// RequestID returns the ID of the request ctx belongs to, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Middleware gives every request an ID and a logger, and logs the request
+// once it has been handled. The ID is taken from the X-Request-ID header
+// if it is valid, or generated, and sent back in the same header. The
+// logger is tagged with the ID and the route, and later with the user
+// once authenticated, see AddAttrs.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+
+		logger := slog.Default().With(
+			slog.String("request_id", id),
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+		)
+		ctx := context.WithValue(c.Request.Context(), requestIDKey{}, id)
+		c.Request = c.Request.WithContext(WithLogger(ctx, logger))
+
+		c.Next()
+
+		ctx = c.Request.Context()
+		level := slog.LevelInfo
+		status := c.Writer.Status()
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		attrs := []slog.Attr{
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.Int("size", c.Writer.Size()),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("error", c.Errors.String()))
+		}
+		FromContext(ctx).LogAttrs(ctx, level, "Request handled", attrs...)
+	}
+}
+
+// AddAttrs adds attrs to the logger of the request.
+func AddAttrs(c *gin.Context, attrs ...any) {
+	ctx := c.Request.Context()
+	c.Request = c.Request.WithContext(WithLogger(ctx, FromContext(ctx).With(attrs...)))
+}
+
+// Recovery turns panics into 500 responses, logging them with the stack
+// through the request's logger instead of gin's own writer. The panic is
+// added to the request's errors and the body is left to the error
+// middleware in front of it.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err any) {
+		ctx := c.Request.Context()
+		FromContext(ctx).ErrorContext(ctx, "Panic while handling request",
+			slog.Any("panic", err), slog.String("stack", string(debug.Stack())))
+		c.Error(fmt.Errorf("panic: %v", err))
+		c.Status(http.StatusInternalServerError)
+		c.Abort()
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,113 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// Patterns masked wherever they appear in messages and string values.
+var (
+	jwtPattern    = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	bearerPattern = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`)
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@([A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+	// Iranian mobile numbers, with or without their 0 or +98 prefix. The
+	// last two digits are kept, to tell numbers apart while debugging.
+	phonePattern = regexp.MustCompile(`(?:\+98|\b0|\b)9\d{7}(\d{2})\b`)
+	// Verification codes are plain numbers, so they are only recognized
+	// when they follow the word "code", or "کد" in Persian messages.
+	codePattern = regexp.MustCompile(`(?i)((?:code|کد)\D{0,16}?)\d{4,8}\b`)
+)
+
+// sensitiveKeys maps attribute keys to how their values are masked, for
+// values the patterns can't recognize on their own.
+var sensitiveKeys = map[string]func(string) string{
+	"password":          func(string) string { return redacted },
+	"token":             func(string) string { return redacted },
+	"authorization":     func(string) string { return redacted },
+	"secret":            func(string) string { return redacted },
+	"api_key":           func(string) string { return redacted },
+	"code":              maskCode,
+	"verification_code": maskCode,
+}
+
+func maskCode(string) string { return "******" }
+
+// Redact masks phone numbers, emails, tokens and verification codes in s.
+func Redact(s string) string {
+	s = jwtPattern.ReplaceAllString(s, redacted)
+	s = bearerPattern.ReplaceAllString(s, "${1}"+redacted)
+	s = emailPattern.ReplaceAllString(s, "***@$1")
+	s = phonePattern.ReplaceAllString(s, "*********$1")
+	s = codePattern.ReplaceAllString(s, "${1}******")
+	return s
+}
+
+// RedactHandler masks sensitive data in records before passing them on:
+// in the message, in string values of attributes, in errors, and in
+// everything under a sensitive key such as "token" or "code".
+type RedactHandler struct {
+	next slog.Handler
+}
+
+// NewRedactHandler returns a handler redacting records passed to next.
+func NewRedactHandler(next slog.Handler) *RedactHandler {
+	return &RedactHandler{next: next}
+}
+
+func (h *RedactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *RedactHandler) Handle(ctx context.Context, r slog.Record) error {
+	redactedRecord := slog.NewRecord(r.Time, r.Level, Redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redactedRecord.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, redactedRecord)
+}
+
+func (h *RedactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redactedAttrs := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redactedAttrs[i] = redactAttr(a)
+	}
+	return &RedactHandler{next: h.next.WithAttrs(redactedAttrs)}
+}
+
+func (h *RedactHandler) WithGroup(name string) slog.Handler {
+	return &RedactHandler{next: h.next.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	value := a.Value.Resolve()
+	if mask, ok := sensitiveKeys[strings.ToLower(a.Key)]; ok && value.Kind() != slog.KindGroup {
+		return slog.String(a.Key, mask(value.String()))
+	}
+
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		redactedGroup := make([]slog.Attr, len(group))
+		for i, member := range group {
+			redactedGroup[i] = redactAttr(member)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redactedGroup...)}
+	case slog.KindAny:
+		// Errors and other values are logged as text, which may quote
+		// user input.
+		if err, ok := value.Any().(error); ok {
+			return slog.String(a.Key, Redact(err.Error()))
+		}
+		if s, ok := value.Any().(interface{ String() string }); ok {
+			return slog.String(a.Key, Redact(s.String()))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: value}
+}
@@ -0,0 +1,118 @@
+// Package metrics exposes Prometheus metrics for HTTP requests,
+// authentication and the database.
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Reasons passed to TokenFailed.
+const (
+	TokenMissing   = "missing"
+	TokenMalformed = "malformed"
+	TokenInvalid   = "invalid"
+	TokenDisabled  = "disabled"
+	TokenIssue     = "issue"
+)
+
+// Registry holds every metric of the server, along with the Go runtime
+// and process collectors.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by method, route template and status.",
+	}, []string{"method", "route", "status"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by method, route template and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_logins_total",
+		Help: "Login attempts by method and outcome.",
+	}, []string{"method", "outcome"})
+	otpSends = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_otp_sends_total",
+		Help: "Verification codes sent by channel and outcome.",
+	}, []string{"channel", "outcome"})
+	otpVerifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_otp_verifications_total",
+		Help: "Verification code checks by channel and outcome.",
+	}, []string{"channel", "outcome"})
+	tokensIssued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_tokens_issued_total",
+		Help: "Tokens issued by login method.",
+	}, []string{"method"})
+	tokenFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_token_failures_total",
+		Help: "Tokens that failed to be issued or validated, by reason.",
+	}, []string{"reason"})
+
+	dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Database query latency by operation.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration,
+		logins, otpSends, otpVerifications, tokensIssued, tokenFailures,
+		dbQueryDuration,
+	)
+}
+
+// Middleware records the count and latency of requests. Requests are
+// labelled with their route template, such as /api/v1/users/:id, so IDs
+// don't create a series each; unmatched requests share one label.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		httpDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format. If token is
+// set, requests must present it as a bearer token.
+func Handler(token string) http.Handler {
+	metrics := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+	if token == "" {
+		return metrics
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		metrics.ServeHTTP(w, r)
+	})
+}
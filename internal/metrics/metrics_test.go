@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"my-project/internal/events"
+	"my-project/internal/notify"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMiddlewareLabelsRouteTemplate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/users/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	for _, path := range []string{"/users/1", "/users/2", "/missing"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/users/:id", "204")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(httpRequests.WithLabelValues("GET", "unmatched", "404")))
+}
+
+func TestHandlerRequiresToken(t *testing.T) {
+	handler := Handler("secret")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "go_goroutines")
+}
+
+func TestSubscribeCountsLogins(t *testing.T) {
+	bus := events.NewBus()
+	Subscribe(bus)
+	before := testutil.ToFloat64(logins.WithLabelValues(events.MethodSMS, OutcomeFailure))
+
+	require.NoError(t, bus.Publish(context.Background(), events.LoginFailed{Method: events.MethodSMS}))
+	assert.Equal(t, before+1, testutil.ToFloat64(logins.WithLabelValues(events.MethodSMS, OutcomeFailure)))
+}
+
+type failingProvider struct{ notify.LogProvider }
+
+func (failingProvider) Send(ctx context.Context, recipient, message string) error {
+	return errors.New("gateway down")
+}
+
+func TestInstrumentProviderCountsSends(t *testing.T) {
+	ok := InstrumentProvider(events.MethodEmail, notify.LogProvider{})
+	failing := InstrumentProvider(events.MethodSMS, failingProvider{})
+
+	require.NoError(t, ok.Send(context.Background(), "user@example.com", "hi"))
+	require.Error(t, failing.Send(context.Background(), "09121111111", "hi"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(otpSends.WithLabelValues(events.MethodEmail, OutcomeSuccess)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(otpSends.WithLabelValues(events.MethodSMS, OutcomeFailure)))
+}
+
+func TestInstrumentDB(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, InstrumentDB(db, "test"))
+
+	var n int
+	require.NoError(t, db.Raw("SELECT 1").Scan(&n).Error)
+
+	count, err := testutil.GatherAndCount(Registry, "db_query_duration_seconds", "go_sql_open_connections")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	problems, err := testutil.GatherAndLint(Registry)
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+}
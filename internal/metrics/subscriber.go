@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"context"
+	"my-project/internal/events"
+	"my-project/internal/notify"
+)
+
+// Subscribe counts logins as they are published. The subscribers never
+// fail, so they can't fail the login either.
+func Subscribe(bus *events.Bus) {
+	events.Subscribe(bus, func(ctx context.Context, e events.UserLoggedIn) error {
+		logins.WithLabelValues(e.Method, OutcomeSuccess).Inc()
+		return nil
+	})
+	events.Subscribe(bus, func(ctx context.Context, e events.LoginFailed) error {
+		logins.WithLabelValues(e.Method, OutcomeFailure).Inc()
+		return nil
+	})
+}
+
+// TokenIssued counts a token issued to a user who logged in with method.
+func TokenIssued(method string) {
+	tokensIssued.WithLabelValues(method).Inc()
+}
+
+// TokenFailed counts a token that could not be issued or was refused,
+// for one of the Token* reasons.
+func TokenFailed(reason string) {
+	tokenFailures.WithLabelValues(reason).Inc()
+}
+
+// CodeVerified counts a check of a verification code sent over channel.
+func CodeVerified(channel string, ok bool) {
+	otpVerifications.WithLabelValues(channel, outcome(ok)).Inc()
+}
+
+// InstrumentProvider counts the verification codes p sends over channel.
+func InstrumentProvider(channel string, p notify.Provider) notify.Provider {
+	return instrumentedProvider{Provider: p, channel: channel}
+}
+
+type instrumentedProvider struct {
+	notify.Provider
+	channel string
+}
+
+func (p instrumentedProvider) Send(ctx context.Context, recipient, message string) error {
+	err := p.Provider.Send(ctx, recipient, message)
+	otpSends.WithLabelValues(p.channel, outcome(err == nil)).Inc()
+	return err
+}
+
+func outcome(ok bool) string {
+	if ok {
+		return OutcomeSuccess
+	}
+	return OutcomeFailure
+}
@@ -0,0 +1,133 @@
+// Package migrations holds the versioned SQL migrations of the schema and
+// applies them. Migrations live in sql/<driver>/ as pairs of files named
+// NNNN_name.up.sql and NNNN_name.down.sql and are embedded in the binary.
+// Every driver has the same migrations, each written in its SQL dialect.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"my-project/config"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed sql/*/*.sql
+var embedded embed.FS
+
+// Dir is where the migration files live, in a directory per driver,
+// relative to the repository root.
+const Dir = "internal/migrations/sql"
+
+// Drivers are the database drivers migrations are written for.
+var Drivers = []string{config.Postgres, config.SQLite}
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Embedded returns the migrations of driver compiled into the binary,
+// ordered by version.
+func Embedded(driver string) ([]Migration, error) {
+	dir := path.Join("sql", driver)
+	if _, err := fs.Stat(embedded, dir); err != nil {
+		return nil, fmt.Errorf("no migrations for database driver %q", driver)
+	}
+	sub, err := fs.Sub(embedded, dir)
+	if err != nil {
+		return nil, err
+	}
+	return Load(sub)
+}
+
+// Load reads the migrations in the root of fsys, ordered by version. Every
+// version must have both an up and a down file.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_name.up.sql", entry.Name())
+		}
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d: conflicting names %q and %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s: needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Create writes an empty up and down migration named name to dir, numbered
+// after the last migration there, and returns their paths.
+func Create(dir, name string) ([]string, error) {
+	slug := strings.Trim(regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if slug == "" {
+		return nil, fmt.Errorf("invalid migration name %q", name)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var last int64
+	for _, entry := range entries {
+		if match := fileName.FindStringSubmatch(entry.Name()); match != nil {
+			version, _ := strconv.ParseInt(match[1], 10, 64)
+			last = max(last, version)
+		}
+	}
+
+	var paths []string
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(dir, fmt.Sprintf("%04d_%s.%s.sql", last+1, slug, direction))
+		content := fmt.Sprintf("-- %s (%s)\n", name, direction)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
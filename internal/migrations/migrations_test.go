@@ -0,0 +1,209 @@
+package migrations
+
+import (
+	"context"
+	"my-project/config"
+	"my-project/internal/database"
+	"my-project/internal/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestEmbedded(t *testing.T) {
+	migrations, err := Embedded(config.Postgres)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	assert.Equal(t, int64(1), migrations[0].Version)
+	for i := 1; i < len(migrations); i++ {
+		assert.Less(t, migrations[i-1].Version, migrations[i].Version)
+	}
+
+	// Every driver has the same migrations.
+	for _, driver := range Drivers[1:] {
+		other, err := Embedded(driver)
+		require.NoError(t, err)
+		require.Len(t, other, len(migrations), driver)
+		for i := range other {
+			assert.Equal(t, migrations[i].Version, other[i].Version, driver)
+			assert.Equal(t, migrations[i].Name, other[i].Name, driver)
+		}
+	}
+
+	_, err = Embedded("mysql")
+	assert.ErrorContains(t, err, `no migrations for database driver "mysql"`)
+}
+
+// openDatabase returns an empty SQLite database, or the database
+// configured in the environment when DB_DRIVER is set, as in CI.
+func openDatabase(t *testing.T) *gorm.DB {
+	cfg := config.Default()
+	cfg.DB.Driver = config.SQLite
+	cfg.DB.Name = filepath.Join(t.TempDir(), "test.db")
+	if os.Getenv("DB_DRIVER") != "" {
+		var err error
+		cfg, err = config.Load(nil)
+		require.NoError(t, err)
+	}
+	db, err := database.Open(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close(db) })
+	return db
+}
+
+// TestEmbeddedSchema applies the embedded migrations and checks they create
+// the schema of the models.
+func TestEmbeddedSchema(t *testing.T) {
+	db := openDatabase(t)
+	m, err := New(db)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = m.Up(ctx)
+	require.NoError(t, err)
+	for _, model := range []any{
+		&models.User{},
+		&models.AuditEvent{},
+		&models.WebhookSubscription{},
+		&models.OutboxEvent{},
+		&models.WebhookDelivery{},
+		&models.WebhookDeliveryAttempt{},
+	} {
+		stmt := &gorm.Statement{DB: db}
+		require.NoError(t, stmt.Parse(model))
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" {
+				assert.True(t, db.Migrator().HasColumn(model, field.DBName), "%s.%s", stmt.Table, field.DBName)
+			}
+		}
+	}
+
+	// The audit log is append-only.
+	event := models.AuditEvent{Action: "test", Outcome: "success", PrevHash: "", Hash: "h"}
+	require.NoError(t, db.Create(&event).Error)
+	assert.Error(t, db.Model(&event).Update("outcome", "failure").Error)
+
+	_, err = m.Down(ctx, len(m.migrations))
+	require.NoError(t, err)
+	assert.False(t, db.Migrator().HasTable(&models.User{}))
+}
+
+// TestAdoptAutoMigrated applies the embedded migrations to a Postgres
+// database created by AutoMigrate in the first release, before users had
+// versions.
+func TestAdoptAutoMigrated(t *testing.T) {
+	db := openDatabase(t)
+	if !database.IsPostgres(db) {
+		t.Skip("only Postgres databases were created by AutoMigrate")
+	}
+	type User struct {
+		gorm.Model
+		PhoneNumber string `gorm:"uniqueIndex;not null"`
+		Email       string `gorm:"uniqueIndex;not null"`
+		Password    string `gorm:"not null"`
+		Role        string `gorm:"default:'user'"`
+	}
+	require.NoError(t, db.AutoMigrate(&User{}))
+	require.NoError(t, db.Create(&User{PhoneNumber: "09121111111", Email: "user@example.com", Password: "hashed"}).Error)
+
+	m, err := New(db)
+	require.NoError(t, err)
+	ctx := context.Background()
+	_, err = m.Up(ctx)
+	require.NoError(t, err)
+	defer m.Down(ctx, len(m.migrations))
+
+	var user models.User
+	require.NoError(t, db.First(&user).Error)
+	assert.Equal(t, uint(1), user.Version)
+	assert.Empty(t, user.Locale)
+	assert.Nil(t, user.DisabledAt)
+}
+
+func TestLoadRejectsUnpaired(t *testing.T) {
+	_, err := Load(fstest.MapFS{
+		"0001_users.up.sql": {Data: []byte("CREATE TABLE users (id integer)")},
+	})
+	assert.ErrorContains(t, err, "needs both an up and a down file")
+
+	_, err = Load(fstest.MapFS{"users.sql": {Data: []byte("")}})
+	assert.Error(t, err)
+}
+
+func TestUpDownStatus(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	require.NoError(t, err)
+	migrations, err := Load(fstest.MapFS{
+		"0001_users.up.sql":        {Data: []byte("CREATE TABLE users (id integer)")},
+		"0001_users.down.sql":      {Data: []byte("DROP TABLE users")},
+		"0002_user_email.up.sql":   {Data: []byte("ALTER TABLE users ADD COLUMN email text")},
+		"0002_user_email.down.sql": {Data: []byte("ALTER TABLE users DROP COLUMN email")},
+	})
+	require.NoError(t, err)
+	m := NewWithMigrations(db, migrations)
+	ctx := context.Background()
+
+	pending, err := m.Pending(ctx)
+	require.NoError(t, err)
+	assert.Len(t, pending, 2)
+
+	done, err := m.Up(ctx)
+	require.NoError(t, err)
+	assert.Len(t, done, 2)
+	assert.True(t, db.Migrator().HasColumn("users", "email"))
+
+	// Applied migrations are skipped.
+	done, err = m.Up(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, done)
+
+	done, err = m.Down(ctx, 1)
+	require.NoError(t, err)
+	if assert.Len(t, done, 1) {
+		assert.Equal(t, int64(2), done[0].Version)
+	}
+	assert.False(t, db.Migrator().HasColumn("users", "email"))
+
+	statuses, err := m.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.True(t, statuses[0].Applied)
+	assert.False(t, statuses[0].AppliedAt.IsZero())
+	assert.False(t, statuses[1].Applied)
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	require.NoError(t, err)
+	m := NewWithMigrations(db, []Migration{
+		{Version: 1, Name: "broken", Up: "CREATE TABLE users (id integer); SELECT * FROM missing", Down: "DROP TABLE users"},
+	})
+
+	_, err = m.Up(context.Background())
+	assert.ErrorContains(t, err, "migration 1_broken")
+	assert.False(t, db.Migrator().HasTable("users"))
+	pending, err := m.Pending(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, pending, 1)
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0007_old.up.sql"), nil, 0o644))
+
+	paths, err := Create(dir, "Add user locale")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "0008_add_user_locale.up.sql"),
+		filepath.Join(dir, "0008_add_user_locale.down.sql"),
+	}, paths)
+
+	_, err = Create(dir, "!!!")
+	assert.Error(t, err)
+}
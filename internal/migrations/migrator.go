@@ -0,0 +1,201 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"my-project/internal/database"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// lockKey identifies the advisory lock held while migrating, so replicas
+// starting at the same time apply each migration once.
+const lockKey int64 = 7295431017
+
+// schemaMigration is a row of schema_migrations, one per applied migration.
+type schemaMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// Status is a migration and whether it has been applied.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies migrations to a database and records them in the
+// schema_migrations table.
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// New returns a Migrator applying the embedded migrations of the driver of
+// db to it.
+func New(db *gorm.DB) (*Migrator, error) {
+	migrations, err := Embedded(db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	return NewWithMigrations(db, migrations), nil
+}
+
+// NewWithMigrations returns a Migrator applying migrations to db.
+func NewWithMigrations(db *gorm.DB, migrations []Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+// Up applies every pending migration in order and returns the ones applied.
+// Each migration runs in its own transaction.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := exec(tx, migration.Up); err != nil {
+					return err
+				}
+				return tx.Create(&schemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down rolls back the last steps applied migrations, newest first, and
+// returns the ones rolled back.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int64, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for _, version := range versions[:min(steps, len(versions))] {
+			migration, ok := m.find(version)
+			if !ok {
+				return fmt.Errorf("migration %d is applied but missing from this build", version)
+			}
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := exec(tx, migration.Down); err != nil {
+					return err
+				}
+				return tx.Delete(&schemaMigration{Version: version}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status lists every known migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(m.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses[i] = Status{Migration: migration, Applied: ok, AppliedAt: appliedAt}
+	}
+	return statuses, nil
+}
+
+// Pending returns the migrations that have not been applied yet.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, status := range statuses {
+		if !status.Applied {
+			pending = append(pending, status.Migration)
+		}
+	}
+	return pending, nil
+}
+
+func (m *Migrator) find(version int64) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+// locked runs fn on a single connection holding the migration lock, after
+// making sure schema_migrations exists. The lock is a session-level
+// Postgres advisory lock; other databases run unlocked.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *gorm.DB) error) error {
+	return m.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		if database.IsPostgres(conn) {
+			if err := conn.Exec("SELECT pg_advisory_lock(?)", lockKey).Error; err != nil {
+				return fmt.Errorf("acquire migration lock: %w", err)
+			}
+			// Unlock even if ctx was cancelled, or the pooled connection
+			// would keep holding the lock.
+			defer conn.WithContext(context.WithoutCancel(ctx)).Exec("SELECT pg_advisory_unlock(?)", lockKey)
+		}
+		if err := conn.AutoMigrate(&schemaMigration{}); err != nil {
+			return err
+		}
+		return fn(conn)
+	})
+}
+
+// applied returns when each applied migration was applied, by version.
+func (m *Migrator) applied(conn *gorm.DB) (map[int64]time.Time, error) {
+	applied := map[int64]time.Time{}
+	if !conn.Migrator().HasTable(&schemaMigration{}) {
+		return applied, nil
+	}
+	var rows []schemaMigration
+	if err := conn.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		applied[row.Version] = row.AppliedAt
+	}
+	return applied, nil
+}
+
+// exec runs the statements of a migration file. They are sent in one
+// round trip, which the Postgres driver supports since no arguments are
+// passed.
+func exec(tx *gorm.DB, sql string) error {
+	if strings.TrimSpace(sql) == "" {
+		return nil
+	}
+	return tx.Exec(sql).Error
+}
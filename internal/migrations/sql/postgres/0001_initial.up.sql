@@ -0,0 +1,133 @@
+-- Baseline schema. Every statement is idempotent so databases created by
+-- the old AutoMigrate-on-boot can be brought under versioned migrations.
+
+CREATE TABLE IF NOT EXISTS users (
+	id bigserial PRIMARY KEY,
+	created_at timestamptz,
+	updated_at timestamptz,
+	deleted_at timestamptz,
+	phone_number text NOT NULL,
+	email text NOT NULL,
+	password text NOT NULL,
+	role text DEFAULT 'user',
+	verification_code text,
+	verification_code_expires_at timestamptz,
+	email_verification_code text,
+	email_verification_code_expires_at timestamptz,
+	version bigint NOT NULL DEFAULT 1
+);
+
+-- CREATE TABLE IF NOT EXISTS leaves the users tables of the first releases
+-- alone, so add the columns they lack.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 1;
+
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+
+-- Phone numbers and emails are only unique among non-deleted users. These
+-- replace the unique indexes that covered soft-deleted rows too.
+DROP INDEX IF EXISTS idx_users_phone_number;
+DROP INDEX IF EXISTS idx_users_email;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_phone_number_active ON users (phone_number) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_active ON users (email) WHERE deleted_at IS NULL;
+
+-- Trigram indexes used by user search.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS idx_users_phone_number_trgm ON users USING gin (phone_number gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_users_email_trgm ON users USING gin (email gin_trgm_ops);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+	id bigserial PRIMARY KEY,
+	created_at timestamptz,
+	actor text,
+	actor_role text,
+	action text NOT NULL,
+	outcome text NOT NULL,
+	target_type text,
+	target_id text,
+	changes text,
+	ip text,
+	user_agent text,
+	request_id text,
+	prev_hash text NOT NULL,
+	hash text NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events (created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events (actor);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events (action);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target ON audit_events (target_type, target_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_events_hash ON audit_events (hash);
+
+-- audit_events is append-only, even for clients bypassing the application.
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+	FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events;
+CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+	FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+	id bigserial PRIMARY KEY,
+	created_at timestamptz,
+	updated_at timestamptz,
+	url text NOT NULL,
+	secret text NOT NULL,
+	event_types text NOT NULL,
+	active boolean NOT NULL DEFAULT true
+);
+
+CREATE TABLE IF NOT EXISTS outbox_events (
+	id bigserial PRIMARY KEY,
+	created_at timestamptz,
+	event_type text NOT NULL,
+	payload text NOT NULL,
+	dispatched_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_events_dispatched_at ON outbox_events (dispatched_at);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id bigserial PRIMARY KEY,
+	created_at timestamptz,
+	updated_at timestamptz,
+	subscription_id bigint NOT NULL,
+	outbox_event_id bigint NOT NULL,
+	event_type text NOT NULL,
+	status text NOT NULL,
+	attempts bigint NOT NULL DEFAULT 0,
+	next_attempt_at timestamptz,
+	last_status_code bigint,
+	last_error text,
+	delivered_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription_id ON webhook_deliveries (subscription_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_outbox_event_id ON webhook_deliveries (outbox_event_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery_attempts (
+	id bigserial PRIMARY KEY,
+	created_at timestamptz,
+	delivery_id bigint NOT NULL,
+	status_code bigint,
+	error text,
+	duration_ms bigint
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_attempts_delivery_id ON webhook_delivery_attempts (delivery_id);
+
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_webhook_deliveries_attempt_log') THEN
+		ALTER TABLE webhook_delivery_attempts ADD CONSTRAINT fk_webhook_deliveries_attempt_log
+			FOREIGN KEY (delivery_id) REFERENCES webhook_deliveries (id);
+	END IF;
+END;
+$$;
@@ -0,0 +1,4 @@
+-- Full-text index used by user search, matching whole words and prefixes
+-- of phone numbers and emails. The expression must match searchDocument in
+-- the user repository for the index to be used.
+CREATE INDEX IF NOT EXISTS idx_users_search ON users USING gin (to_tsvector('simple', phone_number || ' ' || email));
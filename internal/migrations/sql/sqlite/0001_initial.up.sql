@@ -0,0 +1,108 @@
+-- Baseline schema, as 0001_initial of Postgres.
+
+CREATE TABLE users (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	created_at datetime,
+	updated_at datetime,
+	deleted_at datetime,
+	phone_number text NOT NULL,
+	email text NOT NULL,
+	password text NOT NULL,
+	role text DEFAULT 'user',
+	verification_code text,
+	verification_code_expires_at datetime,
+	email_verification_code text,
+	email_verification_code_expires_at datetime,
+	version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX idx_users_deleted_at ON users (deleted_at);
+
+-- Phone numbers and emails are only unique among non-deleted users. Search
+-- falls back to LIKE scans, so there are no trigram indexes.
+CREATE UNIQUE INDEX idx_users_phone_number_active ON users (phone_number) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX idx_users_email_active ON users (email) WHERE deleted_at IS NULL;
+
+CREATE TABLE audit_events (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	created_at datetime,
+	actor text,
+	actor_role text,
+	action text NOT NULL,
+	outcome text NOT NULL,
+	target_type text,
+	target_id text,
+	changes text,
+	ip text,
+	user_agent text,
+	request_id text,
+	prev_hash text NOT NULL,
+	hash text NOT NULL
+);
+
+CREATE INDEX idx_audit_events_created_at ON audit_events (created_at);
+CREATE INDEX idx_audit_events_actor ON audit_events (actor);
+CREATE INDEX idx_audit_events_action ON audit_events (action);
+CREATE INDEX idx_audit_events_target ON audit_events (target_type, target_id);
+CREATE UNIQUE INDEX idx_audit_events_hash ON audit_events (hash);
+
+-- audit_events is append-only, even for clients bypassing the application.
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+	SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+	SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TABLE webhook_subscriptions (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	created_at datetime,
+	updated_at datetime,
+	url text NOT NULL,
+	secret text NOT NULL,
+	event_types text NOT NULL,
+	active numeric NOT NULL DEFAULT true
+);
+
+CREATE TABLE outbox_events (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	created_at datetime,
+	event_type text NOT NULL,
+	payload text NOT NULL,
+	dispatched_at datetime
+);
+
+CREATE INDEX idx_outbox_events_dispatched_at ON outbox_events (dispatched_at);
+
+CREATE TABLE webhook_deliveries (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	created_at datetime,
+	updated_at datetime,
+	subscription_id integer NOT NULL,
+	outbox_event_id integer NOT NULL,
+	event_type text NOT NULL,
+	status text NOT NULL,
+	attempts integer NOT NULL DEFAULT 0,
+	next_attempt_at datetime,
+	last_status_code integer,
+	last_error text,
+	delivered_at datetime
+);
+
+CREATE INDEX idx_webhook_deliveries_subscription_id ON webhook_deliveries (subscription_id);
+CREATE INDEX idx_webhook_deliveries_outbox_event_id ON webhook_deliveries (outbox_event_id);
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
+
+CREATE TABLE webhook_delivery_attempts (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	created_at datetime,
+	delivery_id integer NOT NULL,
+	status_code integer,
+	error text,
+	duration_ms integer,
+	CONSTRAINT fk_webhook_deliveries_attempt_log FOREIGN KEY (delivery_id) REFERENCES webhook_deliveries (id)
+);
+
+CREATE INDEX idx_webhook_delivery_attempts_delivery_id ON webhook_delivery_attempts (delivery_id);
@@ -0,0 +1 @@
+-- Nothing to drop, see the up migration.
@@ -0,0 +1,2 @@
+-- SQLite searches with LIKE, which can't use an index for substrings, so
+-- there is nothing to create.
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrAuditEventImmutable is returned when trying to change a recorded audit event.
+var ErrAuditEventImmutable = errors.New("audit events are append-only")
+
+// AuditEvent records who did what to which resource. Events form a hash
+// chain: each one stores the hash of the event before it, so editing or
+// removing a row breaks every hash after it.
+type AuditEvent struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+	Actor      string    `gorm:"index" json:"actor"`
+	ActorRole  string    `json:"actor_role"`
+	Action     string    `gorm:"index;not null" json:"action"`
+	Outcome    string    `gorm:"not null" json:"outcome"`
+	TargetType string    `gorm:"index:idx_audit_events_target" json:"target_type"`
+	TargetID   string    `gorm:"index:idx_audit_events_target" json:"target_id"`
+	Changes    Changes   `gorm:"type:text" json:"changes,omitempty"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	RequestID  string    `json:"request_id"`
+	PrevHash   string    `gorm:"not null" json:"prev_hash"`
+	Hash       string    `gorm:"uniqueIndex;not null" json:"hash"`
+}
+
+// BeforeUpdate keeps audit events from being modified through GORM.
+func (e *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
+
+// BeforeDelete keeps audit events from being deleted through GORM.
+func (e *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
+
+// Change is the value of a field before and after an action.
+type Change struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Changes maps field names to how they changed. It is stored as JSON text.
+type Changes map[string]Change
+
+// Value implements driver.Valuer.
+func (c Changes) Value() (driver.Value, error) {
+	if len(c) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(c)
+	return string(b), err
+}
+
+// Scan implements sql.Scanner.
+func (c *Changes) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("cannot scan %T into Changes", value)
+	}
+	return json.Unmarshal(data, c)
+}
@@ -9,13 +9,31 @@ type User struct {
 	ID                           uint      `gorm:"primarykey" json:"id"`
 	CreatedAt                    time.Time `json:"created_at"`
 	UpdatedAt                    time.Time `json:"updated_at"`
-	DeletedAt                    gorm.DeletedAt `gorm:"index" json:"-"`
-	PhoneNumber                  string    `gorm:"uniqueIndex;not null" json:"phone_number"`
-	Email                        string    `gorm:"uniqueIndex;not null" json:"email"`
+	DeletedAt                    gorm.DeletedAt `gorm:"index" json:"deleted_at" swaggertype:"string" format:"date-time"`
+	// Phone numbers and emails are only unique among non-deleted users, so a
+	// soft-deleted account doesn't block anyone from signing up again.
+	PhoneNumber                  string    `gorm:"uniqueIndex:idx_users_phone_number_active,where:deleted_at IS NULL;not null" json:"phone_number"`
+	Email                        string    `gorm:"uniqueIndex:idx_users_email_active,where:deleted_at IS NULL;not null" json:"email"`
 	Password                     string    `gorm:"not null" json:"-"`
 	Role                         string    `gorm:"default:'user'" json:"role"`
+	// Locale is the language the user prefers for messages, such as "fa".
+	// It is empty when the user hasn't chosen one.
+	Locale                       string    `gorm:"not null;default:''" json:"locale"`
 	VerificationCode             string    `json:"-"`
 	VerificationCodeExpiresAt    time.Time `json:"-"`
 	EmailVerificationCode        string    `json:"-"`
 	EmailVerificationCodeExpiresAt time.Time `json:"-"`
+	// Version is bumped on every update and backs the ETag of the user resource.
+	Version                      uint      `gorm:"not null;default:1" json:"version"`
+	// DisabledAt is set while the account is disabled. Disabled users
+	// can't log in or use the tokens they already have.
+	DisabledAt                   *time.Time `json:"disabled_at,omitempty"`
+}
+
+// BeforeCreate starts every new user at version 1.
+func (u *User) BeforeCreate(tx *gorm.DB) error {
+	if u.Version == 0 {
+		u.Version = 1
+	}
+	return nil
 }
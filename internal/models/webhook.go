@@ -0,0 +1,103 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Delivery statuses. A delivery that runs out of attempts is dead-lettered
+// and only retried when an admin asks for it.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryDead      = "dead"
+)
+
+// WebhookSubscription is an endpoint that is notified of user lifecycle events.
+type WebhookSubscription struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	URL        string     `gorm:"not null" json:"url"`
+	Secret     string     `gorm:"not null" json:"-"`
+	EventTypes StringList `gorm:"type:text;not null" json:"event_types"`
+	Active     bool       `gorm:"not null;default:true" json:"active"`
+}
+
+// Subscribes reports whether s wants events of eventType. The "*" event
+// type subscribes to everything.
+func (s WebhookSubscription) Subscribes(eventType string) bool {
+	for _, t := range s.EventTypes {
+		if t == "*" || t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// OutboxEvent is an event waiting to be fanned out to webhook
+// subscriptions. It is written in the same transaction as the change it
+// describes, so committed changes always produce their events.
+type OutboxEvent struct {
+	ID           uint       `gorm:"primarykey" json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	EventType    string     `gorm:"not null" json:"event_type"`
+	Payload      string     `gorm:"type:text;not null" json:"payload"`
+	DispatchedAt *time.Time `gorm:"index" json:"dispatched_at"`
+}
+
+// WebhookDelivery tracks sending one outbox event to one subscription.
+type WebhookDelivery struct {
+	ID             uint                     `gorm:"primarykey" json:"id"`
+	CreatedAt      time.Time                `json:"created_at"`
+	UpdatedAt      time.Time                `json:"updated_at"`
+	SubscriptionID uint                     `gorm:"index;not null" json:"subscription_id"`
+	OutboxEventID  uint                     `gorm:"index;not null" json:"outbox_event_id"`
+	EventType      string                   `gorm:"not null" json:"event_type"`
+	Status         string                   `gorm:"index:idx_webhook_deliveries_due;not null" json:"status"`
+	Attempts       int                      `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt  time.Time                `gorm:"index:idx_webhook_deliveries_due" json:"next_attempt_at"`
+	LastStatusCode int                      `json:"last_status_code"`
+	LastError      string                   `json:"last_error"`
+	DeliveredAt    *time.Time               `json:"delivered_at"`
+	AttemptLog     []WebhookDeliveryAttempt `gorm:"foreignKey:DeliveryID" json:"attempt_log,omitempty"`
+}
+
+// WebhookDeliveryAttempt is the delivery log entry for a single HTTP request.
+type WebhookDeliveryAttempt struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	DeliveryID uint      `gorm:"index;not null" json:"delivery_id"`
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// StringList is a list of strings stored as JSON text.
+type StringList []string
+
+// Value implements driver.Valuer.
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		l = StringList{}
+	}
+	b, err := json.Marshal(l)
+	return string(b), err
+}
+
+// Scan implements sql.Scanner.
+func (l *StringList) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	case []byte:
+		return json.Unmarshal(v, l)
+	default:
+		return fmt.Errorf("cannot scan %T into StringList", value)
+	}
+}
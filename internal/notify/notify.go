@@ -0,0 +1,62 @@
+// Package notify sends messages to users over SMS and email.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"my-project/internal/events"
+	"my-project/internal/i18n"
+	"my-project/internal/logging"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("my-project/internal/notify")
+
+// Provider delivers messages over one channel.
+type Provider interface {
+	// Send delivers message to recipient, a phone number or email.
+	Send(ctx context.Context, recipient, message string) error
+	// Check reports whether the provider can be reached, for readiness checks.
+	Check(ctx context.Context) error
+}
+
+// LogProvider logs messages instead of sending them. It is used when no
+// real provider is configured, such as in development.
+type LogProvider struct{}
+
+func (LogProvider) Send(ctx context.Context, recipient, message string) error {
+	logging.FromContext(ctx).InfoContext(ctx, "Message not sent, no provider is configured", "recipient", recipient, "message", message)
+	return nil
+}
+
+func (LogProvider) Check(ctx context.Context) error {
+	return nil
+}
+
+// Subscribe sends verification codes when they are issued, by SMS or
+// email depending on their channel. Messages are in the locale of the
+// event, which is also set on the context passed to the provider.
+func Subscribe(bus *events.Bus, sms, email Provider) {
+	events.Subscribe(bus, func(ctx context.Context, e events.VerificationCodeIssued) error {
+		provider := sms
+		if e.Channel == events.MethodEmail {
+			provider = email
+		}
+		ctx, span := tracer.Start(ctx, "notify.Send", trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attribute.String("notify.channel", e.Channel)))
+		defer span.End()
+
+		ctx = i18n.WithLocale(ctx, e.Locale)
+		message := i18n.T(ctx, "notify.verification_code", e.Code, e.ExpiresAt.Format("15:04"))
+		if err := provider.Send(ctx, e.Recipient, message); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return fmt.Errorf("send verification code over %s: %w", e.Channel, err)
+		}
+		return nil
+	})
+}
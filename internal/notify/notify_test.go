@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"my-project/internal/events"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProvider struct {
+	sent []string
+	err  error
+}
+
+func (p *fakeProvider) Send(ctx context.Context, recipient, message string) error {
+	p.sent = append(p.sent, recipient+": "+message)
+	return p.err
+}
+
+func (p *fakeProvider) Check(ctx context.Context) error { return p.err }
+
+func TestSubscribeRoutesByChannel(t *testing.T) {
+	bus := events.NewBus()
+	sms, email := &fakeProvider{}, &fakeProvider{}
+	Subscribe(bus, sms, email)
+	expiresAt := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+
+	require.NoError(t, bus.Publish(context.Background(), events.VerificationCodeIssued{
+		Channel: events.MethodSMS, Recipient: "09121111111", Code: "123456", ExpiresAt: expiresAt,
+	}))
+	require.NoError(t, bus.Publish(context.Background(), events.VerificationCodeIssued{
+		Channel: events.MethodEmail, Recipient: "user@example.com", Code: "654321", ExpiresAt: expiresAt,
+	}))
+
+	assert.Equal(t, []string{"09121111111: Your verification code is 123456. It expires at 10:30."}, sms.sent)
+	assert.Equal(t, []string{"user@example.com: Your verification code is 654321. It expires at 10:30."}, email.sent)
+
+	// Codes are sent in the recipient's language.
+	require.NoError(t, bus.Publish(context.Background(), events.VerificationCodeIssued{
+		Channel: events.MethodSMS, Recipient: "09122222222", Code: "111111", ExpiresAt: expiresAt, Locale: "fa",
+	}))
+	assert.Equal(t, "09122222222: کد تأیید شما 111111 است و تا ساعت 10:30 معتبر است.", sms.sent[1])
+
+	// A failed delivery fails the request for the code.
+	sms.err = errors.New("gateway down")
+	err := bus.Publish(context.Background(), events.VerificationCodeIssued{Channel: events.MethodSMS, Recipient: "09121111111"})
+	assert.ErrorIs(t, err, sms.err)
+}
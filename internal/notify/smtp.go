@@ -0,0 +1,107 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"my-project/internal/i18n"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPProvider sends email through an SMTP server.
+type SMTPProvider struct {
+	// Addr is the host:port of the server.
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (p *SMTPProvider) Send(ctx context.Context, recipient, message string) error {
+	client, err := p.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if p.Username != "" {
+		host, _, _ := net.SplitHostPort(p.Addr)
+		if err := client.Auth(smtp.PlainAuth("", p.Username, p.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(p.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(recipient); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	body := strings.Join([]string{
+		"From: " + p.From,
+		"To: " + recipient,
+		"Subject: " + mime.BEncoding.Encode("UTF-8", i18n.T(ctx, "notify.verification_code_subject")),
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		message,
+	}, "\r\n")
+	if _, err := w.Write([]byte(body)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Check connects to the server and greets it.
+func (p *SMTPProvider) Check(ctx context.Context) error {
+	client, err := p.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if err := client.Noop(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// dial connects to the server, upgrading to TLS if it offers STARTTLS.
+// The connection can't outlive ctx, nor be used once it is cancelled.
+func (p *SMTPProvider) dial(ctx context.Context) (*smtp.Client, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(30 * time.Second))
+	}
+	// Abort the conversation as soon as ctx is cancelled, such as when the
+	// client whose request sends the message goes away.
+	context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+
+	host, _, _ := net.SplitHostPort(p.Addr)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("smtp: %w", err)
+	}
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("smtp: %w", err)
+		}
+	}
+	return client, nil
+}
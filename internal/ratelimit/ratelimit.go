@@ -0,0 +1,87 @@
+// Package ratelimit limits how often clients can call an endpoint, such as
+// login, to slow down password guessing and code flooding.
+package ratelimit
+
+import (
+	"math"
+	"my-project/internal/apierr"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limiter is a token bucket per key. Each key can make up to requests
+// requests at once, and gets them back evenly over window.
+type Limiter struct {
+	requests int
+	window   time.Duration
+	now      func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New returns a limiter allowing requests requests per window and key.
+func New(requests int, window time.Duration) *Limiter {
+	return &Limiter{requests: requests, window: window, now: time.Now, buckets: map[string]*bucket{}}
+}
+
+// Allow takes a token from the bucket of key. If it is empty, it returns
+// false and how long until the next token.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.prune(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.requests), last: now}
+		l.buckets[key] = b
+	}
+	perToken := l.window / time.Duration(l.requests)
+	b.tokens = math.Min(float64(l.requests), b.tokens+float64(now.Sub(b.last))/float64(perToken))
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune forgets the buckets that have filled up again, once per window,
+// so clients that went away don't hold memory.
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.window {
+		return
+	}
+	l.lastPrune = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.window {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Middleware limits requests per client IP, answering 429 with a
+// Retry-After header to those over the limit.
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ok, wait := l.Allow(c.ClientIP()); !ok {
+			seconds := int(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			apierr.Abort(c, apierr.New(http.StatusTooManyRequests, apierr.CodeRateLimited, "error.rate_limited", seconds))
+			return
+		}
+		c.Next()
+	}
+}
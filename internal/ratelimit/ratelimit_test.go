@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"my-project/internal/apierr"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := New(3, time.Minute)
+	l.now = func() time.Time { return now }
+
+	for range 3 {
+		ok, _ := l.Allow("1.2.3.4")
+		assert.True(t, ok)
+	}
+	ok, wait := l.Allow("1.2.3.4")
+	assert.False(t, ok)
+	assert.Equal(t, 20*time.Second, wait)
+
+	// Other clients have their own bucket.
+	ok, _ = l.Allow("5.6.7.8")
+	assert.True(t, ok)
+
+	// A token comes back every window/requests.
+	now = now.Add(20 * time.Second)
+	ok, _ = l.Allow("1.2.3.4")
+	assert.True(t, ok)
+	ok, _ = l.Allow("1.2.3.4")
+	assert.False(t, ok)
+
+	// Idle clients are forgotten.
+	now = now.Add(time.Hour)
+	l.Allow("1.2.3.4")
+	assert.Len(t, l.buckets, 1)
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(apierr.Middleware())
+	r.POST("/login", New(1, time.Minute).Middleware(), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	login := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", nil))
+		return w
+	}
+
+	assert.Equal(t, http.StatusNoContent, login().Code)
+	w := login()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	var problem apierr.Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, apierr.CodeRateLimited, problem.Code)
+	assert.Equal(t, "Too many requests, try again in 60 seconds", problem.Detail)
+}
@@ -0,0 +1,295 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"my-project/internal/database"
+	"my-project/internal/models"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GormUserRepository stores users with GORM.
+type GormUserRepository struct {
+	db *gorm.DB
+}
+
+var _ UserRepository = (*GormUserRepository)(nil)
+
+// NewGormUserRepository returns a UserRepository backed by db.
+func NewGormUserRepository(db *gorm.DB) *GormUserRepository {
+	return &GormUserRepository{db: db}
+}
+
+// conn returns the transaction carried by ctx, or the repository's database.
+func (r *GormUserRepository) conn(ctx context.Context) *gorm.DB {
+	return database.Conn(ctx, r.db)
+}
+
+// Transaction runs fn in a database transaction. The transaction is put in
+// the context with database.WithTx, so event subscribers writing with
+// database.Conn join it too.
+func (r *GormUserRepository) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(database.WithTx(ctx, tx))
+	})
+}
+
+func (r *GormUserRepository) Create(ctx context.Context, user *models.User) error {
+	return duplicate(r.conn(ctx).Create(user).Error)
+}
+
+func (r *GormUserRepository) CreateBatch(ctx context.Context, users []*models.User) error {
+	return duplicate(r.conn(ctx).CreateInBatches(users, 500).Error)
+}
+
+// duplicate returns ErrDuplicate for the unique violation of another user
+// having the same phone number or email, and err otherwise. The users table
+// has no other unique index the repository can violate. SQLite drivers only
+// tell unique violations apart by their message.
+func duplicate(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" ||
+		err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *GormUserRepository) Get(ctx context.Context, id uint) (models.User, error) {
+	return first(r.conn(ctx), "id = ?", id)
+}
+
+func (r *GormUserRepository) GetDeleted(ctx context.Context, id uint) (models.User, error) {
+	return first(r.conn(ctx).Unscoped().Where("deleted_at IS NOT NULL"), "id = ?", id)
+}
+
+func (r *GormUserRepository) GetAny(ctx context.Context, id uint) (models.User, error) {
+	return first(r.conn(ctx).Unscoped(), "id = ?", id)
+}
+
+func (r *GormUserRepository) FindByPhoneNumber(ctx context.Context, phoneNumber string) (models.User, error) {
+	return first(r.conn(ctx), "phone_number = ?", phoneNumber)
+}
+
+func (r *GormUserRepository) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	return first(r.conn(ctx), "email = ?", email)
+}
+
+func first(db *gorm.DB, query string, args ...interface{}) (models.User, error) {
+	var user models.User
+	if err := db.Where(query, args...).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.User{}, ErrNotFound
+		}
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+func (r *GormUserRepository) filtered(ctx context.Context, filter UserFilter) *gorm.DB {
+	query := r.conn(ctx)
+	if filter.Deleted {
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	return query
+}
+
+func (r *GormUserRepository) List(ctx context.Context, filter UserFilter) ([]models.User, error) {
+	var users []models.User
+	err := r.filtered(ctx, filter).Find(&users).Error
+	return users, err
+}
+
+func (r *GormUserRepository) Each(ctx context.Context, filter UserFilter, batchSize int, fn func([]models.User) error) error {
+	var batch []models.User
+	return r.filtered(ctx, filter).Order("id").FindInBatches(&batch, batchSize, func(tx *gorm.DB, _ int) error {
+		return fn(batch)
+	}).Error
+}
+
+func (r *GormUserRepository) Search(ctx context.Context, query string, limit int) ([]SearchHit, error) {
+	if database.IsPostgres(r.db) {
+		return r.searchPostgres(ctx, query, limit)
+	}
+	return r.searchLike(ctx, query, limit)
+}
+
+type searchRow struct {
+	models.User
+	Rank float64
+}
+
+// searchDocument is the text search document of a user. It must match the
+// expression of the idx_users_search index.
+const searchDocument = "to_tsvector('simple', phone_number || ' ' || email)"
+
+// searchPostgres ranks matches by trigram similarity, using the pg_trgm
+// indexes created by the initial migration, plus the text search rank of
+// the words of query, so whole words and prefixes rank higher.
+func (r *GormUserRepository) searchPostgres(ctx context.Context, query string, limit int) ([]SearchHit, error) {
+	pattern := "%" + escapeLike(query) + "%"
+	words := prefixQuery(query)
+	return scanHits(r.conn(ctx).Model(&models.User{}).
+		Select("users.*, GREATEST(similarity(phone_number, ?), similarity(email, ?)) + ts_rank("+searchDocument+", to_tsquery('simple', ?)) AS rank",
+			query, query, words).
+		Where("phone_number ILIKE ? OR email ILIKE ? OR phone_number % ? OR email % ? OR "+searchDocument+" @@ to_tsquery('simple', ?)",
+			pattern, pattern, query, query, words).
+		Order("rank DESC, id").
+		Limit(limit))
+}
+
+// prefixQuery returns a tsquery matching documents with a word starting
+// with each word of query.
+func prefixQuery(query string) string {
+	words := strings.Fields(query)
+	for i, word := range words {
+		word = strings.NewReplacer(`\`, `\\`, "'", "''").Replace(word)
+		words[i] = "'" + word + "':*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// searchLike is the portable fallback used on SQLite. It ranks matches as
+// matchRank does, since there is no similarity function to lean on.
+func (r *GormUserRepository) searchLike(ctx context.Context, query string, limit int) ([]SearchHit, error) {
+	pattern := "%" + escapeLike(query) + "%"
+	prefix := escapeLike(query) + "%"
+	length := float64(utf8.RuneCountInString(query))
+	rank := func(column string) (string, []interface{}) {
+		return `CASE WHEN lower(` + column + `) = lower(?) THEN 1
+			WHEN ` + column + ` LIKE ? ESCAPE '\' THEN 0.5 + 0.5 * ? / length(` + column + `)
+			WHEN ` + column + ` LIKE ? ESCAPE '\' THEN 0.25 + 0.5 * ? / length(` + column + `)
+			ELSE 0 END`, []interface{}{query, prefix, length, pattern, length}
+	}
+	phoneRank, phoneArgs := rank("phone_number")
+	emailRank, emailArgs := rank("email")
+	return scanHits(r.conn(ctx).Model(&models.User{}).
+		Select("users.*, max("+phoneRank+", "+emailRank+") AS rank", append(phoneArgs, emailArgs...)...).
+		Where(`phone_number LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\'`, pattern, pattern).
+		Order("rank DESC, id").
+		Limit(limit))
+}
+
+// scanHits runs a search query selecting users and their rank.
+func scanHits(query *gorm.DB) ([]SearchHit, error) {
+	var rows []searchRow
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	hits := make([]SearchHit, len(rows))
+	for i, row := range rows {
+		hits[i] = SearchHit{User: row.User, Rank: row.Rank}
+	}
+	return hits, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s so it is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func (r *GormUserRepository) TakenIdentities(ctx context.Context, phoneNumbers, emails []string) ([]string, []string, error) {
+	taken := func(column string, values []string) ([]string, error) {
+		var found []string
+		for start := 0; start < len(values); start += 500 {
+			end := min(start+500, len(values))
+			var batch []string
+			if err := r.conn(ctx).Model(&models.User{}).
+				Where(column+" IN ?", values[start:end]).
+				Pluck(column, &batch).Error; err != nil {
+				return nil, err
+			}
+			found = append(found, batch...)
+		}
+		return found, nil
+	}
+
+	takenPhones, err := taken("phone_number", phoneNumbers)
+	if err != nil {
+		return nil, nil, err
+	}
+	takenEmails, err := taken("email", emails)
+	if err != nil {
+		return nil, nil, err
+	}
+	return takenPhones, takenEmails, nil
+}
+
+func (r *GormUserRepository) Update(ctx context.Context, user *models.User) error {
+	expected := user.Version
+	user.Version = expected + 1
+	result := r.conn(ctx).Model(user).
+		Where("version = ?", expected).
+		Select("*").
+		Omit("created_at", "deleted_at",
+			"verification_code", "verification_code_expires_at",
+			"email_verification_code", "email_verification_code_expires_at").
+		Updates(user)
+	if result.Error != nil {
+		user.Version = expected
+		return duplicate(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		user.Version = expected
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+func (r *GormUserRepository) SaveVerificationCodes(ctx context.Context, user *models.User) error {
+	return r.conn(ctx).Model(user).
+		Select("verification_code", "verification_code_expires_at",
+			"email_verification_code", "email_verification_code_expires_at").
+		Updates(user).Error
+}
+
+func (r *GormUserRepository) Delete(ctx context.Context, user *models.User, purge bool) error {
+	query := r.conn(ctx)
+	if purge {
+		query = query.Unscoped()
+	}
+	result := query.Where("version = ?", user.Version).Delete(user)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+func (r *GormUserRepository) Restore(ctx context.Context, user *models.User) error {
+	// Update through a blank model, so that user is left as it was if
+	// the update fails.
+	version, now := user.Version+1, time.Now()
+	result := r.conn(ctx).Unscoped().Model(&models.User{}).
+		Where("id = ? AND version = ?", user.ID, user.Version).
+		Updates(map[string]interface{}{
+			"deleted_at": nil,
+			"version":    version,
+			"updated_at": now,
+		})
+	if result.Error != nil {
+		return duplicate(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrVersionConflict
+	}
+	user.DeletedAt = gorm.DeletedAt{}
+	user.Version, user.UpdatedAt = version, now
+	return nil
+}
+
+func (r *GormUserRepository) PurgeDeletedBefore(ctx context.Context, cutoff time.Time) ([]models.User, error) {
+	var users []models.User
+	err := r.conn(ctx).Unscoped().Clauses(clause.Returning{}).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+		Delete(&users).Error
+	return users, err
+}
@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"my-project/internal/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestSaveVerificationCodesKeepsUpdates(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}))
+
+	for name, r := range map[string]UserRepository{
+		"gorm":   NewGormUserRepository(db),
+		"memory": NewMemoryUserRepository(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			user := models.User{PhoneNumber: "09121111111", Email: "user@example.com", Password: "hashed"}
+			require.NoError(t, r.Create(ctx, &user))
+
+			// A code login read the user before an admin changed it.
+			stale, err := r.Get(ctx, user.ID)
+			require.NoError(t, err)
+			user.Role = "admin"
+			require.NoError(t, r.Update(ctx, &user))
+
+			stale.VerificationCode = "123456"
+			stale.VerificationCodeExpiresAt = time.Now().Add(time.Minute)
+			require.NoError(t, r.SaveVerificationCodes(ctx, &stale))
+
+			got, err := r.Get(ctx, user.ID)
+			require.NoError(t, err)
+			assert.Equal(t, "123456", got.VerificationCode)
+			assert.Equal(t, "admin", got.Role)
+			assert.Equal(t, user.Version, got.Version)
+		})
+	}
+}
+
+func TestUpdateKeepsVerificationCodes(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}))
+
+	for name, r := range map[string]UserRepository{
+		"gorm":   NewGormUserRepository(db),
+		"memory": NewMemoryUserRepository(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			user := models.User{PhoneNumber: "09121111111", Email: "user@example.com", Password: "hashed"}
+			require.NoError(t, r.Create(ctx, &user))
+
+			// An admin read the user before a code was sent to it.
+			stale, err := r.Get(ctx, user.ID)
+			require.NoError(t, err)
+			user.VerificationCode = "123456"
+			user.VerificationCodeExpiresAt = time.Now().Add(time.Minute)
+			user.EmailVerificationCode = "654321"
+			user.EmailVerificationCodeExpiresAt = time.Now().Add(time.Minute)
+			require.NoError(t, r.SaveVerificationCodes(ctx, &user))
+
+			stale.Role = "admin"
+			require.NoError(t, r.Update(ctx, &stale))
+
+			got, err := r.Get(ctx, user.ID)
+			require.NoError(t, err)
+			assert.Equal(t, "admin", got.Role)
+			assert.Equal(t, "123456", got.VerificationCode)
+			assert.Equal(t, "654321", got.EmailVerificationCode)
+			assert.False(t, got.VerificationCodeExpiresAt.IsZero())
+			assert.False(t, got.EmailVerificationCodeExpiresAt.IsZero())
+		})
+	}
+}
+
+func TestRestoreChecksVersionAndIdentity(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}))
+
+	for name, r := range map[string]UserRepository{
+		"gorm":   NewGormUserRepository(db),
+		"memory": NewMemoryUserRepository(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			user := models.User{PhoneNumber: "09122222222", Email: "restore@example.com", Password: "hashed"}
+			require.NoError(t, r.Create(ctx, &user))
+			require.NoError(t, r.Delete(ctx, &user, false))
+
+			stale := user
+			stale.Version--
+			assert.ErrorIs(t, r.Restore(ctx, &stale), ErrVersionConflict)
+
+			// Someone signed up with the same phone number since.
+			other := models.User{PhoneNumber: user.PhoneNumber, Email: "other@example.com", Password: "hashed"}
+			require.NoError(t, r.Create(ctx, &other))
+			deleted := user
+			assert.ErrorIs(t, r.Restore(ctx, &deleted), ErrDuplicate)
+			assert.Equal(t, user.Version, deleted.Version)
+			assert.True(t, deleted.DeletedAt.Valid)
+
+			require.NoError(t, r.Delete(ctx, &other, true))
+			require.NoError(t, r.Restore(ctx, &deleted))
+			assert.Equal(t, user.Version+1, deleted.Version)
+			_, err := r.Get(ctx, user.ID)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestSearchLikeRanksLikeMemory(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}))
+	gormRepo, memoryRepo := NewGormUserRepository(db), NewMemoryUserRepository()
+
+	ctx := context.Background()
+	for _, user := range []models.User{
+		{PhoneNumber: "09121234567", Email: "ali@example.com"},
+		{PhoneNumber: "09351234000", Email: "sara@example.com"},
+		{PhoneNumber: "09120000000", Email: "1234@example.com"},
+		{PhoneNumber: "09131111111", Email: "1234"},
+		{PhoneNumber: "09131111112", Email: "reza_1234@example.org"},
+	} {
+		user.Password = "hashed"
+		memoryUser := user
+		require.NoError(t, gormRepo.Create(ctx, &user))
+		require.NoError(t, memoryRepo.Create(ctx, &memoryUser))
+	}
+
+	for _, query := range []string{"1234", "0912", "EXAMPLE.com", "_", "missing"} {
+		want, err := memoryRepo.Search(ctx, query, 3)
+		require.NoError(t, err)
+		got, err := gormRepo.Search(ctx, query, 3)
+		require.NoError(t, err)
+		require.Len(t, got, len(want), query)
+		for i := range want {
+			assert.Equal(t, want[i].User.ID, got[i].User.ID, query)
+			assert.InDelta(t, want[i].Rank, got[i].Rank, 1e-9, query)
+		}
+	}
+}
+
+func TestPrefixQuery(t *testing.T) {
+	assert.Equal(t, `'ali':* & '0912':*`, prefixQuery(" ali  0912 "))
+	assert.Equal(t, `'o''brien':* & 'a\\b':*`, prefixQuery(`o'brien a\b`))
+	assert.Empty(t, prefixQuery(""))
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"my-project/internal/database"
+	"my-project/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GormWebhookRepository stores webhook subscriptions and deliveries with
+// GORM.
+type GormWebhookRepository struct {
+	db *gorm.DB
+}
+
+var _ WebhookRepository = (*GormWebhookRepository)(nil)
+
+// NewGormWebhookRepository returns a WebhookRepository backed by db.
+func NewGormWebhookRepository(db *gorm.DB) *GormWebhookRepository {
+	return &GormWebhookRepository{db: db}
+}
+
+// conn returns the transaction carried by ctx, or the repository's database.
+func (r *GormWebhookRepository) conn(ctx context.Context) *gorm.DB {
+	return database.Conn(ctx, r.db)
+}
+
+func (r *GormWebhookRepository) Create(ctx context.Context, subscription *models.WebhookSubscription) error {
+	// Create with Select so an explicit active=false isn't replaced by the column default.
+	return r.conn(ctx).Select("*").Create(subscription).Error
+}
+
+func (r *GormWebhookRepository) List(ctx context.Context) ([]models.WebhookSubscription, error) {
+	var subscriptions []models.WebhookSubscription
+	err := r.conn(ctx).Order("id").Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+func (r *GormWebhookRepository) Get(ctx context.Context, id uint) (models.WebhookSubscription, error) {
+	var subscription models.WebhookSubscription
+	if err := r.conn(ctx).First(&subscription, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.WebhookSubscription{}, ErrWebhookNotFound
+		}
+		return models.WebhookSubscription{}, err
+	}
+	return subscription, nil
+}
+
+func (r *GormWebhookRepository) Update(ctx context.Context, subscription *models.WebhookSubscription) error {
+	return r.conn(ctx).Save(subscription).Error
+}
+
+func (r *GormWebhookRepository) Delete(ctx context.Context, subscription *models.WebhookSubscription) error {
+	return r.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.WebhookDelivery{}).
+			Where("subscription_id = ? AND status = ?", subscription.ID, models.DeliveryPending).
+			Updates(map[string]interface{}{"status": models.DeliveryDead, "last_error": "subscription deleted"}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(subscription).Error
+	})
+}
+
+func (r *GormWebhookRepository) ListDeliveries(ctx context.Context, subscriptionID uint, filter DeliveryFilter) ([]models.WebhookDelivery, error) {
+	query := r.conn(ctx).Where("subscription_id = ?", subscriptionID)
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.BeforeID != 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	var deliveries []models.WebhookDelivery
+	err := query.Order("id DESC").Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (r *GormWebhookRepository) GetDelivery(ctx context.Context, subscriptionID, id uint, attempts bool) (models.WebhookDelivery, error) {
+	query := r.conn(ctx)
+	if attempts {
+		query = query.Preload("AttemptLog", func(db *gorm.DB) *gorm.DB { return db.Order("id") })
+	}
+	var delivery models.WebhookDelivery
+	if err := query.First(&delivery, "id = ? AND subscription_id = ?", id, subscriptionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.WebhookDelivery{}, ErrDeliveryNotFound
+		}
+		return models.WebhookDelivery{}, err
+	}
+	return delivery, nil
+}
+
+func (r *GormWebhookRepository) RetryDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	return r.conn(ctx).Model(delivery).Updates(map[string]interface{}{
+		"status":          models.DeliveryPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	}).Error
+}
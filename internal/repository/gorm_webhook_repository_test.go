@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+	"my-project/internal/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestWebhookDeliveries(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.WebhookSubscription{}, &models.WebhookDelivery{}, &models.WebhookDeliveryAttempt{}))
+	r := NewGormWebhookRepository(db)
+	ctx := context.Background()
+
+	subscription := models.WebhookSubscription{URL: "https://example.com/hook", Secret: "secret", EventTypes: models.StringList{"*"}}
+	require.NoError(t, r.Create(ctx, &subscription))
+	for _, status := range []string{models.DeliverySucceeded, models.DeliveryPending, models.DeliveryDead, models.DeliveryPending} {
+		require.NoError(t, db.Create(&models.WebhookDelivery{SubscriptionID: subscription.ID, EventType: "user.created", Status: status}).Error)
+	}
+
+	deliveries, err := r.ListDeliveries(ctx, subscription.ID, DeliveryFilter{Status: models.DeliveryPending})
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, uint(4), deliveries[0].ID)
+	deliveries, err = r.ListDeliveries(ctx, subscription.ID, DeliveryFilter{BeforeID: 4, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, []uint{3, 2}, []uint{deliveries[0].ID, deliveries[1].ID})
+
+	_, err = r.GetDelivery(ctx, subscription.ID+1, 1, false)
+	assert.ErrorIs(t, err, ErrDeliveryNotFound)
+
+	// Deleting the subscription dead-letters its pending deliveries, and
+	// keeps the others as they were.
+	require.NoError(t, r.Delete(ctx, &subscription))
+	_, err = r.Get(ctx, subscription.ID)
+	assert.ErrorIs(t, err, ErrWebhookNotFound)
+	deliveries, err = r.ListDeliveries(ctx, subscription.ID, DeliveryFilter{})
+	require.NoError(t, err)
+	var statuses []string
+	for _, delivery := range deliveries {
+		statuses = append(statuses, delivery.Status)
+	}
+	assert.Equal(t, []string{models.DeliveryDead, models.DeliveryDead, models.DeliveryDead, models.DeliverySucceeded}, statuses)
+}
@@ -0,0 +1,280 @@
+package repository
+
+import (
+	"context"
+	"maps"
+	"my-project/internal/models"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MemoryUserRepository keeps users in memory. It is meant for tests:
+// transactions roll back on error but are not isolated from each other.
+type MemoryUserRepository struct {
+	mu     sync.Mutex
+	users  map[uint]models.User
+	nextID uint
+}
+
+var _ UserRepository = (*MemoryUserRepository)(nil)
+
+// NewMemoryUserRepository returns an empty in-memory UserRepository.
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: map[uint]models.User{}, nextID: 1}
+}
+
+type memoryTxKey struct{}
+
+// Transaction runs fn, restoring the previous contents of the repository
+// if it fails. Nested transactions are part of the outermost one.
+func (r *MemoryUserRepository) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(memoryTxKey{}) != nil {
+		return fn(ctx)
+	}
+
+	r.mu.Lock()
+	snapshot, nextID := maps.Clone(r.users), r.nextID
+	r.mu.Unlock()
+
+	if err := fn(context.WithValue(ctx, memoryTxKey{}, true)); err != nil {
+		r.mu.Lock()
+		r.users, r.nextID = snapshot, nextID
+		r.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (r *MemoryUserRepository) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.create(user)
+}
+
+func (r *MemoryUserRepository) CreateBatch(ctx context.Context, users []*models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, user := range users {
+		if err := r.create(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// create applies the column defaults and unique indexes of the users table.
+func (r *MemoryUserRepository) create(user *models.User) error {
+	if r.taken(user.PhoneNumber, user.Email, 0) {
+		return ErrDuplicate
+	}
+	now := time.Now()
+	user.ID = r.nextID
+	user.CreatedAt, user.UpdatedAt = now, now
+	if user.Role == "" {
+		user.Role = "user"
+	}
+	if user.Version == 0 {
+		user.Version = 1
+	}
+	r.nextID++
+	r.users[user.ID] = *user
+	return nil
+}
+
+// taken reports whether an active user other than except has phoneNumber or email.
+func (r *MemoryUserRepository) taken(phoneNumber, email string, except uint) bool {
+	for id, user := range r.users {
+		if id == except || user.DeletedAt.Valid {
+			continue
+		}
+		if user.PhoneNumber == phoneNumber || (email != "" && user.Email == email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MemoryUserRepository) Get(ctx context.Context, id uint) (models.User, error) {
+	return r.find(func(user models.User) bool { return user.ID == id && !user.DeletedAt.Valid })
+}
+
+func (r *MemoryUserRepository) GetDeleted(ctx context.Context, id uint) (models.User, error) {
+	return r.find(func(user models.User) bool { return user.ID == id && user.DeletedAt.Valid })
+}
+
+func (r *MemoryUserRepository) GetAny(ctx context.Context, id uint) (models.User, error) {
+	return r.find(func(user models.User) bool { return user.ID == id })
+}
+
+func (r *MemoryUserRepository) FindByPhoneNumber(ctx context.Context, phoneNumber string) (models.User, error) {
+	return r.find(func(user models.User) bool { return user.PhoneNumber == phoneNumber && !user.DeletedAt.Valid })
+}
+
+func (r *MemoryUserRepository) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	return r.find(func(user models.User) bool { return user.Email == email && !user.DeletedAt.Valid })
+}
+
+func (r *MemoryUserRepository) find(match func(models.User) bool) (models.User, error) {
+	users := r.matching(match)
+	if len(users) == 0 {
+		return models.User{}, ErrNotFound
+	}
+	return users[0], nil
+}
+
+// matching returns the users for which match returns true, ordered by ID.
+func (r *MemoryUserRepository) matching(match func(models.User) bool) []models.User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var users []models.User
+	for _, id := range slices.Sorted(maps.Keys(r.users)) {
+		if user := r.users[id]; match(user) {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+func (r *MemoryUserRepository) List(ctx context.Context, filter UserFilter) ([]models.User, error) {
+	return r.matching(func(user models.User) bool { return user.DeletedAt.Valid == filter.Deleted }), nil
+}
+
+func (r *MemoryUserRepository) Each(ctx context.Context, filter UserFilter, batchSize int, fn func([]models.User) error) error {
+	users, _ := r.List(ctx, filter)
+	for start := 0; start < len(users); start += batchSize {
+		if err := fn(users[start:min(start+batchSize, len(users))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *MemoryUserRepository) Search(ctx context.Context, query string, limit int) ([]SearchHit, error) {
+	users, _ := r.List(ctx, UserFilter{})
+	return rankHits(users, query, limit), nil
+}
+
+// rankHits ranks users against query with matchRank and keeps the best limit.
+func rankHits(users []models.User, query string, limit int) []SearchHit {
+	hits := make([]SearchHit, 0, len(users))
+	for _, user := range users {
+		rank := max(matchRank(user.PhoneNumber, query), matchRank(user.Email, query))
+		if rank > 0 {
+			hits = append(hits, SearchHit{User: user, Rank: rank})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Rank != hits[j].Rank {
+			return hits[i].Rank > hits[j].Rank
+		}
+		return hits[i].User.ID < hits[j].User.ID
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+func (r *MemoryUserRepository) TakenIdentities(ctx context.Context, phoneNumbers, emails []string) ([]string, []string, error) {
+	var takenPhones, takenEmails []string
+	for _, user := range r.matching(func(user models.User) bool { return !user.DeletedAt.Valid }) {
+		if slices.Contains(phoneNumbers, user.PhoneNumber) {
+			takenPhones = append(takenPhones, user.PhoneNumber)
+		}
+		if slices.Contains(emails, user.Email) {
+			takenEmails = append(takenEmails, user.Email)
+		}
+	}
+	return takenPhones, takenEmails, nil
+}
+
+func (r *MemoryUserRepository) Update(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.users[user.ID]
+	if !ok || stored.DeletedAt.Valid || stored.Version != user.Version {
+		return ErrVersionConflict
+	}
+	if r.taken(user.PhoneNumber, user.Email, user.ID) {
+		return ErrDuplicate
+	}
+	user.Version++
+	user.UpdatedAt = time.Now()
+	user.CreatedAt, user.DeletedAt = stored.CreatedAt, stored.DeletedAt
+	user.VerificationCode = stored.VerificationCode
+	user.VerificationCodeExpiresAt = stored.VerificationCodeExpiresAt
+	user.EmailVerificationCode = stored.EmailVerificationCode
+	user.EmailVerificationCodeExpiresAt = stored.EmailVerificationCodeExpiresAt
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *MemoryUserRepository) SaveVerificationCodes(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.users[user.ID]
+	if !ok || stored.DeletedAt.Valid {
+		return nil
+	}
+	stored.VerificationCode = user.VerificationCode
+	stored.VerificationCodeExpiresAt = user.VerificationCodeExpiresAt
+	stored.EmailVerificationCode = user.EmailVerificationCode
+	stored.EmailVerificationCodeExpiresAt = user.EmailVerificationCodeExpiresAt
+	r.users[user.ID] = stored
+	return nil
+}
+
+func (r *MemoryUserRepository) Delete(ctx context.Context, user *models.User, purge bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.users[user.ID]
+	if !ok || stored.Version != user.Version || (stored.DeletedAt.Valid && !purge) {
+		return ErrVersionConflict
+	}
+	if purge {
+		delete(r.users, user.ID)
+		return nil
+	}
+	user.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	stored.DeletedAt = user.DeletedAt
+	r.users[user.ID] = stored
+	return nil
+}
+
+func (r *MemoryUserRepository) Restore(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if stored.Version != user.Version {
+		return ErrVersionConflict
+	}
+	if r.taken(stored.PhoneNumber, stored.Email, stored.ID) {
+		return ErrDuplicate
+	}
+	stored.DeletedAt = gorm.DeletedAt{}
+	stored.Version++
+	stored.UpdatedAt = time.Now()
+	r.users[user.ID] = stored
+	user.DeletedAt, user.Version, user.UpdatedAt = stored.DeletedAt, stored.Version, stored.UpdatedAt
+	return nil
+}
+
+func (r *MemoryUserRepository) PurgeDeletedBefore(ctx context.Context, cutoff time.Time) ([]models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var purged []models.User
+	for id, user := range r.users {
+		if user.DeletedAt.Valid && user.DeletedAt.Time.Before(cutoff) {
+			delete(r.users, id)
+			purged = append(purged, user)
+		}
+	}
+	return purged, nil
+}
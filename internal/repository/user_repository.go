@@ -0,0 +1,106 @@
+// Package repository stores users and webhook subscriptions.
+// UserRepository hides the database from the service layer, so the same
+// rules run against Postgres, SQLite or an in-memory store in tests.
+package repository
+
+import (
+	"context"
+	"errors"
+	"my-project/internal/models"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when no user matches.
+	ErrNotFound = errors.New("user not found")
+	// ErrVersionConflict is returned when a user changed between being read and written.
+	ErrVersionConflict = errors.New("user was modified concurrently")
+	// ErrDuplicate is returned when an active user already has the phone
+	// number or email.
+	ErrDuplicate = errors.New("phone number or email already taken")
+)
+
+// UserFilter selects the users to list or export.
+type UserFilter struct {
+	// Deleted selects soft-deleted users instead of active ones.
+	Deleted bool
+}
+
+// SearchHit is a user matching a search, with how well it matched.
+type SearchHit struct {
+	User models.User
+	Rank float64
+}
+
+// UserRepository reads and writes users. Every method takes a context;
+// calls made with the context passed to a Transaction callback take part
+// in that transaction.
+type UserRepository interface {
+	// Transaction runs fn in a transaction, which commits if fn returns nil.
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
+
+	Create(ctx context.Context, user *models.User) error
+	CreateBatch(ctx context.Context, users []*models.User) error
+
+	// Get returns the active user with id.
+	Get(ctx context.Context, id uint) (models.User, error)
+	// GetDeleted returns the soft-deleted user with id.
+	GetDeleted(ctx context.Context, id uint) (models.User, error)
+	// GetAny returns the user with id, whether it is deleted or not.
+	GetAny(ctx context.Context, id uint) (models.User, error)
+	FindByPhoneNumber(ctx context.Context, phoneNumber string) (models.User, error)
+	FindByEmail(ctx context.Context, email string) (models.User, error)
+
+	List(ctx context.Context, filter UserFilter) ([]models.User, error)
+	// Each calls fn with the users matching filter in batches of up to
+	// batchSize, ordered by ID, stopping at the first error.
+	Each(ctx context.Context, filter UserFilter, batchSize int, fn func([]models.User) error) error
+	// Search finds active users whose phone number or email contains query,
+	// best matches first.
+	Search(ctx context.Context, query string, limit int) ([]SearchHit, error)
+	// TakenIdentities returns which of the phone numbers and emails belong
+	// to active users.
+	TakenIdentities(ctx context.Context, phoneNumbers, emails []string) ([]string, []string, error)
+
+	// Update saves user if it is still at the version it was read at, and
+	// bumps its version. It returns ErrVersionConflict otherwise. The
+	// verification codes are left as they are; SaveVerificationCodes
+	// saves those.
+	Update(ctx context.Context, user *models.User) error
+	// SaveVerificationCodes saves the verification codes of user and when
+	// they expire, and nothing else, so it doesn't undo a concurrent
+	// Update. It neither checks nor bumps the version of user.
+	SaveVerificationCodes(ctx context.Context, user *models.User) error
+	// Delete soft-deletes user, or erases it if purge is set, if it is
+	// still at the version it was read at. It returns ErrVersionConflict
+	// otherwise.
+	Delete(ctx context.Context, user *models.User, purge bool) error
+	// Restore undoes the soft deletion of user and bumps its version. It
+	// returns ErrVersionConflict if user changed since it was read, and
+	// ErrDuplicate if its phone number or email has been taken since.
+	Restore(ctx context.Context, user *models.User) error
+	// PurgeDeletedBefore erases users soft-deleted before cutoff, and
+	// returns them.
+	PurgeDeletedBefore(ctx context.Context, cutoff time.Time) ([]models.User, error)
+}
+
+// matchRank scores how well query matches value: exact matches beat
+// prefixes, which beat matches elsewhere in the string. Longer matches
+// relative to the value score higher within each group.
+func matchRank(value, query string) float64 {
+	v, q := strings.ToLower(value), strings.ToLower(query)
+	idx := strings.Index(v, q)
+	if idx < 0 || v == "" {
+		return 0
+	}
+	coverage := float64(len(q)) / float64(len(v))
+	switch {
+	case v == q:
+		return 1
+	case idx == 0:
+		return 0.5 + 0.5*coverage
+	default:
+		return 0.25 + 0.5*coverage
+	}
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"my-project/internal/models"
+)
+
+var (
+	// ErrWebhookNotFound is returned when no webhook subscription matches.
+	ErrWebhookNotFound = errors.New("webhook subscription not found")
+	// ErrDeliveryNotFound is returned when no webhook delivery matches.
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+// DeliveryFilter selects the deliveries of a subscription to list.
+type DeliveryFilter struct {
+	// Status selects deliveries with the status, or all of them if empty.
+	Status string
+	// BeforeID selects deliveries with a lower ID, when it is set.
+	BeforeID uint
+	// Limit is the maximum number of deliveries, or 0 for no limit.
+	Limit int
+}
+
+// WebhookRepository reads and writes webhook subscriptions and their
+// deliveries. Deliveries are created by the webhook outbox and sent by its
+// dispatcher; the repository only serves the admin endpoints.
+type WebhookRepository interface {
+	Create(ctx context.Context, subscription *models.WebhookSubscription) error
+	// List returns every subscription, ordered by ID.
+	List(ctx context.Context) ([]models.WebhookSubscription, error)
+	// Get returns the subscription with id.
+	Get(ctx context.Context, id uint) (models.WebhookSubscription, error)
+	Update(ctx context.Context, subscription *models.WebhookSubscription) error
+	// Delete deletes subscription and dead-letters its pending deliveries.
+	// The deliveries are kept as a log.
+	Delete(ctx context.Context, subscription *models.WebhookSubscription) error
+
+	// ListDeliveries returns the deliveries of the subscription with
+	// subscriptionID matching filter, newest first.
+	ListDeliveries(ctx context.Context, subscriptionID uint, filter DeliveryFilter) ([]models.WebhookDelivery, error)
+	// GetDelivery returns the delivery with id of the subscription with
+	// subscriptionID, along with its attempt log if attempts is set.
+	GetDelivery(ctx context.Context, subscriptionID, id uint, attempts bool) (models.WebhookDelivery, error)
+	// RetryDelivery makes delivery pending again, due now and with a fresh
+	// set of attempts.
+	RetryDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+}
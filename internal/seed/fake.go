@@ -0,0 +1,54 @@
+package seed
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+)
+
+var (
+	firstNames = []string{
+		"Ali", "Mohammad", "Hossein", "Reza", "Mehdi", "Amir", "Hamid", "Saeed", "Majid", "Behnam",
+		"Kaveh", "Arash", "Dariush", "Parsa", "Sina", "Zahra", "Fatemeh", "Maryam", "Sara", "Narges",
+		"Neda", "Leila", "Shirin", "Mahsa", "Parisa", "Elham", "Samira", "Roya", "Nazanin", "Yasaman",
+	}
+	lastNames = []string{
+		"Ahmadi", "Mohammadi", "Hosseini", "Rezaei", "Karimi", "Moradi", "Jafari", "Rahimi", "Hashemi", "Sadeghi",
+		"Ghasemi", "Kazemi", "Mousavi", "Rostami", "Azizi", "Ebrahimi", "Heidari", "Abbasi", "Tehrani", "Shirazi",
+		"Esfahani", "Najafi", "Kermani", "Akbari", "Salehi", "Nikpour", "Farahani", "Bagheri", "Soltani", "Zand",
+	}
+	emailDomains = []string{"gmail.example.com", "yahoo.example.com", "chmail.example.ir", "outlook.example.com"}
+
+	// mobilePrefixes are the first four digits of numbers handed out by
+	// the Iranian mobile operators.
+	mobilePrefixes = []string{
+		"0910", "0911", "0912", "0913", "0914", "0915", "0916", "0917", "0918", "0919", "0990", "0991",
+		"0901", "0902", "0903", "0905", "0930", "0933", "0935", "0936", "0937", "0938", "0939",
+		"0920", "0921", "0922",
+	}
+)
+
+// Fake returns n users with Persian names and valid mobile numbers, all
+// with password. The same seed always gives the same users, so seeding
+// them again changes nothing. Emails use example domains so nothing is
+// ever sent to a real mailbox.
+func Fake(n int, seed uint64, password string) []UserFixture {
+	r := rand.New(rand.NewPCG(seed, seed))
+	phones := make(map[string]bool, n)
+	users := make([]UserFixture, 0, n)
+
+	for len(users) < n {
+		phone := fmt.Sprintf("%s%07d", mobilePrefixes[r.IntN(len(mobilePrefixes))], r.IntN(10_000_000))
+		if phones[phone] {
+			continue
+		}
+		phones[phone] = true
+
+		first := firstNames[r.IntN(len(firstNames))]
+		last := lastNames[r.IntN(len(lastNames))]
+		// The phone number keeps emails unique among users sharing a name.
+		email := fmt.Sprintf("%s.%s.%s@%s", strings.ToLower(first), strings.ToLower(last), phone[4:], emailDomains[r.IntN(len(emailDomains))])
+		users = append(users, UserFixture{PhoneNumber: phone, Email: email, Password: password, Role: "user"})
+	}
+	return users
+}
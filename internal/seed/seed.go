@@ -0,0 +1,203 @@
+// Package seed loads users from YAML or JSON fixtures, for development,
+// tests and load testing. Seeding is idempotent: users are matched by
+// phone number, and existing ones are brought in line with their fixture
+// instead of being created again.
+package seed
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"my-project/internal/auth"
+	"my-project/internal/i18n"
+	"my-project/internal/models"
+	"my-project/internal/services"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed fixtures/*.yaml
+var builtin embed.FS
+
+// Fixtures is the content of a fixture file.
+type Fixtures struct {
+	Users []UserFixture `json:"users" yaml:"users"`
+}
+
+// UserFixture describes a user. Either Password or PasswordHash must be
+// set; a bcrypt hash saves hashing the password on every seed.
+type UserFixture struct {
+	PhoneNumber  string `json:"phone_number" yaml:"phone_number"`
+	Email        string `json:"email" yaml:"email"`
+	Password     string `json:"password,omitempty" yaml:"password,omitempty"`
+	PasswordHash string `json:"password_hash,omitempty" yaml:"password_hash,omitempty"`
+	Role         string `json:"role,omitempty" yaml:"role,omitempty"`
+	Disabled     bool   `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+}
+
+// Result counts what seeding did to each user.
+type Result struct {
+	Created   int
+	Updated   int
+	Unchanged int
+}
+
+// Builtin returns the fixtures named name that are compiled into the
+// binary, such as "dev".
+func Builtin(name string) (Fixtures, error) {
+	data, err := builtin.ReadFile("fixtures/" + name + ".yaml")
+	if err != nil {
+		return Fixtures{}, fmt.Errorf("no builtin fixtures named %q", name)
+	}
+	return Parse(data, ".yaml")
+}
+
+// Load reads a fixture file, which is YAML or JSON depending on its extension.
+func Load(path string) (Fixtures, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Fixtures{}, err
+	}
+	fixtures, err := Parse(data, filepath.Ext(path))
+	if err != nil {
+		return Fixtures{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return fixtures, nil
+}
+
+// Parse decodes fixtures in the format given by the file extension ext.
+// Unknown fields are rejected, so typos don't go unnoticed.
+func Parse(data []byte, ext string) (Fixtures, error) {
+	var fixtures Fixtures
+	switch strings.ToLower(ext) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&fixtures); err != nil {
+			return Fixtures{}, err
+		}
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&fixtures); err != nil {
+			return Fixtures{}, err
+		}
+	default:
+		return Fixtures{}, fmt.Errorf("unsupported fixture format %q", ext)
+	}
+	return fixtures, nil
+}
+
+// Apply creates the users in fixtures that don't exist yet, and updates
+// the email, role and disabled state of those that do. Passwords of
+// existing users are left alone. New users are validated and created
+// together like an import, so either all of them are created or none.
+func Apply(ctx context.Context, users *services.UserService, fixtures Fixtures) (Result, error) {
+	var result Result
+	var created []*models.User
+	hashes := map[string]string{}
+
+	for _, fixture := range fixtures.Users {
+		existing, err := users.FindByPhoneNumber(ctx, fixture.PhoneNumber)
+		switch {
+		case err == nil:
+			changed, err := update(ctx, users, existing, fixture)
+			if err != nil {
+				return result, fmt.Errorf("user %s: %w", fixture.PhoneNumber, err)
+			}
+			if changed {
+				result.Updated++
+			} else {
+				result.Unchanged++
+			}
+		case errors.Is(err, services.ErrUserNotFound):
+			user, err := newUser(ctx, fixture, hashes)
+			if err != nil {
+				return result, fmt.Errorf("user %s: %w", fixture.PhoneNumber, err)
+			}
+			created = append(created, user)
+		default:
+			return result, err
+		}
+	}
+	if len(created) == 0 {
+		return result, nil
+	}
+
+	importErrors, err := users.ValidateImport(ctx, created)
+	if err != nil {
+		return result, err
+	}
+	if len(importErrors) > 0 {
+		e := importErrors[0]
+		return result, fmt.Errorf("user %s: %s: %s", created[e.Row-1].PhoneNumber, e.Field, i18n.Translate(i18n.English, e.Message, e.Args...))
+	}
+	if err := users.ImportHashed(ctx, created); err != nil {
+		return result, err
+	}
+	result.Created = len(created)
+	return result, nil
+}
+
+// newUser builds the user described by fixture. hashes caches the hash of
+// each plain-text password, since fake users tend to share one.
+func newUser(ctx context.Context, fixture UserFixture, hashes map[string]string) (*models.User, error) {
+	user := &models.User{
+		PhoneNumber: fixture.PhoneNumber,
+		Email:       fixture.Email,
+		Password:    fixture.PasswordHash,
+		Role:        fixture.Role,
+	}
+	if user.Password == "" {
+		if fixture.Password == "" {
+			return nil, errors.New("password or password_hash is required")
+		}
+		hash, ok := hashes[fixture.Password]
+		if !ok {
+			var err error
+			if hash, err = auth.HashPassword(ctx, fixture.Password); err != nil {
+				return nil, err
+			}
+			hashes[fixture.Password] = hash
+		}
+		user.Password = hash
+	}
+	if fixture.Disabled {
+		now := time.Now()
+		user.DisabledAt = &now
+	}
+	return user, nil
+}
+
+// update brings user in line with fixture and reports whether anything
+// had to change.
+func update(ctx context.Context, users *services.UserService, user models.User, fixture UserFixture) (bool, error) {
+	var changes services.UserChanges
+	if fixture.Email != user.Email {
+		changes.Email = &fixture.Email
+	}
+	if fixture.Role != "" && fixture.Role != user.Role {
+		changes.Role = &fixture.Role
+	}
+	changed := changes != services.UserChanges{}
+	if changed {
+		if err := users.Update(ctx, &user, changes); err != nil {
+			return false, err
+		}
+	}
+
+	if fixture.Disabled != (user.DisabledAt != nil) {
+		if err := users.SetDisabled(ctx, &user, fixture.Disabled); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
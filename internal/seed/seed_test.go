@@ -0,0 +1,66 @@
+package seed
+
+import (
+	"context"
+	"my-project/internal/events"
+	"my-project/internal/repository"
+	"my-project/internal/services"
+	"my-project/pkg/validators"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyIsIdempotent(t *testing.T) {
+	s := services.NewUserService(repository.NewMemoryUserRepository(), events.NewBus())
+	ctx := context.Background()
+	fixtures, err := Builtin("dev")
+	require.NoError(t, err)
+
+	result, err := Apply(ctx, s, fixtures)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Created: len(fixtures.Users)}, result)
+
+	disabled, err := s.FindByPhoneNumber(ctx, "09123333333")
+	require.NoError(t, err)
+	assert.NotNil(t, disabled.DisabledAt)
+
+	result, err = Apply(ctx, s, fixtures)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Unchanged: len(fixtures.Users)}, result)
+
+	// Changing a fixture updates the existing user.
+	fixtures.Users[1].Role = "moderator"
+	result, err = Apply(ctx, s, fixtures)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Updated)
+	user, err := s.FindByPhoneNumber(ctx, fixtures.Users[1].PhoneNumber)
+	require.NoError(t, err)
+	assert.Equal(t, "moderator", user.Role)
+}
+
+func TestParse(t *testing.T) {
+	fixtures, err := Parse([]byte(`{"users": [{"phone_number": "09121111111", "email": "a@example.com", "password": "x"}]}`), ".json")
+	require.NoError(t, err)
+	assert.Len(t, fixtures.Users, 1)
+
+	_, err = Parse([]byte("users:\n  - phone: \"09121111111\"\n"), ".yaml")
+	assert.Error(t, err)
+	_, err = Parse(nil, ".csv")
+	assert.Error(t, err)
+}
+
+func TestFake(t *testing.T) {
+	users := Fake(200, 1, "password")
+	assert.Len(t, users, 200)
+	assert.Equal(t, users, Fake(200, 1, "password"))
+
+	phones := map[string]bool{}
+	for _, user := range users {
+		assert.True(t, validators.ValidatePersianPhoneNumber(user.PhoneNumber), user.PhoneNumber)
+		assert.True(t, validators.ValidateEmail(user.Email), user.Email)
+		assert.False(t, phones[user.PhoneNumber])
+		phones[user.PhoneNumber] = true
+	}
+}
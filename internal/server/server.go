@@ -0,0 +1,86 @@
+// Package server runs the HTTP server and shuts it down gracefully.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"my-project/config"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NewRouter returns a gin engine that takes the client IP from the
+// X-Forwarded-For header only when the request comes from one of the
+// http.trusted_proxies, since anyone else can make it up to get around
+// rate limits.
+func NewRouter(cfg *config.Config) (*gin.Engine, error) {
+	r := gin.New()
+	if err := r.SetTrustedProxies(cfg.TrustedProxies()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// New returns a server for handler with the address, timeouts and header
+// limit from cfg.
+func New(cfg *config.Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.HTTP.Addr,
+		Handler:           handler,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+		MaxHeaderBytes:    cfg.HTTP.MaxHeaderBytes,
+	}
+}
+
+// Run listens on srv.Addr and serves until ctx is cancelled, then drains
+// the server with Shutdown.
+func Run(ctx context.Context, srv *http.Server, drainTimeout time.Duration) error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	return Serve(ctx, srv, ln, drainTimeout)
+}
+
+// Serve serves srv on ln, over TLS if srv.TLSConfig is set, until ctx is
+// cancelled. It then stops accepting connections and gives in-flight
+// requests up to drainTimeout to finish, after which the remaining
+// connections are closed and an error returned.
+func Serve(ctx context.Context, srv *http.Server, ln net.Listener, drainTimeout time.Duration) error {
+	served := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			// The certificate comes from TLSConfig.GetCertificate.
+			served <- srv.ServeTLS(ln, "", "")
+		} else {
+			served <- srv.Serve(ln)
+		}
+	}()
+	slog.Info("Listening", "addr", ln.Addr().String())
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down, draining in-flight requests")
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		srv.Close()
+		return fmt.Errorf("drain requests: %w", err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
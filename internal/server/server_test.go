@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"io"
+	"my-project/config"
+	"my-project/internal/apierr"
+	"my-project/internal/ratelimit"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveSlow serves a handler that takes delay to answer, and returns the
+// server's URL and the result of Serve once ctx is cancelled.
+func serveSlow(t *testing.T, ctx context.Context, delay, drainTimeout time.Duration) (string, <-chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		io.WriteString(w, "done")
+	})}
+
+	result := make(chan error, 1)
+	go func() { result <- Serve(ctx, srv, ln, drainTimeout) }()
+	return "http://" + ln.Addr().String(), result
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	url, result := serveSlow(t, ctx, 200*time.Millisecond, time.Second)
+
+	response := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			response <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		response <- string(body)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	assert.Equal(t, "done", <-response)
+	assert.NoError(t, <-result)
+
+	// New connections are refused once the server is draining.
+	_, err := http.Get(url)
+	assert.Error(t, err)
+}
+
+func TestServeGivesUpAfterDrainTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	url, result := serveSlow(t, ctx, time.Second, 50*time.Millisecond)
+
+	go http.Get(url)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-result, context.DeadlineExceeded)
+}
+
+func TestRouterTrustedProxies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	login := func(r *gin.Engine, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.RemoteAddr = "10.0.0.2:4711"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	router := func(trustedProxies string) *gin.Engine {
+		cfg := config.Default()
+		cfg.HTTP.TrustedProxies = trustedProxies
+		r, err := NewRouter(cfg)
+		require.NoError(t, err)
+		r.Use(apierr.Middleware())
+		r.POST("/login", ratelimit.New(1, time.Minute).Middleware(), func(c *gin.Context) {
+			c.Status(http.StatusNoContent)
+		})
+		return r
+	}
+
+	// Clients can't get a bucket of their own by making up the header.
+	r := router("")
+	assert.Equal(t, http.StatusNoContent, login(r, "203.0.113.1"))
+	assert.Equal(t, http.StatusTooManyRequests, login(r, "203.0.113.2"))
+
+	// Behind a trusted proxy, the client it forwards for is limited.
+	r = router("10.0.0.0/8")
+	assert.Equal(t, http.StatusNoContent, login(r, "203.0.113.1"))
+	assert.Equal(t, http.StatusNoContent, login(r, "203.0.113.2"))
+	assert.Equal(t, http.StatusTooManyRequests, login(r, "203.0.113.1"))
+
+	_, err := NewRouter(&config.Config{HTTP: config.HTTPConfig{TrustedProxies: "proxy.internal"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"my-project/config"
+	"my-project/internal/auth"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA issues certificates for the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key for commonName.
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestCertReloader(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Minute)
+
+	certPEM, keyPEM := ca.issue(t, "old.example.com", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, certPEM, start)
+	writeFile(t, keyFile, keyPEM, start)
+	reloader, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	commonName := func() string {
+		cert, _ := reloader.GetCertificate(nil)
+		return cert.Leaf.Subject.CommonName
+	}
+	assert.Equal(t, "old.example.com", commonName())
+
+	reloaded, err := reloader.Reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded)
+
+	// A half-written pair is ignored until both files are in place.
+	certPEM, keyPEM = ca.issue(t, "new.example.com", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, certPEM, start.Add(time.Second))
+	_, err = reloader.Reload()
+	assert.Error(t, err)
+	assert.Equal(t, "old.example.com", commonName())
+
+	writeFile(t, keyFile, keyPEM, start.Add(2*time.Second))
+	reloaded, err = reloader.Reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, "new.example.com", commonName())
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	cfg := &config.Config{TLS: config.TLSConfig{MinVersion: "1.3", CipherSuites: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}}
+	tlsConfig, err := NewTLSConfig(cfg, &CertReloader{})
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, tlsConfig.CipherSuites)
+
+	_, err = NewTLSConfig(&config.Config{TLS: config.TLSConfig{MinVersion: "1.0"}}, &CertReloader{})
+	assert.Error(t, err)
+	_, err = NewTLSConfig(&config.Config{TLS: config.TLSConfig{MinVersion: "1.2", CipherSuites: "TLS_RSA_WITH_RC4_128_SHA"}}, &CertReloader{})
+	assert.Error(t, err)
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certPEM, keyPEM := ca.issue(t, "localhost", x509.ExtKeyUsageServerAuth)
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	writeFile(t, certFile, certPEM, time.Now())
+	writeFile(t, keyFile, keyPEM, time.Now())
+	writeFile(t, caFile, ca.pem, time.Now())
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	cfg := &config.Config{TLS: config.TLSConfig{MinVersion: "1.2", ClientCAFile: caFile}}
+	tlsConfig, err := NewTLSConfig(cfg, reloader)
+	require.NoError(t, err)
+
+	principals, err := auth.ParsePrincipals("billing=service:billing:admin")
+	require.NoError(t, err)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/v1/whoami", auth.ClientCertMiddleware(principals, false), auth.AuthMiddleware(nil), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("phone_number")+" "+c.GetString("role"))
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Serve(ctx, &http.Server{Handler: r, TLSConfig: tlsConfig}, ln, time.Second)
+
+	get := func(commonName string) (int, string) {
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		clientConfig := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+		if commonName != "" {
+			certPEM, keyPEM := ca.issue(t, commonName, x509.ExtKeyUsageClientAuth)
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			require.NoError(t, err)
+			clientConfig.Certificates = []tls.Certificate{cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+		resp, err := client.Get("https://" + ln.Addr().String() + "/api/v1/whoami")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := get("billing")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "service:billing admin", body)
+	status, _ = get("unknown")
+	assert.Equal(t, http.StatusForbidden, status)
+	// Without a certificate the request falls back to token auth.
+	status, _ = get("")
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"my-project/config"
+	"my-project/internal/audit"
+	"my-project/internal/database"
+	"my-project/internal/events"
+	"my-project/internal/migrations"
+	"my-project/internal/models"
+	"my-project/internal/repository"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// setupDatabase returns a migrated SQLite database, or the database
+// configured in the environment when DB_DRIVER is set, as in CI. The
+// schema is rolled back after the test.
+func setupDatabase(t *testing.T) *gorm.DB {
+	cfg := config.Default()
+	cfg.DB.Driver = config.SQLite
+	cfg.DB.Name = filepath.Join(t.TempDir(), "test.db")
+	if os.Getenv("DB_DRIVER") != "" {
+		var err error
+		cfg, err = config.Load(nil)
+		require.NoError(t, err)
+	}
+	db, err := database.Open(cfg)
+	require.NoError(t, err)
+	m, err := migrations.New(db)
+	require.NoError(t, err)
+	_, err = m.Up(context.Background())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		statuses, _ := m.Status(context.Background())
+		m.Down(context.Background(), len(statuses))
+		database.Close(db)
+	})
+	return db
+}
+
+// TestConcurrentImportsAreAudited imports users while others get roles.
+// Each import records an event per user in its transaction, which must
+// not deadlock with the events recorded by the other requests.
+func TestConcurrentImportsAreAudited(t *testing.T) {
+	db := setupDatabase(t)
+	bus := events.NewBus()
+	audit.Subscribe(bus, db)
+	s := NewUserService(repository.NewGormUserRepository(db), bus)
+	ctx := context.Background()
+
+	const workers, batch = 4, 10
+	var existing []*models.User
+	for i := range workers {
+		existing = append(existing, &models.User{
+			PhoneNumber: fmt.Sprintf("09350000%03d", i),
+			Email:       fmt.Sprintf("existing-%d@example.com", i),
+			Password:    "hashed",
+		})
+	}
+	require.NoError(t, s.ImportHashed(ctx, existing))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*workers)
+	for w := range workers {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			var users []*models.User
+			for i := range batch {
+				users = append(users, &models.User{
+					PhoneNumber: fmt.Sprintf("0912%03d%04d", w, i),
+					Email:       fmt.Sprintf("import-%d-%d@example.com", w, i),
+					Password:    "hashed",
+				})
+			}
+			errs <- s.ImportHashed(ctx, users)
+		}()
+		go func() {
+			defer wg.Done()
+			user := existing[w]
+			for i := range batch {
+				if err := s.AssignRole(ctx, user, fmt.Sprintf("role-%d", i)); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		t.Fatal("imports and signups deadlocked")
+	}
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	var count int64
+	require.NoError(t, db.Model(&models.AuditEvent{}).Count(&count).Error)
+	assert.Equal(t, int64(workers+2*workers*batch), count)
+	result, err := audit.Verify(db)
+	require.NoError(t, err)
+	assert.True(t, result.Valid)
+}
+
+// TestRetentionPurgesAreAudited purges a user with the retention job,
+// which must leave the same audit trail as an admin purging it.
+func TestRetentionPurgesAreAudited(t *testing.T) {
+	db := setupDatabase(t)
+	bus := events.NewBus()
+	audit.Subscribe(bus, db)
+	s := NewUserService(repository.NewGormUserRepository(db), bus)
+
+	user := &models.User{PhoneNumber: "09121111111", Email: "user@example.com", Password: "hashed"}
+	require.NoError(t, s.ImportHashed(context.Background(), []*models.User{user}))
+	require.NoError(t, s.Delete(context.Background(), user, false))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.RunRetentionJob(ctx, 0, time.Hour)
+		close(done)
+	}()
+	var event models.AuditEvent
+	require.Eventually(t, func() bool {
+		return db.Where("action = ?", audit.ActionUserPurge).First(&event).Error == nil
+	}, 10*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Equal(t, RetentionActor, event.Actor)
+	assert.Equal(t, "system", event.ActorRole)
+	assert.Equal(t, fmt.Sprint(user.ID), event.TargetID)
+	_, err := s.GetAny(context.Background(), user.ID)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"my-project/internal/events"
+	"time"
+)
+
+// RetentionActor is the actor recorded for the users purged by
+// RunRetentionJob.
+const RetentionActor = "system:retention"
+
+// RunRetentionJob purges users that have been soft-deleted for longer than
+// retention, checking every interval until ctx is cancelled.
+func (s *UserService) RunRetentionJob(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ctx = events.WithMetadata(ctx, events.Metadata{Actor: RetentionActor, ActorRole: "system"})
+	for {
+		purged, err := s.PurgeDeleted(ctx, time.Now().Add(-retention))
+		if err != nil {
+			slog.Error("Failed to purge deleted users", "error", err)
+		} else if len(purged) > 0 {
+			slog.Info("Purged deleted users", "count", len(purged))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,596 @@
+// Package services holds the business rules for users. Each change
+// publishes a domain event, so side effects such as the audit log and
+// webhooks live in event subscribers instead of the handlers.
+package services
+
+import (
+	"context"
+	"errors"
+	"my-project/internal/apierr"
+	"my-project/internal/auth"
+	"my-project/internal/events"
+	"my-project/internal/i18n"
+	"my-project/internal/logging"
+	"my-project/internal/metrics"
+	"my-project/internal/models"
+	"my-project/internal/repository"
+	"my-project/pkg/utils"
+	"my-project/pkg/validators"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	verificationCodeLength = 6
+	verificationCodeTTL    = 5 * time.Minute
+)
+
+var (
+	// ErrUserNotFound is returned when no user matches.
+	ErrUserNotFound = repository.ErrNotFound
+	// ErrVersionConflict is returned when a user changed between being read and written.
+	ErrVersionConflict = repository.ErrVersionConflict
+	// ErrInvalidCredentials is returned for a wrong phone number or password.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrInvalidCode is returned for a wrong or expired verification code.
+	ErrInvalidCode = errors.New("invalid or expired verification code")
+	// ErrUserDisabled is returned when a disabled user tries to log in.
+	ErrUserDisabled = errors.New("user is disabled")
+	// ErrIdentityTaken is returned when another user has the same phone number or email.
+	ErrIdentityTaken = repository.ErrDuplicate
+)
+
+// ValidationError reports a field that breaks a rule. Code is one of the
+// apierr field codes, and Message the ID of the i18n message explaining
+// it, formatted with Args.
+type ValidationError struct {
+	Field   string
+	Code    string
+	Message string
+	Args    []any
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + ": " + i18n.Translate(i18n.English, e.Message, e.Args...)
+}
+
+// ValidationErrors reports every field of a change that breaks a rule, in
+// the order the fields are checked.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ImportError reports why a row of an import was rejected. Rows are
+// numbered from 1. Message is an i18n message ID, like in ValidationError.
+type ImportError struct {
+	Row     int
+	Field   string
+	Message string
+	Args    []any
+}
+
+// UserChanges are the fields to change in an update. Nil fields are left
+// as they are.
+type UserChanges struct {
+	PhoneNumber *string
+	Email       *string
+	Role        *string
+	// Locale is the preferred locale, or "" for none.
+	Locale *string
+	// Password is the new plain-text password.
+	Password *string
+}
+
+// UserService holds the business rules for users.
+type UserService struct {
+	users repository.UserRepository
+	bus   *events.Bus
+}
+
+// NewUserService returns a UserService storing users in users and
+// publishing to bus.
+func NewUserService(users repository.UserRepository, bus *events.Bus) *UserService {
+	return &UserService{users: users, bus: bus}
+}
+
+// transaction runs fn in a repository transaction. Synchronous subscribers
+// of events published in fn join the transaction; asynchronous ones only
+// run if it commits.
+func (s *UserService) transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	ctx, commit := events.Defer(ctx)
+	if err := s.users.Transaction(ctx, fn); err != nil {
+		return err
+	}
+	commit()
+	return nil
+}
+
+// ValidateNewUser checks the rules every new user must satisfy.
+func ValidateNewUser(user models.User) *ValidationError {
+	if !validators.ValidatePersianPhoneNumber(user.PhoneNumber) {
+		return &ValidationError{Field: "phone_number", Code: apierr.FieldInvalidFormat, Message: "validation.phone_number"}
+	}
+	if !validators.ValidateEmail(user.Email) {
+		return &ValidationError{Field: "email", Code: apierr.FieldInvalidFormat, Message: "validation.email"}
+	}
+	if user.Locale != "" && !i18n.IsSupported(user.Locale) {
+		return localeError()
+	}
+	return nil
+}
+
+func localeError() *ValidationError {
+	return &ValidationError{
+		Field:   "locale",
+		Code:    apierr.FieldInvalidValue,
+		Message: "validation.locale",
+		Args:    []any{strings.Join(i18n.Supported, ", ")},
+	}
+}
+
+// Get returns the active user with id.
+func (s *UserService) Get(ctx context.Context, id uint) (models.User, error) {
+	return s.users.Get(ctx, id)
+}
+
+// Active reports whether the user with id exists and isn't disabled.
+func (s *UserService) Active(ctx context.Context, id uint) (bool, error) {
+	user, err := s.users.Get(ctx, id)
+	if errors.Is(err, ErrUserNotFound) {
+		return false, nil
+	}
+	return err == nil && user.DisabledAt == nil, err
+}
+
+// GetDeleted returns the soft-deleted user with id.
+func (s *UserService) GetDeleted(ctx context.Context, id uint) (models.User, error) {
+	return s.users.GetDeleted(ctx, id)
+}
+
+// GetAny returns the user with id, whether it is deleted or not.
+func (s *UserService) GetAny(ctx context.Context, id uint) (models.User, error) {
+	return s.users.GetAny(ctx, id)
+}
+
+// FindByPhoneNumber returns the active user with phoneNumber.
+func (s *UserService) FindByPhoneNumber(ctx context.Context, phoneNumber string) (models.User, error) {
+	return s.users.FindByPhoneNumber(ctx, phoneNumber)
+}
+
+// List returns the users matching filter.
+func (s *UserService) List(ctx context.Context, filter repository.UserFilter) ([]models.User, error) {
+	return s.users.List(ctx, filter)
+}
+
+// Each calls fn with the users matching filter in batches, ordered by ID.
+func (s *UserService) Each(ctx context.Context, filter repository.UserFilter, batchSize int, fn func([]models.User) error) error {
+	return s.users.Each(ctx, filter, batchSize, fn)
+}
+
+// Search finds the users whose phone number or email contains query.
+func (s *UserService) Search(ctx context.Context, query string, limit int) ([]repository.SearchHit, error) {
+	return s.users.Search(ctx, query, limit)
+}
+
+// Create validates user, hashes its password and stores it.
+func (s *UserService) Create(ctx context.Context, user *models.User) error {
+	if err := ValidateNewUser(*user); err != nil {
+		return err
+	}
+
+	hashedPassword, err := auth.HashPassword(ctx, user.Password)
+	if err != nil {
+		return err
+	}
+	user.Password = hashedPassword
+
+	return s.transaction(ctx, func(ctx context.Context) error {
+		if err := s.users.Create(ctx, user); err != nil {
+			return err
+		}
+		return s.bus.Publish(ctx, events.UserCreated{User: *user})
+	})
+}
+
+// ValidateImport validates each user like Create does, and rejects phone
+// numbers and emails that are repeated in the import or already taken.
+// Users without a role get the default one.
+func (s *UserService) ValidateImport(ctx context.Context, users []*models.User) ([]ImportError, error) {
+	var importErrors []ImportError
+	phones := map[string]int{}
+	emails := map[string]int{}
+
+	for i, user := range users {
+		row := i + 1
+		if user.Role == "" {
+			user.Role = "user"
+		}
+
+		if err := ValidateNewUser(*user); err != nil {
+			importErrors = append(importErrors, ImportError{Row: row, Field: err.Field, Message: err.Message, Args: err.Args})
+			continue
+		}
+		if first, ok := phones[user.PhoneNumber]; ok {
+			importErrors = append(importErrors, ImportError{Row: row, Field: "phone_number", Message: "import.duplicate_row", Args: []any{first}})
+			continue
+		}
+		if first, ok := emails[user.Email]; ok {
+			importErrors = append(importErrors, ImportError{Row: row, Field: "email", Message: "import.duplicate_row", Args: []any{first}})
+			continue
+		}
+		phones[user.PhoneNumber] = row
+		emails[user.Email] = row
+	}
+
+	takenPhones, takenEmails, err := s.users.TakenIdentities(ctx, keys(phones), keys(emails))
+	if err != nil {
+		return nil, err
+	}
+	for _, phone := range takenPhones {
+		importErrors = append(importErrors, ImportError{Row: phones[phone], Field: "phone_number", Message: "import.identity_taken"})
+	}
+	for _, email := range takenEmails {
+		importErrors = append(importErrors, ImportError{Row: emails[email], Field: "email", Message: "import.identity_taken"})
+	}
+	sort.SliceStable(importErrors, func(i, j int) bool { return importErrors[i].Row < importErrors[j].Row })
+	return importErrors, nil
+}
+
+func keys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Import hashes the passwords of users, which must have passed
+// ValidateImport, and stores them all in one transaction. Hashing stops as
+// soon as ctx is done, such as when the request times out.
+func (s *UserService) Import(ctx context.Context, users []*models.User) error {
+	// bcrypt dominates the cost of an import, so hash in parallel.
+	g, hashCtx := errgroup.WithContext(ctx)
+	g.SetLimit(runtime.NumCPU())
+	for _, user := range users {
+		if hashCtx.Err() != nil {
+			break
+		}
+		g.Go(func() error {
+			if err := hashCtx.Err(); err != nil {
+				return err
+			}
+			hashedPassword, err := auth.HashPassword(hashCtx, user.Password)
+			if err != nil {
+				return err
+			}
+			user.Password = hashedPassword
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.ImportHashed(ctx, users)
+}
+
+// ImportHashed stores users, which must have passed ValidateImport and
+// whose passwords are already hashed, in one transaction.
+func (s *UserService) ImportHashed(ctx context.Context, users []*models.User) error {
+	return s.transaction(ctx, func(ctx context.Context) error {
+		if err := s.users.CreateBatch(ctx, users); err != nil {
+			return err
+		}
+		for _, user := range users {
+			if err := s.bus.Publish(ctx, events.UserCreated{User: *user, Imported: true}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ValidateChanges checks the rules for the fields set in changes.
+func ValidateChanges(changes UserChanges) ValidationErrors {
+	var errs ValidationErrors
+	if changes.PhoneNumber != nil && !validators.ValidatePersianPhoneNumber(*changes.PhoneNumber) {
+		errs = append(errs, &ValidationError{Field: "phone_number", Code: apierr.FieldInvalidFormat, Message: "validation.phone_number"})
+	}
+	if changes.Email != nil && !validators.ValidateEmail(*changes.Email) {
+		errs = append(errs, &ValidationError{Field: "email", Code: apierr.FieldInvalidFormat, Message: "validation.email"})
+	}
+	if changes.Role != nil && *changes.Role == "" {
+		errs = append(errs, &ValidationError{Field: "role", Code: apierr.FieldRequired, Message: "validation.role_empty"})
+	}
+	if changes.Locale != nil && *changes.Locale != "" && !i18n.IsSupported(*changes.Locale) {
+		errs = append(errs, localeError())
+	}
+	if changes.Password != nil && *changes.Password == "" {
+		errs = append(errs, &ValidationError{Field: "password", Code: apierr.FieldRequired, Message: "validation.password_empty"})
+	}
+	return errs
+}
+
+// Update validates changes and applies them to user. It returns
+// ValidationErrors listing every invalid field, or ErrVersionConflict if
+// the user changed since it was read. user is left untouched on error.
+func (s *UserService) Update(ctx context.Context, user *models.User, changes UserChanges) error {
+	if errs := ValidateChanges(changes); len(errs) > 0 {
+		return errs
+	}
+
+	before := *user
+	updated := *user
+	if changes.PhoneNumber != nil {
+		updated.PhoneNumber = *changes.PhoneNumber
+	}
+	if changes.Email != nil {
+		updated.Email = *changes.Email
+	}
+	if changes.Role != nil {
+		updated.Role = *changes.Role
+	}
+	if changes.Locale != nil {
+		updated.Locale = *changes.Locale
+	}
+	if changes.Password != nil {
+		hashedPassword, err := auth.HashPassword(ctx, *changes.Password)
+		if err != nil {
+			return err
+		}
+		updated.Password = hashedPassword
+	}
+
+	err := s.transaction(ctx, func(ctx context.Context) error {
+		if err := s.users.Update(ctx, &updated); err != nil {
+			return err
+		}
+		return s.bus.Publish(ctx, events.UserUpdated{Before: before, After: updated})
+	})
+	if err != nil {
+		return err
+	}
+	*user = updated
+	return nil
+}
+
+// AssignRole gives user a new role. It returns ErrVersionConflict if the
+// user changed since it was read.
+func (s *UserService) AssignRole(ctx context.Context, user *models.User, role string) error {
+	before := *user
+	updated := *user
+	updated.Role = role
+	err := s.transaction(ctx, func(ctx context.Context) error {
+		if err := s.users.Update(ctx, &updated); err != nil {
+			return err
+		}
+		return s.bus.Publish(ctx, events.RoleAssigned{Before: before, After: updated})
+	})
+	if err != nil {
+		return err
+	}
+	*user = updated
+	return nil
+}
+
+// Delete soft-deletes user, or erases it if purge is set. It returns
+// ErrVersionConflict if the user changed since it was read.
+func (s *UserService) Delete(ctx context.Context, user *models.User, purge bool) error {
+	return s.transaction(ctx, func(ctx context.Context) error {
+		if err := s.users.Delete(ctx, user, purge); err != nil {
+			return err
+		}
+		return s.bus.Publish(ctx, events.UserDeleted{User: *user, Purged: purge})
+	})
+}
+
+// Restore undoes the soft deletion of user. It returns ErrIdentityTaken if
+// someone has signed up with the same details since.
+func (s *UserService) Restore(ctx context.Context, user *models.User) error {
+	takenPhones, takenEmails, err := s.users.TakenIdentities(ctx, []string{user.PhoneNumber}, []string{user.Email})
+	if err != nil {
+		return err
+	}
+	if len(takenPhones) > 0 || len(takenEmails) > 0 {
+		return ErrIdentityTaken
+	}
+
+	before := *user
+	return s.transaction(ctx, func(ctx context.Context) error {
+		if err := s.users.Restore(ctx, user); err != nil {
+			return err
+		}
+		return s.bus.Publish(ctx, events.UserRestored{Before: before, After: *user})
+	})
+}
+
+// SetDisabled disables user, or enables it again. Disabled users can't
+// log in or use the tokens they already have. It returns
+// ErrVersionConflict if the user changed since it was read.
+func (s *UserService) SetDisabled(ctx context.Context, user *models.User, disabled bool) error {
+	before := *user
+	updated := *user
+	var event events.Event
+	if disabled {
+		now := time.Now()
+		updated.DisabledAt = &now
+		event = events.UserDisabled{Before: before, After: updated}
+	} else {
+		updated.DisabledAt = nil
+		event = events.UserEnabled{Before: before, After: updated}
+	}
+
+	err := s.transaction(ctx, func(ctx context.Context) error {
+		if err := s.users.Update(ctx, &updated); err != nil {
+			return err
+		}
+		return s.bus.Publish(ctx, event)
+	})
+	if err != nil {
+		return err
+	}
+	*user = updated
+	return nil
+}
+
+// PurgeDeleted erases users that were soft-deleted before cutoff, and
+// returns them. Each one is published as purged, like Delete does.
+func (s *UserService) PurgeDeleted(ctx context.Context, cutoff time.Time) ([]models.User, error) {
+	var purged []models.User
+	err := s.transaction(ctx, func(ctx context.Context) error {
+		var err error
+		if purged, err = s.users.PurgeDeletedBefore(ctx, cutoff); err != nil {
+			return err
+		}
+		for _, user := range purged {
+			if err := s.bus.Publish(ctx, events.UserDeleted{User: user, Purged: true}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+
+// Login checks the password of the user with phoneNumber and returns a
+// token for them.
+func (s *UserService) Login(ctx context.Context, phoneNumber, password string) (string, error) {
+	user, err := s.users.FindByPhoneNumber(ctx, phoneNumber)
+	if err != nil {
+		s.publishLogin(ctx, events.LoginFailed{Method: events.MethodPassword, Identifier: phoneNumber})
+		if errors.Is(err, repository.ErrNotFound) {
+			return "", ErrInvalidCredentials
+		}
+		return "", err
+	}
+	if !auth.CheckPasswordHash(ctx, password, user.Password) {
+		s.publishLogin(ctx, events.LoginFailed{User: &user, Method: events.MethodPassword, Identifier: phoneNumber})
+		return "", ErrInvalidCredentials
+	}
+	return s.issueToken(ctx, user, events.MethodPassword, phoneNumber)
+}
+
+// RequestCode stores a new verification code for the user with the phone
+// number (channel sms) or email (channel email) identifier, and sends it.
+func (s *UserService) RequestCode(ctx context.Context, channel, identifier string) error {
+	user, err := s.findByIdentifier(ctx, channel, identifier)
+	if err != nil {
+		return err
+	}
+	if user.DisabledAt != nil {
+		return ErrUserDisabled
+	}
+
+	code := utils.GenerateRandomCode(verificationCodeLength)
+	expiresAt := time.Now().Add(verificationCodeTTL)
+	if channel == events.MethodSMS {
+		user.VerificationCode = code
+		user.VerificationCodeExpiresAt = expiresAt
+	} else {
+		user.EmailVerificationCode = code
+		user.EmailVerificationCodeExpiresAt = expiresAt
+	}
+	if err := s.users.SaveVerificationCodes(ctx, &user); err != nil {
+		return err
+	}
+
+	// Send the code in the user's language, or else in the language of
+	// the request for it.
+	locale := user.Locale
+	if locale == "" {
+		locale = i18n.FromContext(ctx)
+	}
+	return s.bus.Publish(ctx, events.VerificationCodeIssued{
+		User:      user,
+		Channel:   channel,
+		Recipient: identifier,
+		Code:      code,
+		ExpiresAt: expiresAt,
+		Locale:    locale,
+	})
+}
+
+// VerifyCode checks a verification code sent over channel, invalidates it
+// and returns a token for its user.
+func (s *UserService) VerifyCode(ctx context.Context, channel, identifier, code string) (string, error) {
+	user, err := s.findByIdentifier(ctx, channel, identifier)
+	if err != nil {
+		s.publishLogin(ctx, events.LoginFailed{Method: channel, Identifier: identifier})
+		return "", err
+	}
+
+	stored, expiresAt := user.VerificationCode, user.VerificationCodeExpiresAt
+	if channel == events.MethodEmail {
+		stored, expiresAt = user.EmailVerificationCode, user.EmailVerificationCodeExpiresAt
+	}
+	if stored == "" || stored != code || time.Now().After(expiresAt) {
+		metrics.CodeVerified(channel, false)
+		s.publishLogin(ctx, events.LoginFailed{User: &user, Method: channel, Identifier: identifier})
+		return "", ErrInvalidCode
+	}
+	metrics.CodeVerified(channel, true)
+
+	// Invalidate the code
+	if channel == events.MethodSMS {
+		user.VerificationCode = ""
+	} else {
+		user.EmailVerificationCode = ""
+	}
+	err = s.transaction(ctx, func(ctx context.Context) error {
+		if err := s.users.SaveVerificationCodes(ctx, &user); err != nil {
+			return err
+		}
+		return s.bus.Publish(ctx, events.UserVerified{User: user, Channel: channel})
+	})
+	if err != nil {
+		return "", err
+	}
+	return s.issueToken(ctx, user, channel, identifier)
+}
+
+func (s *UserService) findByIdentifier(ctx context.Context, channel, identifier string) (models.User, error) {
+	if channel == events.MethodEmail {
+		return s.users.FindByEmail(ctx, identifier)
+	}
+	return s.users.FindByPhoneNumber(ctx, identifier)
+}
+
+// issueToken returns a token for user, who has proven who they are with
+// method, unless the user is disabled.
+func (s *UserService) issueToken(ctx context.Context, user models.User, method, identifier string) (string, error) {
+	if user.DisabledAt != nil {
+		s.publishLogin(ctx, events.LoginFailed{User: &user, Method: method, Identifier: identifier})
+		return "", ErrUserDisabled
+	}
+	token, err := auth.GenerateJWT(user.ID, user.PhoneNumber, user.Role, user.Locale)
+	if err != nil {
+		metrics.TokenFailed(metrics.TokenIssue)
+		return "", err
+	}
+	metrics.TokenIssued(method)
+	s.publishLogin(ctx, events.UserLoggedIn{User: user, Method: method, Identifier: identifier})
+	return token, nil
+}
+
+// publishLogin publishes a login event. Failing to handle it, such as not
+// being able to record it in the audit log, doesn't fail the login.
+func (s *UserService) publishLogin(ctx context.Context, e events.Event) {
+	if err := s.bus.Publish(ctx, e); err != nil {
+		logging.FromContext(ctx).ErrorContext(ctx, "Failed to publish event", "event", e.EventName(), "error", err)
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"my-project/config"
+	"my-project/internal/auth"
+	"my-project/internal/events"
+	"my-project/internal/models"
+	"my-project/internal/repository"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	auth.InitializeJWT(&config.Config{JWT: config.JWTConfig{Secret: "test-secret"}})
+	os.Exit(m.Run())
+}
+
+// setupService returns a UserService backed by its own in-memory
+// repository, so tests using it can run in parallel.
+func setupService(t *testing.T) (*UserService, *events.Bus) {
+	t.Parallel()
+	bus := events.NewBus()
+	return NewUserService(repository.NewMemoryUserRepository(), bus), bus
+}
+
+func TestCreateAndLogin(t *testing.T) {
+	s, bus := setupService(t)
+	ctx := context.Background()
+
+	var created []events.UserCreated
+	var logins []events.Event
+	events.Subscribe(bus, func(ctx context.Context, e events.UserCreated) error {
+		created = append(created, e)
+		return nil
+	})
+	events.Subscribe(bus, func(ctx context.Context, e events.UserLoggedIn) error {
+		logins = append(logins, e)
+		return nil
+	})
+	events.Subscribe(bus, func(ctx context.Context, e events.LoginFailed) error {
+		logins = append(logins, e)
+		return nil
+	})
+
+	var validationErr *ValidationError
+	err := s.Create(ctx, &models.User{PhoneNumber: "123", Email: "test@example.com", Password: "password"})
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, "phone_number", validationErr.Field)
+
+	user := models.User{PhoneNumber: "09123456789", Email: "test@example.com", Password: "password"}
+	assert.NoError(t, s.Create(ctx, &user))
+	assert.Equal(t, "user", user.Role)
+	assert.Len(t, created, 1)
+
+	_, err = s.Login(ctx, user.PhoneNumber, "wrong")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	token, err := s.Login(ctx, user.PhoneNumber, "password")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token)
+	if assert.Len(t, logins, 2) {
+		assert.IsType(t, events.LoginFailed{}, logins[0])
+		assert.IsType(t, events.UserLoggedIn{}, logins[1])
+	}
+}
+
+func TestUpdateRules(t *testing.T) {
+	s, _ := setupService(t)
+	ctx := context.Background()
+
+	user := models.User{PhoneNumber: "09123456789", Email: "test@example.com", Password: "password"}
+	assert.NoError(t, s.Create(ctx, &user))
+	stale := user
+
+	badEmail, empty := "not-an-email", ""
+	err := s.Update(ctx, &user, UserChanges{Email: &badEmail, Role: &empty})
+	var validationErrs ValidationErrors
+	if assert.True(t, errors.As(err, &validationErrs)) {
+		assert.Len(t, validationErrs, 2)
+	}
+	assert.Equal(t, "test@example.com", user.Email)
+
+	email := "new@example.com"
+	assert.NoError(t, s.Update(ctx, &user, UserChanges{Email: &email}))
+	assert.Equal(t, uint(2), user.Version)
+
+	// The stale copy is at the old version.
+	assert.ErrorIs(t, s.AssignRole(ctx, &stale, "admin"), ErrVersionConflict)
+	assert.Equal(t, "user", stale.Role)
+}
+
+func TestRestoreRules(t *testing.T) {
+	s, _ := setupService(t)
+	ctx := context.Background()
+
+	user := models.User{PhoneNumber: "09123456789", Email: "test@example.com", Password: "password"}
+	assert.NoError(t, s.Create(ctx, &user))
+	assert.NoError(t, s.Delete(ctx, &user, false))
+
+	// Someone signs up again with the same phone number.
+	other := models.User{PhoneNumber: user.PhoneNumber, Email: "other@example.com", Password: "password"}
+	assert.NoError(t, s.Create(ctx, &other))
+
+	deleted, err := s.GetDeleted(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, s.Restore(ctx, &deleted), ErrIdentityTaken)
+
+	assert.NoError(t, s.Delete(ctx, &other, true))
+	assert.NoError(t, s.Restore(ctx, &deleted))
+	_, err = s.Get(ctx, user.ID)
+	assert.NoError(t, err)
+}
+
+func TestVerifyCode(t *testing.T) {
+	s, bus := setupService(t)
+	ctx := context.Background()
+
+	var code string
+	events.Subscribe(bus, func(ctx context.Context, e events.VerificationCodeIssued) error {
+		code = e.Code
+		return nil
+	})
+
+	user := models.User{PhoneNumber: "09123456789", Email: "test@example.com", Password: "password"}
+	assert.NoError(t, s.Create(ctx, &user))
+
+	assert.ErrorIs(t, s.RequestCode(ctx, events.MethodEmail, "missing@example.com"), ErrUserNotFound)
+	assert.NoError(t, s.RequestCode(ctx, events.MethodEmail, user.Email))
+	assert.Len(t, code, verificationCodeLength)
+
+	_, err := s.VerifyCode(ctx, events.MethodEmail, user.Email, "000000x")
+	assert.ErrorIs(t, err, ErrInvalidCode)
+	token, err := s.VerifyCode(ctx, events.MethodEmail, user.Email, code)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token)
+
+	// Codes can only be used once.
+	_, err = s.VerifyCode(ctx, events.MethodEmail, user.Email, code)
+	assert.ErrorIs(t, err, ErrInvalidCode)
+}
+
+func TestImportStopsWhenCancelled(t *testing.T) {
+	s, _ := setupService(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var users []*models.User
+	for i := range 20 {
+		users = append(users, &models.User{
+			PhoneNumber: fmt.Sprintf("0912000%04d", i),
+			Email:       fmt.Sprintf("user-%d@example.com", i),
+			Password:    "password",
+		})
+	}
+	assert.ErrorIs(t, s.Import(ctx, users), context.Canceled)
+
+	// Nothing was hashed, let alone stored.
+	for _, user := range users {
+		assert.Equal(t, "password", user.Password)
+	}
+	stored, err := s.List(context.Background(), repository.UserFilter{})
+	assert.NoError(t, err)
+	assert.Empty(t, stored)
+}
+
+func TestFailedSubscriberRollsBack(t *testing.T) {
+	s, bus := setupService(t)
+	ctx := context.Background()
+
+	committed := make(chan events.UserCreated, 1)
+	events.Subscribe(bus, func(ctx context.Context, e events.UserCreated) error {
+		return errors.New("audit log unavailable")
+	})
+	events.SubscribeAsync(bus, func(ctx context.Context, e events.UserCreated) {
+		committed <- e
+	})
+
+	user := models.User{PhoneNumber: "09123456789", Email: "test@example.com", Password: "password"}
+	assert.Error(t, s.Create(ctx, &user))
+	bus.Wait()
+
+	users, err := s.List(ctx, repository.UserFilter{})
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+	assert.Empty(t, committed)
+}
+
+func TestDisabledUserCannotLogIn(t *testing.T) {
+	s, _ := setupService(t)
+	ctx := context.Background()
+
+	user := models.User{PhoneNumber: "09123456789", Email: "test@example.com", Password: "password"}
+	assert.NoError(t, s.Create(ctx, &user))
+	assert.NoError(t, s.SetDisabled(ctx, &user, true))
+	assert.NotNil(t, user.DisabledAt)
+
+	_, err := s.Login(ctx, user.PhoneNumber, "password")
+	assert.ErrorIs(t, err, ErrUserDisabled)
+	// A wrong password doesn't reveal that the account is disabled.
+	_, err = s.Login(ctx, user.PhoneNumber, "wrong")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	assert.ErrorIs(t, s.RequestCode(ctx, events.MethodSMS, user.PhoneNumber), ErrUserDisabled)
+
+	assert.NoError(t, s.SetDisabled(ctx, &user, false))
+	_, err = s.Login(ctx, user.PhoneNumber, "password")
+	assert.NoError(t, err)
+}
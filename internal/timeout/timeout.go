@@ -0,0 +1,64 @@
+// Package timeout bounds how long requests can run, so slow queries and
+// provider calls don't pile up behind clients that gave up long ago.
+package timeout
+
+import (
+	"context"
+	"errors"
+	"my-project/internal/apierr"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware cancels the context of requests after d, or after the timeout
+// of their route in routes, keyed by path as registered, such as
+// "/api/v1/users/export". A zero timeout means no limit.
+//
+// Everything the handlers do with the request context, such as database
+// queries and provider calls, is cancelled with it. If the request then
+// fails with a server error, it gets a 504 instead.
+func Middleware(d time.Duration, routes map[string]time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d := d
+		if route, ok := routes[c.FullPath()]; ok {
+			d = route
+		}
+		if d <= 0 {
+			c.Next()
+			return
+		}
+
+		parent := c.Request.Context()
+		ctx, cancel := context.WithTimeout(parent, d)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		// Middleware before this one sees whether the client is still
+		// there, not this timeout, but keeps what was added to the context
+		// since, such as the locale and log attributes of the user.
+		c.Request = c.Request.WithContext(cancelledWith{c.Request.Context(), parent})
+
+		if len(c.Errors) == 0 || c.Writer.Written() || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return
+		}
+		err := c.Errors.Last().Err
+		var apiErr *apierr.Error
+		if errors.As(err, &apiErr) && apiErr.Status < http.StatusInternalServerError {
+			return
+		}
+		c.Error(apierr.Timeout(err, d))
+	}
+}
+
+// cancelledWith is a context with the values of Context and the deadline
+// and cancellation of parent.
+type cancelledWith struct {
+	context.Context
+	parent context.Context
+}
+
+func (c cancelledWith) Deadline() (time.Time, bool) { return c.parent.Deadline() }
+func (c cancelledWith) Done() <-chan struct{}       { return c.parent.Done() }
+func (c cancelledWith) Err() error                  { return c.parent.Err() }
@@ -0,0 +1,122 @@
+package timeout
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"my-project/config"
+	"my-project/internal/apierr"
+	"my-project/internal/auth"
+	"my-project/internal/i18n"
+	"my-project/internal/logging"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slow waits until the request is cancelled and fails like a query would.
+func slow(c *gin.Context) {
+	<-c.Request.Context().Done()
+	c.Error(apierr.Internal(c.Request.Context().Err(), "error.unexpected"))
+}
+
+func setupRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(apierr.Middleware(), Middleware(10*time.Millisecond, map[string]time.Duration{
+		"/export": time.Second,
+		"/stream": 0,
+	}))
+	r.GET("/users", slow)
+	r.GET("/missing", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+		c.Error(apierr.New(http.StatusNotFound, apierr.CodeUserNotFound, "user.not_found"))
+	})
+	r.GET("/export", func(c *gin.Context) {
+		deadline, ok := c.Request.Context().Deadline()
+		require.True(t, ok)
+		assert.Greater(t, time.Until(deadline), 500*time.Millisecond)
+		c.Status(http.StatusOK)
+	})
+	r.GET("/stream", func(c *gin.Context) {
+		_, ok := c.Request.Context().Deadline()
+		assert.False(t, ok)
+		c.Status(http.StatusOK)
+	})
+	return r
+}
+
+func serve(r *gin.Engine, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	r := setupRouter(t)
+
+	w := serve(r, httptest.NewRequest(http.MethodGet, "/users", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	var problem apierr.Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, apierr.CodeTimeout, problem.Code)
+	assert.Equal(t, "The request took longer than 10ms and was cancelled", problem.Detail)
+
+	// Client errors are kept.
+	w = serve(r, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Routes can have their own timeout, or none.
+	w = serve(r, httptest.NewRequest(http.MethodGet, "/export", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(r, httptest.NewRequest(http.MethodGet, "/stream", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestClientGone(t *testing.T) {
+	r := setupRouter(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := serve(r, httptest.NewRequest(http.MethodGet, "/users", nil).WithContext(ctx))
+	assert.Equal(t, apierr.StatusClientClosedRequest, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+// activeUsers has every user active.
+type activeUsers struct{}
+
+func (activeUsers) Active(ctx context.Context, id uint) (bool, error) { return true, nil }
+
+func TestAuthenticatedTimeout(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(logging.NewHandler(&buf, slog.LevelInfo, true)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+	auth.InitializeJWT(&config.Config{JWT: config.JWTConfig{Secret: "test-secret"}})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(logging.Middleware(), i18n.Middleware(), apierr.Middleware(), Middleware(10*time.Millisecond, nil))
+	r.GET("/users", auth.AuthMiddleware(activeUsers{}), slow)
+
+	// The error is in the locale of the user, not of the client, and the
+	// request is logged with the user.
+	token, err := auth.GenerateJWT(7, "09121111111", "admin", i18n.Persian)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept-Language", "en")
+	w := serve(r, req)
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	var problem apierr.Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, "درخواست بیش از 10ms طول کشید و لغو شد", problem.Detail)
+	assert.Contains(t, buf.String(), `"user_id":"7"`)
+}
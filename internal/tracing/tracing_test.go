@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"my-project/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func record(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { provider.Shutdown(t.Context()) })
+	return recorder
+}
+
+func TestMiddlewareContinuesTrace(t *testing.T) {
+	recorder := record(t)
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, InstrumentDB(db))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/users/:id", func(c *gin.Context) {
+		var n int
+		db.WithContext(c.Request.Context()).Raw("SELECT 1").Scan(&n)
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/7", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	query, request := spans[0], spans[1]
+
+	assert.Equal(t, "GET /users/:id", request.Name())
+	assert.Equal(t, trace.SpanKindServer, request.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", request.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", request.Parent().SpanID().String())
+
+	assert.Equal(t, "gorm.row", query.Name())
+	assert.Equal(t, request.SpanContext().SpanID(), query.Parent().SpanID())
+}
+
+func TestSetupWritesSpansToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := Setup(t.Context(), &config.Config{Tracing: config.TracingConfig{Exporter: "stdout", File: path, SampleRatio: 1}})
+	require.NoError(t, err)
+	_, span := otel.Tracer("test").Start(t.Context(), "login")
+	span.End()
+	require.NoError(t, shutdown(t.Context()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"Name":"login"`)
+
+	_, err = Setup(t.Context(), &config.Config{Tracing: config.TracingConfig{Exporter: "zipkin"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,310 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"my-project/internal/database"
+	"my-project/internal/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	batchSize = 100
+	// claimLease is how long a claimed delivery is hidden from other
+	// dispatchers. It is renewed right before the delivery is sent, so it
+	// only needs to outlast a single request, not the whole batch.
+	claimLease = 2 * time.Minute
+	// maxErrorLength bounds the response snippet kept in the delivery log.
+	maxErrorLength = 512
+)
+
+// Dispatcher fans outbox events out to subscriptions and sends the
+// resulting deliveries, retrying failures with exponential backoff.
+type Dispatcher struct {
+	DB           *gorm.DB
+	Client       *http.Client
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	PollInterval time.Duration
+}
+
+// NewDispatcher returns a Dispatcher with default settings.
+func NewDispatcher(db *gorm.DB, maxAttempts int) *Dispatcher {
+	return &Dispatcher{
+		DB:           db,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts:  maxAttempts,
+		BaseBackoff:  30 * time.Second,
+		MaxBackoff:   6 * time.Hour,
+		PollInterval: 5 * time.Second,
+	}
+}
+
+// Run dispatches events every PollInterval until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.RunOnce(ctx); err != nil {
+			slog.Error("Failed to dispatch webhooks", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce fans out pending outbox events and sends every delivery that is due.
+func (d *Dispatcher) RunOnce(ctx context.Context) error {
+	if err := d.fanOut(ctx); err != nil {
+		return err
+	}
+	deliveries, err := d.claimDue(ctx)
+	if err != nil {
+		return err
+	}
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := d.deliver(ctx, delivery); err != nil {
+			slog.Error("Failed to record webhook delivery", "delivery_id", delivery.ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// fanOut creates a delivery for every active subscription interested in
+// each undispatched outbox event, and marks the events dispatched.
+func (d *Dispatcher) fanOut(ctx context.Context) error {
+	return d.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var events []models.OutboxEvent
+		if err := lockForUpdate(tx).
+			Where("dispatched_at IS NULL").
+			Order("id").Limit(batchSize).
+			Find(&events).Error; err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		var subscriptions []models.WebhookSubscription
+		if err := tx.Where("active = ?", true).Find(&subscriptions).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, event := range events {
+			for _, subscription := range subscriptions {
+				if !subscription.Subscribes(event.EventType) {
+					continue
+				}
+				delivery := models.WebhookDelivery{
+					SubscriptionID: subscription.ID,
+					OutboxEventID:  event.ID,
+					EventType:      event.EventType,
+					Status:         models.DeliveryPending,
+					NextAttemptAt:  now,
+				}
+				if err := tx.Create(&delivery).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Model(&event).Update("dispatched_at", now).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// claimDue picks the pending deliveries that are due and pushes their next
+// attempt out by claimLease, so concurrent dispatchers don't send them too.
+func (d *Dispatcher) claimDue(ctx context.Context) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := d.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		lease := leaseUntil(now)
+		if err := lockForUpdate(tx).
+			Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, now).
+			Order("next_attempt_at").Limit(batchSize).
+			Find(&deliveries).Error; err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+		ids := make([]uint, len(deliveries))
+		for i := range deliveries {
+			ids[i] = deliveries[i].ID
+			deliveries[i].NextAttemptAt = lease
+		}
+		return tx.Model(&models.WebhookDelivery{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", lease).Error
+	})
+	return deliveries, err
+}
+
+// renewClaim extends the lease on a claimed delivery. It reports false if
+// the lease ran out while earlier deliveries of the batch were sent and
+// the delivery was claimed by another dispatcher, or otherwise changed.
+func (d *Dispatcher) renewClaim(ctx context.Context, delivery *models.WebhookDelivery) (bool, error) {
+	lease := leaseUntil(time.Now())
+	result := d.DB.WithContext(ctx).Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ? AND next_attempt_at = ?", delivery.ID, models.DeliveryPending, delivery.NextAttemptAt).
+		Update("next_attempt_at", lease)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	delivery.NextAttemptAt = lease
+	return true, nil
+}
+
+// leaseUntil returns when a lease taken at now runs out, to the microsecond
+// Postgres keeps, so renewClaim can tell its own lease apart.
+func leaseUntil(now time.Time) time.Time {
+	return now.Add(claimLease).Truncate(time.Microsecond)
+}
+
+// deliver sends a single delivery, unless another dispatcher has claimed it
+// since, and records the outcome. The outcome is
+// recorded even if ctx is cancelled meanwhile, so a request that was sent
+// is never sent again because of a shutdown.
+func (d *Dispatcher) deliver(ctx context.Context, delivery models.WebhookDelivery) error {
+	if claimed, err := d.renewClaim(ctx, &delivery); !claimed {
+		return err
+	}
+	db := d.DB.WithContext(ctx)
+	var subscription models.WebhookSubscription
+	var event models.OutboxEvent
+	if err := db.First(&subscription, delivery.SubscriptionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// The subscription was removed after the delivery was created.
+			return db.Model(&delivery).Updates(map[string]interface{}{
+				"status":     models.DeliveryDead,
+				"last_error": "subscription no longer exists",
+			}).Error
+		}
+		return err
+	}
+	if err := db.First(&event, delivery.OutboxEventID).Error; err != nil {
+		return err
+	}
+
+	start := time.Now()
+	statusCode, sendErr := d.send(ctx, subscription, event, delivery)
+	attempt := models.WebhookDeliveryAttempt{
+		DeliveryID: delivery.ID,
+		StatusCode: statusCode,
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+
+	delivery.Attempts++
+	delivery.LastStatusCode = statusCode
+	delivery.LastError = ""
+	if sendErr != nil {
+		attempt.Error = truncate(sendErr.Error(), maxErrorLength)
+		delivery.LastError = attempt.Error
+		if delivery.Attempts >= d.MaxAttempts {
+			delivery.Status = models.DeliveryDead
+		} else {
+			delivery.NextAttemptAt = time.Now().Add(d.backoff(delivery.Attempts))
+		}
+	} else {
+		now := time.Now()
+		delivery.Status = models.DeliverySucceeded
+		delivery.DeliveredAt = &now
+	}
+
+	return d.DB.WithContext(context.WithoutCancel(ctx)).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&attempt).Error; err != nil {
+			return err
+		}
+		return tx.Model(&delivery).Select(
+			"status", "attempts", "next_attempt_at", "last_status_code", "last_error", "delivered_at",
+		).Updates(&delivery).Error
+	})
+}
+
+// send makes the HTTP request for a delivery. Any response outside 2xx is
+// treated as a failure.
+func (d *Dispatcher) send(ctx context.Context, subscription models.WebhookSubscription, event models.OutboxEvent, delivery models.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(Envelope{
+		ID:         event.ID,
+		Type:       event.EventType,
+		OccurredAt: event.CreatedAt.UTC(),
+		Data:       json.RawMessage(event.Payload),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, event.EventType)
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(subscription.Secret, timestamp, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorLength))
+		return resp.StatusCode, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, snippet)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before retrying after the given number of
+// failed attempts: BaseBackoff doubled per attempt, capped at MaxBackoff,
+// with up to 20% jitter so retries from an outage don't arrive in lockstep.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.BaseBackoff
+	for i := 1; i < attempts && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, d.MaxBackoff)
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+// lockForUpdate locks the selected rows on Postgres, skipping rows another
+// dispatcher already holds. SQLite serializes writers, so it needs nothing.
+func lockForUpdate(tx *gorm.DB) *gorm.DB {
+	if database.IsPostgres(tx) {
+		return tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+	}
+	return tx
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
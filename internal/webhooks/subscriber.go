@@ -0,0 +1,43 @@
+package webhooks
+
+import (
+	"context"
+	"my-project/internal/database"
+	"my-project/internal/events"
+	"my-project/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Subscribe writes user events to the webhook outbox. The subscribers are
+// synchronous, so an event is stored in the transaction that published it
+// and is only delivered if that transaction commits.
+func Subscribe(bus *events.Bus, db *gorm.DB) {
+	events.Subscribe(bus, func(ctx context.Context, e events.UserCreated) error {
+		return Enqueue(database.Conn(ctx, db), EventUserCreated, NewUserPayload(e.User))
+	})
+	events.Subscribe(bus, func(ctx context.Context, e events.UserVerified) error {
+		payload := VerifiedPayload{UserPayload: NewUserPayload(e.User), Channel: e.Channel}
+		return Enqueue(database.Conn(ctx, db), EventUserVerified, payload)
+	})
+	events.Subscribe(bus, func(ctx context.Context, e events.UserUpdated) error {
+		return enqueueRoleChange(database.Conn(ctx, db), e.Before, e.After)
+	})
+	events.Subscribe(bus, func(ctx context.Context, e events.RoleAssigned) error {
+		return enqueueRoleChange(database.Conn(ctx, db), e.Before, e.After)
+	})
+	events.Subscribe(bus, func(ctx context.Context, e events.UserDeleted) error {
+		payload := DeletedPayload{UserPayload: NewUserPayload(e.User), Purged: e.Purged}
+		return Enqueue(database.Conn(ctx, db), EventUserDeleted, payload)
+	})
+}
+
+// enqueueRoleChange emits a user.role_changed event if the role of the user
+// differs between before and after.
+func enqueueRoleChange(tx *gorm.DB, before, after models.User) error {
+	if before.Role == after.Role {
+		return nil
+	}
+	payload := RoleChangedPayload{UserPayload: NewUserPayload(after), PreviousRole: before.Role}
+	return Enqueue(tx, EventUserRoleChanged, payload)
+}
@@ -0,0 +1,100 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"my-project/internal/models"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Event types delivered to webhook subscriptions.
+const (
+	EventUserCreated     = "user.created"
+	EventUserVerified    = "user.verified"
+	EventUserRoleChanged = "user.role_changed"
+	EventUserDeleted     = "user.deleted"
+)
+
+// EventTypes lists every event type a subscription can ask for.
+var EventTypes = []string{EventUserCreated, EventUserVerified, EventUserRoleChanged, EventUserDeleted}
+
+// Headers set on every delivery.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Envelope is the JSON body of a delivery.
+type Envelope struct {
+	ID         uint            `json:"id"`
+	Type       string          `json:"type"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// Enqueue writes an event to the outbox. tx should be the transaction
+// making the change the event describes, so the event is stored if and
+// only if the change commits.
+func Enqueue(tx *gorm.DB, eventType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&models.OutboxEvent{EventType: eventType, Payload: string(payload)}).Error
+}
+
+// Sign returns the signature header value for a delivery body sent at
+// timestamp. Receivers recompute it with their copy of the secret. The
+// timestamp is signed too so old deliveries can't be replayed.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// UserPayload is the data of the user events.
+type UserPayload struct {
+	ID          uint   `json:"id"`
+	PhoneNumber string `json:"phone_number"`
+	Email       string `json:"email"`
+	Role        string `json:"role"`
+}
+
+// NewUserPayload returns the event data describing user.
+func NewUserPayload(user models.User) UserPayload {
+	return UserPayload{
+		ID:          user.ID,
+		PhoneNumber: user.PhoneNumber,
+		Email:       user.Email,
+		Role:        user.Role,
+	}
+}
+
+// VerifiedPayload is the data of a user.verified event.
+type VerifiedPayload struct {
+	UserPayload
+	// Channel is how the user proved they own their contact details: sms or email.
+	Channel string `json:"channel"`
+}
+
+// RoleChangedPayload is the data of a user.role_changed event.
+type RoleChangedPayload struct {
+	UserPayload
+	PreviousRole string `json:"previous_role"`
+}
+
+// DeletedPayload is the data of a user.deleted event.
+type DeletedPayload struct {
+	UserPayload
+	// Purged is true when the user was erased rather than soft-deleted.
+	Purged bool `json:"purged"`
+}
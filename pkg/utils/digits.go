@@ -0,0 +1,16 @@
+package utils
+
+import "strings"
+
+// NormalizeDigits converts Persian (۰-۹) and Arabic-Indic (٠-٩) digits to their ASCII equivalents.
+func NormalizeDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= '۰' && r <= '۹':
+			return '0' + (r - '۰')
+		case r >= '٠' && r <= '٩':
+			return '0' + (r - '٠')
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,9 @@
+package validators
+
+import "net/mail"
+
+// ValidateEmail checks if an email is a plain address such as user@example.com.
+func ValidateEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}